@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/devopstools/backend/internal/logger"
@@ -36,6 +42,9 @@ func main() {
 
 	app := fiber.New(fiber.Config{
 		AppName: "DevOps Tools API v2.0",
+		// Params, queries and headers are kept by services (schedules,
+		// webhook deliveries) after the request ends
+		Immutable: true,
 	})
 
 	// Middleware
@@ -285,11 +294,89 @@ func main() {
 		}
 	})
 
+	// Initialize command queue; ad-hoc commands and queues share its workers
+	cmdQueue := services.NewCommandQueue(cmdService, envInt("QUEUE_WORKERS", 5))
+
+	// Named locks shared by commands and workflow runs
+	lockManager := services.NewLockManager()
+	cmdQueue.SetLockManager(lockManager)
+	if err := cmdQueue.SetLimits(models.QueueLimits{
+		MaxQueuedTotal:       envInt("QUEUE_MAX_SIZE", 100),
+		MaxQueuedPerUser:     envInt("QUEUE_MAX_QUEUED_PER_USER", 0),
+		MaxConcurrentPerUser: envInt("QUEUE_MAX_CONCURRENT_PER_USER", 0),
+	}); err != nil {
+		log.Fatalf("Invalid queue limits: %v", err)
+	}
+	cmdQueue.SetProgressCallback(func(progress models.CommandProgress) {
+		// Save finished commands to history
+		if progress.Step == "completed" && progress.ExecutionID != "" {
+			if exec, err := cmdService.GetExecution(progress.ExecutionID); err == nil {
+				store.SaveCommandHistory(models.CommandHistory{
+					UserID:      exec.UserID,
+					Command:     exec.Command,
+					FullCommand: exec.Command + " " + strings.Join(exec.Args, " "),
+					Status:      exec.Status,
+					Output:      exec.Output,
+					Error:       exec.Error,
+					ExitCode:    exec.ExitCode,
+					Duration:    exec.Duration,
+					Timestamp:   exec.StartedAt,
+				})
+			}
+		}
+
+		clientsMu.Lock()
+		defer clientsMu.Unlock()
+
+		msg, _ := json.Marshal(fiber.Map{
+			"type":     "command_progress",
+			"progress": progress,
+		})
+
+		for client := range clients {
+			if err := client.WriteMessage(websocket.TextMessage, msg); err != nil {
+				log.Printf("❌ Failed to send progress: %v", err)
+			}
+		}
+	})
+
+	// queueError maps queue admission errors to HTTP status codes
+	queueError := func(c *fiber.Ctx, err error) error {
+		var limitErr *services.QueueLimitError
+		switch {
+		case errors.As(err, &limitErr):
+			return c.Status(429).JSON(fiber.Map{"error": err.Error(), "limit": limitErr.Limit, "max": limitErr.Max})
+		case errors.Is(err, services.ErrQueueClosed):
+			return c.Status(503).JSON(fiber.Map{"error": err.Error()})
+		default:
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+
 	api.Post("/commands/execute", func(c *fiber.Ctx) error {
+		var req models.BatchCommand
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+
+		// Interactive commands jump ahead of queued background work by default
+		if req.Priority == "" {
+			req.Priority = services.PriorityHigh
+		}
+
+		userID := "dev-user-id"
+		execution, err := cmdQueue.Enqueue(context.Background(), userID, req)
+		if err != nil {
+			return queueError(c, err)
+		}
+
+		return c.Status(202).JSON(queuedExecution{execution, cmdQueue.Position(execution.ID)})
+	})
+
+	api.Post("/commands/batch", func(c *fiber.Ctx) error {
 		var req struct {
-			Command string   `json:"command"`
-			Args    []string `json:"args"`
-			WorkDir string   `json:"work_dir"`
+			Commands []models.BatchCommand `json:"commands"`
+			Priority string                `json:"priority"` // default for commands without one
 		}
 
 		if err := c.BodyParser(&req); err != nil {
@@ -297,44 +384,20 @@ func main() {
 		}
 
 		userID := "dev-user-id"
-		ctx := c.Context()
-
-		execution, err := cmdService.Execute(ctx, userID, req.Command, req.Args, req.WorkDir)
+		batch, err := cmdQueue.EnqueueBatch(context.Background(), userID, req.Commands, req.Priority)
 		if err != nil {
-			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+			return queueError(c, err)
 		}
 
-		// Save to history (async)
-		go func() {
-			// Wait for execution to complete
-			time.Sleep(100 * time.Millisecond)
-			exec, _ := cmdService.GetExecution(execution.ID)
-			if exec != nil && exec.Status != "pending" && exec.Status != "running" {
-				history := models.CommandHistory{
-					UserID:      userID,
-					Command:     exec.Command,
-					FullCommand: exec.Command + " " + strings.Join(exec.Args, " "),
-					Status:      exec.Status,
-					Output:      exec.Output,
-					Error:       exec.Error,
-					ExitCode:    exec.ExitCode,
-					Duration:    exec.Duration,
-					Timestamp:   exec.StartedAt,
-				}
-				store.SaveCommandHistory(history)
-			}
-		}()
-
-		return c.Status(202).JSON(execution)
+		return c.Status(202).JSON(batchStatus(batch, cmdService, cmdQueue))
 	})
 
-	api.Get("/commands/:id", func(c *fiber.Ctx) error {
-		id := c.Params("id")
-		execution, err := cmdService.GetExecution(id)
+	api.Get("/commands/batch/:id", func(c *fiber.Ctx) error {
+		batch, err := cmdQueue.GetBatch(c.Params("id"))
 		if err != nil {
-			return c.Status(404).JSON(fiber.Map{"error": "Execution not found"})
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
 		}
-		return c.JSON(execution)
+		return c.JSON(batchStatus(batch, cmdService, cmdQueue))
 	})
 
 	api.Get("/commands/history", func(c *fiber.Ctx) error {
@@ -348,29 +411,23 @@ func main() {
 		return c.JSON(history)
 	})
 
-	// Initialize command queue
-	cmdQueue := services.NewCommandQueue(cmdService, 5) // Max 5 concurrent
-	cmdQueue.SetProgressCallback(func(progress models.CommandProgress) {
-		clientsMu.Lock()
-		defer clientsMu.Unlock()
-
-		msg, _ := json.Marshal(fiber.Map{
-			"type":     "command_progress",
-			"progress": progress,
-		})
-
-		for client := range clients {
-			if err := client.WriteMessage(websocket.TextMessage, msg); err != nil {
-				log.Printf("❌ Failed to send progress: %v", err)
-			}
+	api.Get("/commands/:id", func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		execution, err := cmdService.GetExecution(id)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Execution not found"})
 		}
+		return c.JSON(queuedExecution{execution, cmdQueue.Position(id)})
 	})
 
 	// Queue endpoints
 	api.Post("/queue", func(c *fiber.Ctx) error {
 		var req struct {
-			Name     string   `json:"name"`
-			Commands []string `json:"commands"`
+			Name     string                 `json:"name"`
+			Commands []string               `json:"commands"`
+			Priority string                 `json:"priority"`
+			Retry    *models.CommandRetry   `json:"retry"`
+			Timeout  *models.CommandTimeout `json:"timeout"`
 		}
 
 		if err := c.BodyParser(&req); err != nil {
@@ -378,14 +435,30 @@ func main() {
 		}
 
 		userID := "dev-user-id"
-		queue, err := cmdQueue.CreateQueue(userID, req.Name, req.Commands)
+		queue, err := cmdQueue.CreateQueue(userID, req.Name, req.Commands, req.Priority, req.Retry, req.Timeout)
 		if err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 		}
 
 		return c.Status(201).JSON(queue)
 	})
 
+	// Queue fairness limits
+	api.Get("/queue/limits", func(c *fiber.Ctx) error {
+		return c.JSON(cmdQueue.GetLimits())
+	})
+
+	api.Put("/queue/limits", func(c *fiber.Ctx) error {
+		var limits models.QueueLimits
+		if err := c.BodyParser(&limits); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := cmdQueue.SetLimits(limits); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(limits)
+	})
+
 	api.Get("/queue/:id", func(c *fiber.Ctx) error {
 		id := c.Params("id")
 		queue, err := cmdQueue.GetQueue(id)
@@ -397,17 +470,80 @@ func main() {
 
 	api.Post("/queue/:id/execute", func(c *fiber.Ctx) error {
 		id := c.Params("id")
-		ctx := c.Context()
-
-		go func() {
-			if err := cmdQueue.ExecuteQueue(ctx, id); err != nil {
-				logger.Error("Failed to execute queue", err)
-			}
-		}()
+		if err := cmdQueue.StartQueue(id); err != nil {
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		}
 
 		return c.JSON(fiber.Map{"status": "started"})
 	})
 
+	// Queue control
+	api.Post("/queue/:id/pause", func(c *fiber.Ctx) error {
+		if err := cmdQueue.PauseQueue(c.Params("id")); err != nil {
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"status": "pausing"})
+	})
+
+	api.Post("/queue/:id/resume", func(c *fiber.Ctx) error {
+		if err := cmdQueue.ResumeQueue(c.Params("id")); err != nil {
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"status": "running"})
+	})
+
+	api.Post("/queue/:id/cancel", func(c *fiber.Ctx) error {
+		if err := cmdQueue.CancelQueue(c.Params("id")); err != nil {
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"status": "cancelled"})
+	})
+
+	api.Post("/queue/:id/items/:itemId/skip", func(c *fiber.Ctx) error {
+		if err := cmdQueue.SkipItem(c.Params("id"), c.Params("itemId")); err != nil {
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"status": "skipped"})
+	})
+
+	api.Put("/queue/:id/order", func(c *fiber.Ctx) error {
+		var req struct {
+			ItemIDs []string `json:"item_ids"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid request"})
+		}
+
+		id := c.Params("id")
+		if err := cmdQueue.ReorderItems(id, req.ItemIDs); err != nil {
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		queue, err := cmdQueue.GetQueue(id)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": "Queue not found"})
+		}
+		return c.JSON(queue)
+	})
+
+	// Locks API (admin)
+	api.Get("/admin/locks", func(c *fiber.Ctx) error {
+		return c.JSON(lockManager.List())
+	})
+
+	// Lock names contain ':' and '/', so the name is a query parameter
+	api.Delete("/admin/locks", func(c *fiber.Ctx) error {
+		name := c.Query("name")
+		if name == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "name is required"})
+		}
+		lock, err := lockManager.ForceRelease(name)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"released": lock})
+	})
+
 	// Metrics endpoint
 	api.Get("/metrics", func(c *fiber.Ctx) error {
 		snapshot := metricsCollector.GetSnapshot()
@@ -831,8 +967,119 @@ func main() {
 		log.Fatalf("Failed to initialize variable service: %v", err)
 	}
 
+	// Secret variables are encrypted with a key derived from SECRETS_KEY, or
+	// with a generated key kept in ./data when it is not set
+	secretsKey := os.Getenv("SECRETS_KEY")
+	if secretsKey == "" {
+		logger.Warn("SECRETS_KEY not set, secret variables use the generated key in ./data/secrets.key")
+	}
+	encryptionKey, err := services.LoadSecretKey("./data", secretsKey)
+	if err != nil {
+		log.Fatalf("Failed to load secrets key: %v", err)
+	}
+	variableService.SetEncryptionKey(encryptionKey)
+	os.Unsetenv("SECRETS_KEY") // keep it out of commands and {env:...} lookups
+
+	workflowValidator := services.NewWorkflowValidator(workflowStore, variableService)
+	workflowStore.SetValidator(workflowValidator)
+
+	// Workflows kept under version control are loaded at startup
+	workflowsDir := os.Getenv("WORKFLOWS_DIR")
+	if workflowsDir != "" {
+		loaded, err := workflowStore.LoadDirectory(workflowsDir)
+		if err != nil {
+			log.Fatalf("Failed to load workflows from %s: %v", workflowsDir, err)
+		}
+		logger.Info("Loaded workflows from directory", logger.WithFields(map[string]interface{}{
+			"dir":       workflowsDir,
+			"workflows": len(loaded),
+		}).Data)
+	}
+
 	workflowExecutor := services.NewWorkflowExecutor(workflowStore, variableService)
 
+	runStore, err := services.NewRunStore("./data/runs", models.RunRetention{
+		MaxRunsPerWorkflow:  envInt("WORKFLOW_RUNS_MAX_PER_WORKFLOW", 50),
+		MaxAgeDays:          envInt("WORKFLOW_RUNS_MAX_AGE_DAYS", 30),
+		ArtifactMaxAgeDays:  envInt("WORKFLOW_ARTIFACTS_MAX_AGE_DAYS", 14),
+		ArtifactMaxRunBytes: int64(envInt("WORKFLOW_ARTIFACTS_MAX_RUN_MB", 100)) << 20,
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize workflow run store: %v", err)
+	}
+	workflowExecutor.SetRunStore(runStore)
+	workflowExecutor.SetLockManager(lockManager)
+	workflowExecutor.SetStepServices(services.StepServices{
+		Network:   networkService,
+		Terraform: terraformService,
+		ArgoCD:    argoCDService,
+		AWS:       awsBrowserService,
+	})
+
+	// Approval gates notify approvers over the WebSocket
+	approvalService := services.NewApprovalService()
+	approvalService.SetChangeCallback(func(request models.ApprovalRequest) {
+		msgType := "approval_decided"
+		if request.Status == "pending" {
+			msgType = "approval_requested"
+			logger.Info("Approval requested", logger.WithFields(map[string]interface{}{
+				"approval_id": request.ID,
+				"run_id":      request.RunID,
+				"step_id":     request.StepID,
+				"approvers":   request.Approvers,
+			}).Data)
+		}
+
+		clientsMu.Lock()
+		defer clientsMu.Unlock()
+
+		msg, _ := json.Marshal(fiber.Map{
+			"type":     msgType,
+			"approval": request,
+		})
+		for client := range clients {
+			if err := client.WriteMessage(websocket.TextMessage, msg); err != nil {
+				log.Printf("❌ Failed to send approval: %v", err)
+			}
+		}
+	})
+	workflowExecutor.SetApprovalService(approvalService)
+
+	// Notification rules are matched against finished runs, commands and
+	// scheduled activations
+	notificationService, err := services.NewNotificationService("./data", encryptionKey)
+	if err != nil {
+		log.Fatalf("Failed to initialize notifications: %v", err)
+	}
+	workflowExecutor.SetFinishCallback(func(run models.WorkflowExecution) {
+		notificationService.Notify(services.WorkflowRunEvent(run))
+	})
+	cmdService.SetFinishCallback(func(execution models.CommandExecution) {
+		notificationService.Notify(services.CommandEvent(execution))
+	})
+
+	schedulerService, err := services.NewSchedulerService("./data", workflowStore, workflowExecutor, cmdQueue)
+	if err != nil {
+		log.Fatalf("Failed to initialize scheduler: %v", err)
+	}
+	schedulerService.SetRunEndCallback(func(schedule models.Schedule, run models.ScheduleRun) {
+		notificationService.Notify(services.ScheduleRunEvent(schedule, run))
+	})
+	schedulerService.Start()
+
+	// app.Listen blocks until the server shuts down, so background services
+	// are stopped from a shutdown hook rather than deferred
+	app.Hooks().OnShutdown(func() error {
+		schedulerService.Stop()
+		notificationService.Stop()
+		return nil
+	})
+
+	webhookService, err := services.NewWebhookService("./data", workflowStore, workflowExecutor, encryptionKey)
+	if err != nil {
+		log.Fatalf("Failed to initialize webhooks: %v", err)
+	}
+
 	// Global Variables API
 	api.Get("/variables", func(c *fiber.Ctx) error {
 		variables := variableService.List()
@@ -856,7 +1103,11 @@ func main() {
 		if err := variableService.Set(variable); err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
-		return c.Status(201).JSON(variable)
+		saved, err := variableService.Get(variable.Name)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(201).JSON(saved)
 	})
 
 	api.Delete("/variables/:name", func(c *fiber.Ctx) error {
@@ -867,89 +1118,829 @@ func main() {
 		return c.SendStatus(204)
 	})
 
-	// Workflows API
-	api.Get("/workflows", func(c *fiber.Ctx) error {
-		workflows, err := workflowStore.List()
+	// Environments API
+	api.Get("/environments", func(c *fiber.Ctx) error {
+		return c.JSON(variableService.ListEnvironments())
+	})
+
+	api.Post("/environments", func(c *fiber.Ctx) error {
+		var env models.Environment
+		if err := c.BodyParser(&env); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if _, err := variableService.GetEnvironment(env.Name); err == nil {
+			return c.Status(409).JSON(fiber.Map{"error": "environment already exists: " + env.Name})
+		}
+		saved, err := variableService.SaveEnvironment(env)
 		if err != nil {
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 		}
-		return c.JSON(workflows)
+		return c.Status(201).JSON(saved)
 	})
 
-	api.Get("/workflows/:id", func(c *fiber.Ctx) error {
-		id := c.Params("id")
-		workflow, err := workflowStore.Get(id)
+	api.Get("/environments/:name", func(c *fiber.Ctx) error {
+		env, err := variableService.GetEnvironment(c.Params("name"))
 		if err != nil {
 			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
 		}
-		return c.JSON(workflow)
+		return c.JSON(env)
 	})
 
-	api.Post("/workflows", func(c *fiber.Ctx) error {
-		var workflow models.Workflow
-		if err := c.BodyParser(&workflow); err != nil {
+	api.Put("/environments/:name", func(c *fiber.Ctx) error {
+		name := c.Params("name")
+		if _, err := variableService.GetEnvironment(name); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		var env models.Environment
+		if err := c.BodyParser(&env); err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 		}
-		if err := workflowStore.Save(workflow); err != nil {
+		env.Name = name
+		saved, err := variableService.SaveEnvironment(env)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(saved)
+	})
+
+	api.Delete("/environments/:name", func(c *fiber.Ctx) error {
+		if err := variableService.DeleteEnvironment(c.Params("name")); err != nil {
+			switch {
+			case errors.Is(err, services.ErrEnvironmentNotFound):
+				return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+			case errors.Is(err, services.ErrEnvironmentInUse):
+				return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+			}
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
-		return c.Status(201).JSON(workflow)
+		return c.SendStatus(204)
 	})
 
-	api.Delete("/workflows/:id", func(c *fiber.Ctx) error {
-		id := c.Params("id")
-		if err := workflowStore.Delete(id); err != nil {
+	// Variables the environment sets itself, or with ?resolved=true every
+	// variable a run in it sees and where each value comes from
+	api.Get("/environments/:name/variables", func(c *fiber.Ctx) error {
+		variables, err := variableService.EnvironmentVariables(c.Params("name"), c.Query("resolved") == "true")
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(variables)
+	})
+
+	api.Post("/environments/:name/variables", func(c *fiber.Ctx) error {
+		var variable models.GlobalVariable
+		if err := c.BodyParser(&variable); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := variableService.SetEnvironmentVariable(c.Params("name"), variable); err != nil {
+			if errors.Is(err, services.ErrEnvironmentNotFound) {
+				return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		saved, err := variableService.GetEnvironmentVariable(c.Params("name"), variable.Name)
+		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
+		return c.Status(201).JSON(saved)
+	})
+
+	api.Delete("/environments/:name/variables/:var", func(c *fiber.Ctx) error {
+		if err := variableService.DeleteEnvironmentVariable(c.Params("name"), c.Params("var")); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
 		return c.SendStatus(204)
 	})
 
-	api.Post("/workflows/:id/execute", func(c *fiber.Ctx) error {
-		id := c.Params("id")
+	api.Post("/templates/preview", func(c *fiber.Ctx) error {
 		var req struct {
+			Template  string            `json:"template"`
 			Variables map[string]string `json:"variables"`
 		}
 		if err := c.BodyParser(&req); err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
 		}
+		return c.JSON(workflowExecutor.PreviewCommand(req.Template, req.Variables))
+	})
 
-		// Create a channel for streaming logs
-		outputChan := make(chan string)
-
-		// Start execution
-		execution, err := workflowExecutor.Execute(c.Context(), id, req.Variables, outputChan)
+	// Workflows API
+	api.Get("/workflows", func(c *fiber.Ctx) error {
+		workflows, err := workflowStore.List()
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
+		return c.JSON(workflows)
+	})
+
+	// sendWorkflows encodes workflows or bundles as YAML (default) or JSON
+	sendWorkflows := func(c *fiber.Ctx, v interface{}, filename string) error {
+		if c.Query("format") == "json" {
+			c.Attachment(filename + ".json")
+			return c.JSON(v)
+		}
 
-		// Stream output via WebSocket if connected, or just return execution ID
-		// For now, we'll just return the execution object and let the client subscribe to logs via WS if needed
-		// In a real implementation, we'd hook this into the existing WS infrastructure
+		data, err := services.MarshalYAML(v)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		c.Attachment(filename + ".yaml")
+		c.Set(fiber.HeaderContentType, "application/yaml")
+		return c.Send(data)
+	}
 
-		// Hook into existing WS for real-time logs
-		go func() {
-			for msg := range outputChan {
-				clientsMu.Lock()
-				wsMsg, _ := json.Marshal(fiber.Map{
-					"type":         "workflow_log",
-					"execution_id": execution.ID,
-					"output":       msg,
-					"timestamp":    time.Now(),
-				})
-				for client := range clients {
-					client.WriteMessage(websocket.TextMessage, wsMsg)
-				}
-				clientsMu.Unlock()
+	api.Get("/workflows/bundle", func(c *fiber.Ctx) error {
+		var ids []string
+		for _, id := range strings.Split(c.Query("ids"), ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				ids = append(ids, id)
 			}
-		}()
+		}
 
-		return c.JSON(execution)
+		bundle, err := workflowStore.ExportBundle(ids)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return sendWorkflows(c, bundle, "workflows")
 	})
 
-	// Agent Data Sync
-	api.Post("/sync/agent-data", func(c *fiber.Ctx) error {
-		var data map[string]interface{}
-		if err := c.BodyParser(&data); err != nil {
+	api.Post("/workflows/import", func(c *fiber.Ctx) error {
+		workflows, err := services.ParseWorkflows(c.Body())
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		author := c.Query("author", "dev-user-id") // Mock user
+		imported, err := workflowStore.Import(workflows, c.QueryBool("overwrite"), author, c.Query("note"))
+		var validationErr *services.WorkflowValidationError
+		if errors.As(err, &validationErr) {
+			return c.Status(422).JSON(fiber.Map{"error": err.Error(), "validation": validationErr.Results})
+		}
+		if errors.Is(err, services.ErrWorkflowExists) {
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(201).JSON(imported)
+	})
+
+	api.Post("/workflows/reload", func(c *fiber.Ctx) error {
+		if workflowsDir == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "WORKFLOWS_DIR is not configured"})
+		}
+
+		loaded, err := workflowStore.LoadDirectory(workflowsDir)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(loaded)
+	})
+
+	api.Get("/workflows/:id", func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		workflow, err := workflowStore.Get(id)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(workflow)
+	})
+
+	api.Post("/workflows/validate", func(c *fiber.Ctx) error {
+		var workflow models.Workflow
+		if err := c.BodyParser(&workflow); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(workflowValidator.Validate(workflow))
+	})
+
+	api.Post("/workflows", func(c *fiber.Ctx) error {
+		var req struct {
+			models.Workflow
+			Author     string `json:"author"`
+			ChangeNote string `json:"change_note"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if req.Author == "" {
+			req.Author = "dev-user-id" // Mock user
+		}
+		if result := workflowValidator.Validate(req.Workflow); !result.Valid {
+			return c.Status(422).JSON(fiber.Map{"error": "workflow is invalid", "validation": result})
+		}
+		saved, err := workflowStore.Save(req.Workflow, req.Author, req.ChangeNote)
+		if errors.Is(err, services.ErrInvalidWorkflowID) {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(201).JSON(saved)
+	})
+
+	api.Get("/workflows/:id/versions", func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		versions, err := workflowStore.Versions(id)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if len(versions) == 0 {
+			// Workflows saved before history was kept have no versions yet
+			if _, err := workflowStore.Get(id); err != nil {
+				return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+		return c.JSON(versions)
+	})
+
+	api.Get("/workflows/:id/versions/:version", func(c *fiber.Ctx) error {
+		version, err := c.ParamsInt("version")
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid version"})
+		}
+		v, err := workflowStore.GetVersion(c.Params("id"), version)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(v)
+	})
+
+	api.Get("/workflows/:id/diff", func(c *fiber.Ctx) error {
+		from, errFrom := strconv.Atoi(c.Query("from", "0"))
+		to, errTo := strconv.Atoi(c.Query("to", "0"))
+		if errFrom != nil || errTo != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "from and to must be version numbers"})
+		}
+		diff, err := workflowStore.Diff(c.Params("id"), from, to)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(diff)
+	})
+
+	api.Post("/workflows/:id/versions/:version/restore", func(c *fiber.Ctx) error {
+		version, err := c.ParamsInt("version")
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid version"})
+		}
+		var req struct {
+			Author     string `json:"author"`
+			ChangeNote string `json:"change_note"`
+		}
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+		if req.Author == "" {
+			req.Author = "dev-user-id" // Mock user
+		}
+
+		restored, err := workflowStore.Restore(c.Params("id"), version, req.Author, req.ChangeNote)
+		var validationErr *services.WorkflowValidationError
+		if errors.As(err, &validationErr) {
+			return c.Status(422).JSON(fiber.Map{"error": err.Error(), "validation": validationErr.Results})
+		}
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(restored)
+	})
+
+	api.Get("/workflows/:id/export", func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		workflow, err := workflowStore.Get(id)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return sendWorkflows(c, workflow, id)
+	})
+
+	api.Delete("/workflows/:id", func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		if err := workflowStore.Delete(id); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.SendStatus(204)
+	})
+
+	// streamWorkflowLogs forwards the log of a run to WebSocket clients as
+	// workflow_log messages until the run ends
+	streamWorkflowLogs := func(executionID string, outputChan <-chan string) {
+		for msg := range outputChan {
+			clientsMu.Lock()
+			wsMsg, _ := json.Marshal(fiber.Map{
+				"type":         "workflow_log",
+				"execution_id": executionID,
+				"output":       msg,
+				"timestamp":    time.Now(),
+			})
+			for client := range clients {
+				client.WriteMessage(websocket.TextMessage, wsMsg)
+			}
+			clientsMu.Unlock()
+		}
+	}
+
+	api.Post("/workflows/:id/execute", func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		var req struct {
+			Variables   map[string]string `json:"variables"`
+			Environment string            `json:"environment"`
+			DryRun      bool              `json:"dry_run"`
+		}
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		// Dry run: render the steps without executing them
+		if req.DryRun {
+			result, err := workflowExecutor.DryRun(id, req.Environment, req.Variables)
+			if err != nil {
+				if errors.Is(err, services.ErrEnvironmentNotFound) {
+					return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+				}
+				return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.JSON(result)
+		}
+
+		// Create a channel for streaming logs
+		outputChan := make(chan string)
+
+		// Start execution; the run outlives the request
+		execution, err := workflowExecutor.Execute(context.Background(), id, req.Environment, req.Variables, outputChan)
+		if err != nil {
+			if errors.Is(err, services.ErrInvalidInputs) || errors.Is(err, services.ErrEnvironmentNotFound) {
+				return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		go streamWorkflowLogs(execution.ID, outputChan)
+		return c.JSON(workflowExecutor.Snapshot(execution))
+	})
+
+	api.Get("/workflows/:id/runs", func(c *fiber.Ctx) error {
+		runs, err := runStore.ListByWorkflow(c.Params("id"))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		// Logs are only returned for a single run
+		for _, run := range runs {
+			run.Logs = nil
+		}
+		return c.JSON(runs)
+	})
+
+	// Approvals API
+	api.Get("/approvals", func(c *fiber.Ctx) error {
+		return c.JSON(approvalService.List(c.Query("status")))
+	})
+
+	api.Get("/approvals/:id", func(c *fiber.Ctx) error {
+		request, err := approvalService.Get(c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(request)
+	})
+
+	decideApproval := func(decision string) fiber.Handler {
+		return func(c *fiber.Ctx) error {
+			var req struct {
+				User    string `json:"user"`
+				Comment string `json:"comment"`
+			}
+			if len(c.Body()) > 0 {
+				if err := c.BodyParser(&req); err != nil {
+					return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+				}
+			}
+			if req.User == "" {
+				req.User = "dev-user-id"
+			}
+
+			id := c.Params("id")
+			if _, err := approvalService.Get(id); err != nil {
+				return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+			}
+
+			request, err := approvalService.Decide(id, decision, req.User, req.Comment)
+			if errors.Is(err, services.ErrNotApprover) {
+				return c.Status(403).JSON(fiber.Map{"error": err.Error()})
+			}
+			if err != nil {
+				return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.JSON(request)
+		}
+	}
+	api.Post("/approvals/:id/approve", decideApproval(services.ApprovalApprove))
+	api.Post("/approvals/:id/reject", decideApproval(services.ApprovalReject))
+
+	api.Get("/workflow-runs/retention", func(c *fiber.Ctx) error {
+		return c.JSON(runStore.GetRetention())
+	})
+
+	api.Put("/workflow-runs/retention", func(c *fiber.Ctx) error {
+		var retention models.RunRetention
+		if err := c.BodyParser(&retention); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := runStore.SetRetention(retention); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		removed, err := runStore.Prune()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"retention": retention, "removed": removed})
+	})
+
+	api.Get("/workflow-runs/:runId", func(c *fiber.Ctx) error {
+		runID := c.Params("runId")
+		if run, ok := workflowExecutor.GetActive(runID); ok {
+			return c.JSON(run)
+		}
+
+		run, err := runStore.Get(runID)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(run)
+	})
+
+	runArtifacts := func(runID string) ([]models.RunArtifact, error) {
+		if run, ok := workflowExecutor.GetActive(runID); ok {
+			return run.Artifacts, nil
+		}
+		run, err := runStore.Get(runID)
+		if err != nil {
+			return nil, err
+		}
+		return run.Artifacts, nil
+	}
+
+	api.Get("/workflow-runs/:runId/artifacts", func(c *fiber.Ctx) error {
+		artifacts, err := runArtifacts(c.Params("runId"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		if artifacts == nil {
+			artifacts = []models.RunArtifact{}
+		}
+		return c.JSON(artifacts)
+	})
+
+	api.Get("/workflow-runs/:runId/artifacts/*", func(c *fiber.Ctx) error {
+		runID := c.Params("runId")
+		artifacts, err := runArtifacts(runID)
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		path := c.Params("*")
+		for _, artifact := range artifacts {
+			if artifact.Path != path {
+				continue
+			}
+			if artifact.Expired {
+				return c.Status(404).JSON(fiber.Map{"error": "artifact expired"})
+			}
+			file, err := runStore.ArtifactFile(runID, path)
+			if err != nil {
+				return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Download(file, filepath.Base(artifact.Name))
+		}
+		return c.Status(404).JSON(fiber.Map{"error": services.ErrArtifactNotFound.Error()})
+	})
+
+	api.Post("/workflow-runs/:runId/resume", func(c *fiber.Ctx) error {
+		var req struct {
+			StepID    string            `json:"step_id"`
+			Variables map[string]string `json:"variables"`
+		}
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+
+		outputChan := make(chan string)
+		execution, err := workflowExecutor.Resume(context.Background(), c.Params("runId"), req.StepID, req.Variables, outputChan)
+		if err != nil {
+			switch {
+			case errors.Is(err, services.ErrRunNotFound), errors.Is(err, services.ErrWorkflowNotFound):
+				return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+			case errors.Is(err, services.ErrRunNotResumable):
+				return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+			case errors.Is(err, services.ErrInvalidInputs), errors.Is(err, services.ErrEnvironmentNotFound):
+				return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		go streamWorkflowLogs(execution.ID, outputChan)
+		return c.JSON(workflowExecutor.Snapshot(execution))
+	})
+
+	// Schedules API
+	api.Get("/schedules", func(c *fiber.Ctx) error {
+		return c.JSON(schedulerService.List())
+	})
+
+	api.Get("/schedules/:id", func(c *fiber.Ctx) error {
+		schedule, err := schedulerService.Get(c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(schedule)
+	})
+
+	api.Post("/schedules", func(c *fiber.Ctx) error {
+		var schedule models.Schedule
+		if err := c.BodyParser(&schedule); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		schedule.ID = ""
+
+		saved, err := schedulerService.Save(schedule)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(201).JSON(saved)
+	})
+
+	api.Put("/schedules/:id", func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		if _, err := schedulerService.Get(id); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		var schedule models.Schedule
+		if err := c.BodyParser(&schedule); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		schedule.ID = id
+
+		saved, err := schedulerService.Save(schedule)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(saved)
+	})
+
+	api.Delete("/schedules/:id", func(c *fiber.Ctx) error {
+		if err := schedulerService.Delete(c.Params("id")); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.SendStatus(204)
+	})
+
+	api.Get("/schedules/:id/runs", func(c *fiber.Ctx) error {
+		runs, err := schedulerService.ListRuns(c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(runs)
+	})
+
+	api.Post("/schedules/:id/trigger", func(c *fiber.Ctx) error {
+		run, err := schedulerService.Trigger(c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(202).JSON(run)
+	})
+
+	// Webhooks API
+	api.Get("/webhooks", func(c *fiber.Ctx) error {
+		webhooks := webhookService.List()
+		if workflowID := c.Query("workflow_id"); workflowID != "" {
+			filtered := make([]models.Webhook, 0)
+			for _, webhook := range webhooks {
+				if webhook.WorkflowID == workflowID {
+					filtered = append(filtered, webhook)
+				}
+			}
+			webhooks = filtered
+		}
+		return c.JSON(webhooks)
+	})
+
+	api.Get("/webhooks/:id", func(c *fiber.Ctx) error {
+		webhook, err := webhookService.Get(c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(webhook)
+	})
+
+	api.Post("/webhooks", func(c *fiber.Ctx) error {
+		var webhook models.Webhook
+		if err := c.BodyParser(&webhook); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		webhook.ID = ""
+
+		saved, err := webhookService.Save(webhook)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(201).JSON(saved)
+	})
+
+	api.Put("/webhooks/:id", func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		if _, err := webhookService.Get(id); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		var webhook models.Webhook
+		if err := c.BodyParser(&webhook); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		webhook.ID = id
+
+		saved, err := webhookService.Save(webhook)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(saved)
+	})
+
+	api.Delete("/webhooks/:id", func(c *fiber.Ctx) error {
+		if err := webhookService.Delete(c.Params("id")); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.SendStatus(204)
+	})
+
+	api.Get("/webhooks/:id/deliveries", func(c *fiber.Ctx) error {
+		deliveries, err := webhookService.ListDeliveries(c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(deliveries)
+	})
+
+	// Inbound webhook deliveries, authenticated by token or HMAC signature
+	api.Post("/hooks/:id", func(c *fiber.Ctx) error {
+		delivery, err := webhookService.Deliver(c.Params("id"), services.WebhookRequest{
+			Header: func(name string) string { return c.Get(name) },
+			Query:  func(name string) string { return c.Query(name) },
+			Body:   c.Body(),
+		})
+		switch {
+		case errors.Is(err, services.ErrWebhookNotFound), errors.Is(err, services.ErrWebhookUnauthorized):
+			// Unknown hooks and bad credentials look the same to the caller
+			return c.Status(401).JSON(fiber.Map{"error": services.ErrWebhookUnauthorized.Error()})
+		case errors.Is(err, services.ErrWebhookDisabled):
+			return c.Status(403).JSON(fiber.Map{"error": err.Error()})
+		case err != nil && delivery != nil:
+			return c.Status(400).JSON(fiber.Map{"error": err.Error(), "delivery": delivery})
+		case err != nil:
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		case delivery.Status == "duplicate":
+			return c.JSON(delivery)
+		}
+		return c.Status(202).JSON(delivery)
+	})
+
+	// Notifications API
+	api.Get("/notifications/channels", func(c *fiber.Ctx) error {
+		return c.JSON(notificationService.ListChannels())
+	})
+
+	api.Get("/notifications/channels/:id", func(c *fiber.Ctx) error {
+		channel, err := notificationService.GetChannel(c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(channel)
+	})
+
+	api.Post("/notifications/channels", func(c *fiber.Ctx) error {
+		var channel models.NotificationChannel
+		if err := c.BodyParser(&channel); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		channel.ID = ""
+
+		saved, err := notificationService.SaveChannel(channel)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(201).JSON(saved)
+	})
+
+	api.Put("/notifications/channels/:id", func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		if _, err := notificationService.GetChannel(id); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		var channel models.NotificationChannel
+		if err := c.BodyParser(&channel); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		channel.ID = id
+
+		saved, err := notificationService.SaveChannel(channel)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(saved)
+	})
+
+	api.Delete("/notifications/channels/:id", func(c *fiber.Ctx) error {
+		err := notificationService.DeleteChannel(c.Params("id"))
+		switch {
+		case errors.Is(err, services.ErrChannelNotFound):
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		case errors.Is(err, services.ErrChannelInUse):
+			return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+		case err != nil:
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.SendStatus(204)
+	})
+
+	// Send a test message now, once, and report the outcome
+	api.Post("/notifications/channels/:id/test", func(c *fiber.Ctx) error {
+		delivery, err := notificationService.TestChannel(c.Context(), c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		if delivery.Status != "sent" {
+			return c.Status(502).JSON(delivery)
+		}
+		return c.JSON(delivery)
+	})
+
+	api.Get("/notifications/rules", func(c *fiber.Ctx) error {
+		return c.JSON(notificationService.ListRules())
+	})
+
+	api.Get("/notifications/rules/:id", func(c *fiber.Ctx) error {
+		rule, err := notificationService.GetRule(c.Params("id"))
+		if err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(rule)
+	})
+
+	api.Post("/notifications/rules", func(c *fiber.Ctx) error {
+		var rule models.NotificationRule
+		if err := c.BodyParser(&rule); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		rule.ID = ""
+
+		saved, err := notificationService.SaveRule(rule)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(201).JSON(saved)
+	})
+
+	api.Put("/notifications/rules/:id", func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		if _, err := notificationService.GetRule(id); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		var rule models.NotificationRule
+		if err := c.BodyParser(&rule); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		rule.ID = id
+
+		saved, err := notificationService.SaveRule(rule)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(saved)
+	})
+
+	api.Delete("/notifications/rules/:id", func(c *fiber.Ctx) error {
+		if err := notificationService.DeleteRule(c.Params("id")); err != nil {
+			return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.SendStatus(204)
+	})
+
+	api.Get("/notifications/deliveries", func(c *fiber.Ctx) error {
+		return c.JSON(notificationService.ListDeliveries(c.Query("channel_id"), c.Query("rule_id")))
+	})
+
+	// Agent Data Sync
+	api.Post("/sync/agent-data", func(c *fiber.Ctx) error {
+		var data map[string]interface{}
+		if err := c.BodyParser(&data); err != nil {
 			return c.Status(400).JSON(fiber.Map{"error": "Invalid data"})
 		}
 
@@ -987,11 +1978,66 @@ func main() {
 		"version": "2.0",
 	}).Data)
 
+	// Shut down on SIGINT/SIGTERM so the shutdown hooks run
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+		<-quit
+		log.Println("Shutting down server...")
+		if err := app.Shutdown(); err != nil {
+			logger.Error("Failed to shut down server", err)
+		}
+	}()
+
 	log.Printf("🚀 Server starting on port %s", port)
 	if err := app.Listen(":" + port); err != nil {
 		logger.Error("Failed to start server", err)
 		log.Fatalf("Failed to start server: %v", err)
 	}
+	<-stopped
+}
+
+// queuedExecution is an execution with its place in the command queue
+type queuedExecution struct {
+	*models.CommandExecution
+	Queue *models.QueuePosition `json:"queue,omitempty"`
+}
+
+// batchStatus reports the current state of every command in a batch
+func batchStatus(batch *models.CommandBatch, cmdService *services.CommandService, cmdQueue *services.CommandQueue) fiber.Map {
+	items := make([]queuedExecution, 0, len(batch.Executions))
+	counts := make(map[string]int)
+	for _, id := range batch.Executions {
+		execution, err := cmdService.GetExecution(id)
+		if err != nil {
+			continue
+		}
+		items = append(items, queuedExecution{execution, cmdQueue.Position(id)})
+		counts[execution.Status]++
+	}
+
+	return fiber.Map{
+		"id":         batch.ID,
+		"created_at": batch.CreatedAt,
+		"total":      len(batch.Executions),
+		"counts":     counts,
+		"items":      items,
+	}
+}
+
+// envInt reads an integer setting from the environment, falling back to def
+func envInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %v", name, err)
+	}
+	return n
 }
 
 func getMapKeys(m map[string]interface{}) []string {
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// ApprovalConfig configures an approval step
+type ApprovalConfig struct {
+	Approvers     []string `json:"approvers,omitempty" yaml:"approvers,omitempty"`           // Users allowed to decide; empty = anyone
+	Message       string   `json:"message,omitempty" yaml:"message,omitempty"`               // Shown to approvers, supports {VARIABLES}
+	TimeoutMs     int64    `json:"timeout_ms,omitempty" yaml:"timeout_ms,omitempty"`         // 0 = wait indefinitely
+	TimeoutAction string   `json:"timeout_action,omitempty" yaml:"timeout_action,omitempty"` // reject (default), approve
+}
+
+// ApprovalRequest is a pending or decided approval gate in a workflow run
+type ApprovalRequest struct {
+	ID          string     `json:"id"`
+	RunID       string     `json:"run_id"`
+	WorkflowID  string     `json:"workflow_id"`
+	StepID      string     `json:"step_id"`
+	StepName    string     `json:"step_name,omitempty"`
+	Message     string     `json:"message,omitempty"`
+	Approvers   []string   `json:"approvers,omitempty"`
+	Status      string     `json:"status"` // pending, approved, rejected, timed_out, cancelled
+	DecidedBy   string     `json:"decided_by,omitempty"`
+	Comment     string     `json:"comment,omitempty"`
+	RequestedAt time.Time  `json:"requested_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	DecidedAt   *time.Time `json:"decided_at,omitempty"`
+}
@@ -4,16 +4,23 @@ import "time"
 
 // CommandExecution represents a command execution request/response
 type CommandExecution struct {
-	ID        string     `json:"id"`
-	UserID    string     `json:"user_id"`
-	Command   string     `json:"command"`
-	Args      []string   `json:"args,omitempty"`
-	WorkDir   string     `json:"work_dir,omitempty"`
-	Status    string     `json:"status"` // pending, running, success, failed
-	Output    string     `json:"output,omitempty"`
-	Error     string     `json:"error,omitempty"`
-	ExitCode  int        `json:"exit_code"`
-	StartedAt time.Time  `json:"started_at"`
-	EndedAt   *time.Time `json:"ended_at,omitempty"`
-	Duration  int64      `json:"duration_ms,omitempty"` // milliseconds
+	ID         string           `json:"id"`
+	UserID     string           `json:"user_id"`
+	Command    string           `json:"command"`
+	Args       []string         `json:"args,omitempty"`
+	WorkDir    string           `json:"work_dir,omitempty"`
+	Priority   string           `json:"priority,omitempty"` // high, normal, low (queued executions)
+	BatchID    string           `json:"batch_id,omitempty"`
+	Retry      *CommandRetry    `json:"retry,omitempty"`
+	Timeout    *CommandTimeout  `json:"timeout,omitempty"`
+	Locks      []string         `json:"locks,omitempty"`       // Held while the command runs
+	LockPolicy string           `json:"lock_policy,omitempty"` // wait (default), fail
+	Status     string           `json:"status"`                // pending, queued, running, success, failed, timeout
+	Output     string           `json:"output,omitempty"`
+	Error      string           `json:"error,omitempty"`
+	ExitCode   int              `json:"exit_code"`
+	StartedAt  time.Time        `json:"started_at"`
+	EndedAt    *time.Time       `json:"ended_at,omitempty"`
+	Duration   int64            `json:"duration_ms,omitempty"` // milliseconds
+	Attempts   []CommandAttempt `json:"attempts,omitempty"`
 }
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Environment is a named variable set such as dev, staging or prod. Its
+// variables override those of its base, and the base chain ends at the
+// global variables.
+type Environment struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Base        string          `json:"base,omitempty"`     // Environment inherited from; empty = the global variables
+	Protected   bool            `json:"protected"`          // Runs carry a warning
+	Approval    *ApprovalConfig `json:"approval,omitempty"` // Protected only: runs wait for approval before starting
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// ResolvedVariable is a variable as a run in an environment sees it
+type ResolvedVariable struct {
+	GlobalVariable
+	Source string `json:"source"` // Environment that sets the value, or "global"
+}
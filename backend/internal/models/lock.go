@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Lock is a named mutual-exclusion lock held by a workflow run or command,
+// e.g. terraform:/srv/infra or k8s:prod-context
+type Lock struct {
+	Name       string       `json:"name"`
+	Holder     LockHolder   `json:"holder"`
+	AcquiredAt time.Time    `json:"acquired_at"`
+	Waiting    []LockHolder `json:"waiting,omitempty"` // Runs waiting for the lock, oldest first
+}
+
+// LockHolder identifies what holds or waits for a lock
+type LockHolder struct {
+	Type   string `json:"type"`              // workflow_run, command
+	ID     string `json:"id"`                // Run or command execution ID
+	Label  string `json:"label,omitempty"`   // Workflow name or command line
+	UserID string `json:"user_id,omitempty"` // Who started it
+}
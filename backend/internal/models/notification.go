@@ -0,0 +1,69 @@
+package models
+
+import "time"
+
+// NotificationChannel is a destination for notifications
+type NotificationChannel struct {
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	Type         string            `json:"type"`                    // webhook, slack, email
+	URL          string            `json:"url,omitempty"`           // webhook and slack
+	Headers      map[string]string `json:"headers,omitempty"`       // Extra request headers (webhook)
+	Secret       string            `json:"secret,omitempty"`        // HMAC signing key (webhook) or SMTP password, masked in responses
+	SMTPHost     string            `json:"smtp_host,omitempty"`     // email
+	SMTPPort     int               `json:"smtp_port,omitempty"`     // email (default 25)
+	SMTPUsername string            `json:"smtp_username,omitempty"` // email, enables PLAIN auth
+	From         string            `json:"from,omitempty"`          // email
+	To           []string          `json:"to,omitempty"`            // email recipients
+	Retry        *CommandRetry     `json:"retry,omitempty"`         // Delivery retry (default 3 attempts)
+	Enabled      bool              `json:"enabled"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+// NotificationRule sends a message to channels when a matching event occurs
+type NotificationRule struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Events      []string  `json:"events,omitempty"`       // workflow_run, command, schedule_run; empty = all
+	Statuses    []string  `json:"statuses,omitempty"`     // e.g. failed, completed; empty = any
+	WorkflowIDs []string  `json:"workflow_ids,omitempty"` // Only runs of these workflows; empty = any
+	Triggers    []string  `json:"triggers,omitempty"`     // Only workflow runs started by manual, schedule, webhook or workflow
+	Channels    []string  `json:"channels"`               // Channel IDs
+	Subject     string    `json:"subject,omitempty"`      // Template, see NotificationEvent for placeholders
+	Body        string    `json:"body,omitempty"`         // Template
+	Enabled     bool      `json:"enabled"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// NotificationEvent is the outcome of a workflow run, command or scheduled
+// activation that rules are matched against. Its fields are available to
+// templates as {EVENT}, {STATUS}, {ID}, {NAME}, {WORKFLOW_ID}, {TRIGGER},
+// {USER}, {ERROR}, {DURATION}, {DURATION_MS} and {TIME}.
+type NotificationEvent struct {
+	Type       string    `json:"type"`   // workflow_run, command, schedule_run
+	Status     string    `json:"status"` // Final status of the run, command or activation
+	ID         string    `json:"id"`     // Run, command execution or schedule run ID
+	Name       string    `json:"name"`   // Workflow name, command line or schedule name
+	WorkflowID string    `json:"workflow_id,omitempty"`
+	Trigger    string    `json:"trigger,omitempty"` // What started it, e.g. schedule or cron
+	UserID     string    `json:"user_id,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// NotificationDelivery records a message sent, or being sent, to a channel
+type NotificationDelivery struct {
+	ID        string            `json:"id"`
+	RuleID    string            `json:"rule_id,omitempty"` // Empty for test messages
+	ChannelID string            `json:"channel_id"`
+	Event     NotificationEvent `json:"event"`
+	Subject   string            `json:"subject"`
+	Status    string            `json:"status"` // pending, sent, failed
+	Attempts  int               `json:"attempts"`
+	Error     string            `json:"error,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	SentAt    *time.Time        `json:"sent_at,omitempty"`
+}
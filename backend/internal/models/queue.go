@@ -4,33 +4,105 @@ import "time"
 
 // CommandQueue represents a queue of commands to execute
 type CommandQueue struct {
-	ID          string     `json:"id"`
-	UserID      string     `json:"user_id"`
-	Name        string     `json:"name"`
-	Commands    []string   `json:"commands"` // Command execution IDs
-	Status      string     `json:"status"`   // pending, running, completed, failed
-	CurrentStep int        `json:"current_step"`
-	CreatedAt   time.Time  `json:"created_at"`
-	StartedAt   *time.Time `json:"started_at,omitempty"`
-	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ID             string          `json:"id"`
+	UserID         string          `json:"user_id"`
+	Name           string          `json:"name"`
+	Commands       []string        `json:"commands"`          // Command execution IDs, in execution order
+	Items          []QueueItem     `json:"items"`             // Per-command state, in execution order
+	Priority       string          `json:"priority"`          // high, normal, low
+	Retry          *CommandRetry   `json:"retry,omitempty"`   // Applied to every command in the queue
+	Timeout        *CommandTimeout `json:"timeout,omitempty"` // Applied to every command in the queue
+	Status         string          `json:"status"`            // pending, running, paused, completed, failed, cancelled
+	PauseRequested bool            `json:"pause_requested,omitempty"`
+	CurrentStep    int             `json:"current_step"`
+	CreatedAt      time.Time       `json:"created_at"`
+	StartedAt      *time.Time      `json:"started_at,omitempty"`
+	CompletedAt    *time.Time      `json:"completed_at,omitempty"`
+}
+
+// QueueItem tracks one command of a queue
+type QueueItem struct {
+	ID          string `json:"id"`
+	CommandID   string `json:"command_id"`             // Execution the command is copied from
+	ExecutionID string `json:"execution_id,omitempty"` // Execution produced when the item ran
+	Status      string `json:"status"`                 // pending, running, completed, failed, skipped, cancelled
+}
+
+// QueuePosition estimates when a queued command will start
+type QueuePosition struct {
+	ExecutionID      string     `json:"execution_id"`
+	Position         int        `json:"position"` // 1 = next to start
+	EstimatedStartAt *time.Time `json:"estimated_start_at,omitempty"`
+}
+
+// BatchCommand is one command submitted through the batch API
+type BatchCommand struct {
+	Command    string          `json:"command"`
+	Args       []string        `json:"args,omitempty"`
+	WorkDir    string          `json:"work_dir,omitempty"`
+	Priority   string          `json:"priority,omitempty"`
+	Retry      *CommandRetry   `json:"retry,omitempty"`
+	Timeout    *CommandTimeout `json:"timeout,omitempty"`
+	Locks      []string        `json:"locks,omitempty"`
+	LockPolicy string          `json:"lock_policy,omitempty"`
+}
+
+// CommandBatch groups commands submitted together
+type CommandBatch struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	Executions []string  `json:"executions"` // Execution IDs, in submission order
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// QueueLimits configures per-user fairness quotas for the command queue.
+// Zero values mean unlimited.
+type QueueLimits struct {
+	MaxQueuedTotal       int                       `json:"max_queued_total"`
+	MaxQueuedPerUser     int                       `json:"max_queued_per_user"`
+	MaxConcurrentPerUser int                       `json:"max_concurrent_per_user"`
+	Users                map[string]UserQueueLimit `json:"users,omitempty"` // Per-user overrides
+}
+
+// UserQueueLimit overrides the queue limits for a single user
+type UserQueueLimit struct {
+	MaxQueued     int `json:"max_queued,omitempty"`
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+	Weight        int `json:"weight,omitempty"` // Share of workers relative to other users, default 1
 }
 
 // CommandTimeout represents timeout configuration
 type CommandTimeout struct {
-	Duration time.Duration `json:"duration"`
-	Action   string        `json:"action"` // kill, continue
+	DurationMs int64  `json:"duration_ms" yaml:"duration_ms,omitempty"`
+	Action     string `json:"action" yaml:"action,omitempty"` // kill, continue
 }
 
 // CommandRetry represents retry configuration
 type CommandRetry struct {
-	MaxAttempts int           `json:"max_attempts"`
-	Delay       time.Duration `json:"delay"`
-	Backoff     float64       `json:"backoff"` // exponential backoff multiplier
+	MaxAttempts      int     `json:"max_attempts" yaml:"max_attempts,omitempty"`
+	DelayMs          int64   `json:"delay_ms" yaml:"delay_ms,omitempty"`
+	Backoff          float64 `json:"backoff" yaml:"backoff,omitempty"`                                   // exponential backoff multiplier
+	RetryOnExitCodes []int   `json:"retry_on_exit_codes,omitempty" yaml:"retry_on_exit_codes,omitempty"` // empty retries on any failure
+}
+
+// CommandAttempt records a single attempt of a command execution
+type CommandAttempt struct {
+	Number    int        `json:"number"`
+	Status    string     `json:"status"` // success, failed, timeout
+	Output    string     `json:"output,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	ExitCode  int        `json:"exit_code"`
+	TimedOut  bool       `json:"timed_out,omitempty"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	Duration  int64      `json:"duration_ms,omitempty"` // milliseconds
 }
 
 // CommandProgress represents execution progress
 type CommandProgress struct {
-	ExecutionID string    `json:"execution_id"`
+	ExecutionID string    `json:"execution_id,omitempty"`
+	QueueID     string    `json:"queue_id,omitempty"`
+	BatchID     string    `json:"batch_id,omitempty"`
 	Step        string    `json:"step"`
 	Percentage  float64   `json:"percentage"`
 	Message     string    `json:"message"`
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Schedule runs a workflow or command queue on a cron expression
+type Schedule struct {
+	ID             string            `json:"id"`
+	Name           string            `json:"name"`
+	CronExpression string            `json:"cron_expression"`
+	TimeZone       string            `json:"time_zone"`   // IANA name, e.g. Europe/Lisbon (default UTC)
+	TargetType     string            `json:"target_type"` // workflow, queue
+	TargetID       string            `json:"target_id"`
+	Variables      map[string]string `json:"variables,omitempty"`   // Workflow input variables
+	Environment    string            `json:"environment,omitempty"` // Variable environment of workflow runs
+	OverlapPolicy  string            `json:"overlap_policy"`        // skip, queue, allow
+	Enabled        bool              `json:"enabled"`
+	NextRunAt      *time.Time        `json:"next_run_at,omitempty"`
+	LastRunAt      *time.Time        `json:"last_run_at,omitempty"`
+	LastStatus     string            `json:"last_status,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+}
+
+// ScheduleRun records one activation of a schedule
+type ScheduleRun struct {
+	ID           string     `json:"id"`
+	ScheduleID   string     `json:"schedule_id"`
+	TargetType   string     `json:"target_type"`
+	TargetID     string     `json:"target_id"`
+	RunID        string     `json:"run_id,omitempty"` // Workflow execution or queue ID
+	Trigger      string     `json:"trigger"`          // cron, manual
+	Status       string     `json:"status"`           // queued, running, completed, failed, skipped, interrupted
+	Error        string     `json:"error,omitempty"`
+	ScheduledFor time.Time  `json:"scheduled_for"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	EndedAt      *time.Time `json:"ended_at,omitempty"`
+}
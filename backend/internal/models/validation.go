@@ -0,0 +1,18 @@
+package models
+
+// ValidationIssue is a problem found by static workflow validation
+type ValidationIssue struct {
+	Severity string `json:"severity"` // error, warning
+	Code     string `json:"code"`     // e.g. unknown_target, undeclared_variable
+	Message  string `json:"message"`
+	Location string `json:"location"` // e.g. steps[2].conditions[0].action.target
+	StepID   string `json:"step_id,omitempty"`
+}
+
+// ValidationResult lists the issues found in a workflow. A workflow with
+// only warnings is valid.
+type ValidationResult struct {
+	WorkflowID string            `json:"workflow_id,omitempty"`
+	Valid      bool              `json:"valid"`
+	Issues     []ValidationIssue `json:"issues"`
+}
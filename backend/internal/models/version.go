@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// WorkflowVersion is one saved revision of a workflow
+type WorkflowVersion struct {
+	WorkflowID string    `json:"workflow_id"`
+	Version    int       `json:"version"`
+	Author     string    `json:"author,omitempty"`
+	ChangeNote string    `json:"change_note,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	Workflow   *Workflow `json:"workflow,omitempty"` // Omitted in version lists
+}
+
+// WorkflowDiff lists the differences between two versions of a workflow
+type WorkflowDiff struct {
+	WorkflowID string           `json:"workflow_id"`
+	From       int              `json:"from"`
+	To         int              `json:"to"`
+	Changes    []WorkflowChange `json:"changes"`
+}
+
+// WorkflowChange is a single difference. Steps are addressed by ID, for
+// example steps[id=deploy].content.
+type WorkflowChange struct {
+	Path string      `json:"path"`
+	Op   string      `json:"op"` // added, removed, changed, reordered
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// Webhook starts a workflow when an external system posts to it
+type Webhook struct {
+	ID              string            `json:"id"`
+	Name            string            `json:"name"`
+	WorkflowID      string            `json:"workflow_id"`
+	AuthType        string            `json:"auth_type"`                  // token, hmac
+	Secret          string            `json:"secret,omitempty"`           // Token or HMAC key, masked in responses
+	SignatureHeader string            `json:"signature_header,omitempty"` // HMAC header (default X-Hub-Signature-256)
+	DeliveryHeader  string            `json:"delivery_header,omitempty"`  // Header with a unique delivery ID
+	Mapping         map[string]string `json:"mapping,omitempty"`          // Input variable -> payload path, e.g. pull_request.head.ref
+	Variables       map[string]string `json:"variables,omitempty"`        // Fixed input variables
+	Environment     string            `json:"environment,omitempty"`      // Variable environment of the runs
+	Enabled         bool              `json:"enabled"`
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+}
+
+// WebhookDelivery records one authenticated call to a webhook
+type WebhookDelivery struct {
+	ID          string            `json:"id"`
+	WebhookID   string            `json:"webhook_id"`
+	DeliveryID  string            `json:"delivery_id"` // From the delivery header, or a hash of the payload
+	Status      string            `json:"status"`      // received, triggered, duplicate, failed
+	Error       string            `json:"error,omitempty"`
+	RunID       string            `json:"run_id,omitempty"`       // Workflow run started by this delivery
+	DuplicateOf string            `json:"duplicate_of,omitempty"` // Delivery that was replayed
+	Variables   map[string]string `json:"variables,omitempty"`    // Mapped and fixed inputs of the run, secrets masked
+	ReceivedAt  time.Time         `json:"received_at"`
+}
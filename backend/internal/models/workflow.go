@@ -4,69 +4,219 @@ import "time"
 
 // Workflow represents a complete workflow with steps and variables
 type Workflow struct {
-	ID          string     `json:"id"`
-	Name        string     `json:"name"`
-	Description string     `json:"description"`
-	Category    string     `json:"category"`
-	Variables   []Variable `json:"variables"`
-	Steps       []Step     `json:"steps"`
-	CreatedAt   time.Time  `json:"created_at"`
-	UpdatedAt   time.Time  `json:"updated_at"`
+	ID          string     `json:"id" yaml:"id,omitempty"`
+	Name        string     `json:"name" yaml:"name,omitempty"`
+	Description string     `json:"description" yaml:"description,omitempty"`
+	Category    string     `json:"category" yaml:"category,omitempty"`
+	Variables   []Variable `json:"variables" yaml:"variables,omitempty"`
+	Steps       []Step     `json:"steps" yaml:"steps,omitempty"`
+	Locks       []string   `json:"locks,omitempty" yaml:"locks,omitempty"`             // Held for the whole run, e.g. terraform:{WORK_DIR}
+	LockPolicy  string     `json:"lock_policy,omitempty" yaml:"lock_policy,omitempty"` // wait (default), fail
+	Version     int        `json:"version" yaml:"-"`                                   // Set by the store on every saved change
+	CreatedAt   time.Time  `json:"created_at" yaml:"created_at,omitempty"`
+	UpdatedAt   time.Time  `json:"updated_at" yaml:"updated_at,omitempty"`
 }
 
 // Variable represents a workflow variable (global or local)
 type Variable struct {
-	Name         string   `json:"name"`
-	Description  string   `json:"description"`
-	Type         string   `json:"type"` // string, number, select, boolean
-	DefaultValue string   `json:"default_value,omitempty"`
-	Options      []string `json:"options,omitempty"` // For select type
-	IsGlobal     bool     `json:"is_global"`         // Global or template-specific
-	Required     bool     `json:"required"`
+	Name         string   `json:"name" yaml:"name,omitempty"`
+	Description  string   `json:"description" yaml:"description,omitempty"`
+	Type         string   `json:"type" yaml:"type,omitempty"` // string, number, select, boolean, secret
+	DefaultValue string   `json:"default_value,omitempty" yaml:"default_value,omitempty"`
+	Options      []string `json:"options,omitempty" yaml:"options,omitempty"` // For select type
+	Pattern      string   `json:"pattern,omitempty" yaml:"pattern,omitempty"` // Regex the whole value must match
+	IsGlobal     bool     `json:"is_global" yaml:"is_global,omitempty"`       // Global or template-specific
+	Required     bool     `json:"required" yaml:"required,omitempty"`
 }
 
 // Step represents a single step in a workflow
 type Step struct {
-	ID         string            `json:"id"`
-	Name       string            `json:"name"`
-	Type       string            `json:"type"` // command, workflow_ref, conditional
-	Order      int               `json:"order"`
-	Content    string            `json:"content"`              // Command or workflow_id
-	Variables  map[string]string `json:"variables,omitempty"`  // Variable mappings
-	Conditions []Condition       `json:"conditions,omitempty"` // Conditional logic
-	OnSuccess  *StepAction       `json:"on_success,omitempty"`
-	OnFailure  *StepAction       `json:"on_failure,omitempty"`
+	ID         string            `json:"id" yaml:"id,omitempty"`
+	Name       string            `json:"name" yaml:"name,omitempty"`
+	Type       string            `json:"type" yaml:"type,omitempty"` // command, workflow_ref, parallel, foreach, matrix, approval, or a native type (http, tcp_check, dns, tls_check, terraform, argocd_sync, aws_list)
+	Order      int               `json:"order" yaml:"order,omitempty"`
+	Content    string            `json:"content" yaml:"content,omitempty"`                 // Command or workflow_id
+	Variables  map[string]string `json:"variables,omitempty" yaml:"variables,omitempty"`   // Variable mappings
+	Conditions []Condition       `json:"conditions,omitempty" yaml:"conditions,omitempty"` // Conditional logic
+	OnSuccess  *StepAction       `json:"on_success,omitempty" yaml:"on_success,omitempty"`
+	OnFailure  *StepAction       `json:"on_failure,omitempty" yaml:"on_failure,omitempty"`
+	Retry      *CommandRetry     `json:"retry,omitempty" yaml:"retry,omitempty"`         // command, workflow_ref and native steps
+	Timeout    *CommandTimeout   `json:"timeout,omitempty" yaml:"timeout,omitempty"`     // Deadline per attempt, any step type
+	Outputs    []StepOutput      `json:"outputs,omitempty" yaml:"outputs,omitempty"`     // Values captured into variables
+	Approval   *ApprovalConfig   `json:"approval,omitempty" yaml:"approval,omitempty"`   // Approval steps
+	With       map[string]string `json:"with,omitempty" yaml:"with,omitempty"`           // Native step parameters or workflow_ref inputs
+	Artifacts  []string          `json:"artifacts,omitempty" yaml:"artifacts,omitempty"` // Workspace files or globs kept after the step
+
+	// ContinueOnError lets the run go on when the step fails. An on_failure
+	// action takes precedence; inside parallel steps and loops the failure
+	// does not fail the group or iteration.
+	ContinueOnError bool `json:"continue_on_error,omitempty" yaml:"continue_on_error,omitempty"`
+
+	// Parallel groups and loops: parallel runs the child steps concurrently,
+	// foreach and matrix run them in order once per iteration. Conditions and
+	// on_success/on_failure actions of child steps are not evaluated.
+	Steps          []Step `json:"steps,omitempty" yaml:"steps,omitempty"`
+	MaxConcurrency int    `json:"max_concurrency,omitempty" yaml:"max_concurrency,omitempty"` // parallel: 0 = all at once; loops: iterations at once (0 = 1)
+	Mode           string `json:"mode,omitempty" yaml:"mode,omitempty"`                       // wait_all (default), fail_fast
+
+	// Loop values. An entry that is a single placeholder such as "{CLUSTERS}"
+	// expands to the list in that variable: a JSON array or comma separated.
+	Items  []string            `json:"items,omitempty" yaml:"items,omitempty"`   // foreach
+	As     string              `json:"as,omitempty" yaml:"as,omitempty"`         // foreach: iteration variable (default ITEM)
+	Matrix map[string][]string `json:"matrix,omitempty" yaml:"matrix,omitempty"` // matrix: variable -> values, every combination runs
+}
+
+// StepOutput extracts a value from a step's result into a workflow variable
+type StepOutput struct {
+	Name    string `json:"name" yaml:"name,omitempty"`                 // Variable to set
+	Type    string `json:"type" yaml:"type,omitempty"`                 // regex, json, stdout, exit_code
+	Pattern string `json:"pattern,omitempty" yaml:"pattern,omitempty"` // regex: expression to match against stdout
+	Group   int    `json:"group,omitempty" yaml:"group,omitempty"`     // regex: capture group (default 1, or 0 without groups)
+	Path    string `json:"path,omitempty" yaml:"path,omitempty"`       // json: path such as Instances[0].InstanceId
+	Default string `json:"default,omitempty" yaml:"default,omitempty"` // Used when nothing is extracted
 }
 
 // Condition represents a conditional check on step output
 type Condition struct {
-	Type     string     `json:"type"`  // contains, equals, starts_with, ends_with, regex, exit_code
-	Value    string     `json:"value"` // Value to compare
-	Operator string     `json:"operator,omitempty"` // AND, OR (for multiple conditions)
-	Action   StepAction `json:"action"`
+	Type     string     `json:"type" yaml:"type,omitempty"`                   // contains, equals, starts_with, ends_with, regex, exit_code
+	Value    string     `json:"value" yaml:"value,omitempty"`                 // Value to compare
+	Operator string     `json:"operator,omitempty" yaml:"operator,omitempty"` // AND, OR (for multiple conditions)
+	Action   StepAction `json:"action" yaml:"action,omitempty"`
 }
 
 // StepAction defines what to do based on condition result
 type StepAction struct {
-	Type   string `json:"type"`   // continue, stop, jump_to, execute_step
-	Target string `json:"target"` // Step ID or workflow ID
+	Type   string `json:"type" yaml:"type,omitempty"`     // continue, stop, jump_to, execute_step
+	Target string `json:"target" yaml:"target,omitempty"` // Step ID or workflow ID
 }
 
 // WorkflowExecution tracks the execution state
 type WorkflowExecution struct {
-	ID         string            `json:"id"`
-	WorkflowID string            `json:"workflow_id"`
-	Status     string            `json:"status"` // pending, running, completed, failed, cancelled
-	Variables  map[string]string `json:"variables"`
-	Logs       []ExecutionLog    `json:"logs"`
-	StartTime  string            `json:"start_time"`
-	EndTime    string            `json:"end_time,omitempty"`
+	ID              string            `json:"id"`
+	WorkflowID      string            `json:"workflow_id"`
+	WorkflowName    string            `json:"workflow_name,omitempty"`
+	WorkflowVersion int               `json:"workflow_version,omitempty"` // Version that was executed
+	Trigger         *RunTrigger       `json:"trigger,omitempty"`          // Unset for manual runs
+	ResumedFrom     string            `json:"resumed_from,omitempty"`     // Run this one resumed
+	ResumeStep      string            `json:"resume_step,omitempty"`      // Step the resumed run started at
+	ResumedBy       []string          `json:"resumed_by,omitempty"`       // Runs that resumed this one
+	CallStack       []string          `json:"call_stack,omitempty"`       // Workflows of the calling runs, outermost first
+	Locks           []string          `json:"locks,omitempty"`            // Lock names held by the run
+	Environment     string            `json:"environment,omitempty"`      // Variable set the run used
+	Warnings        []string          `json:"warnings,omitempty"`         // e.g. protected environment
+	Artifacts       []RunArtifact     `json:"artifacts,omitempty"`        // Files kept from the run's workspace
+	Workspace       string            `json:"-"`                          // Working directory while the run is active
+	Status          string            `json:"status"`                     // pending, waiting_lock, running, waiting_approval, completed, failed, cancelled, interrupted
+	Error           string            `json:"error,omitempty"`
+	Variables       map[string]string `json:"variables"`                  // Secret values are masked
+	SecretVariables []string          `json:"secret_variables,omitempty"` // Names of secret variables
+	Steps           []StepResult      `json:"steps"`
+	Logs            []ExecutionLog    `json:"logs"`
+	StartTime       string            `json:"start_time"`
+	EndTime         string            `json:"end_time,omitempty"`
+	DurationMs      int64             `json:"duration_ms,omitempty"`
+}
+
+// RunTrigger records what started a workflow run
+type RunTrigger struct {
+	Type       string `json:"type"`                  // schedule, webhook, workflow
+	ID         string `json:"id"`                    // Schedule, webhook or parent run ID
+	DeliveryID string `json:"delivery_id,omitempty"` // Webhook delivery
+}
+
+// StepResult records the outcome of one step within a workflow run
+type StepResult struct {
+	StepID     string `json:"step_id"`
+	Name       string `json:"name,omitempty"`
+	Type       string `json:"type"`
+	ParentID   string `json:"parent_id,omitempty"` // Enclosing parallel step or loop iteration
+	Status     string `json:"status"`              // running, success, failed, cancelled, skipped
+	ExitCode   int    `json:"exit_code"`
+	Output     string `json:"output,omitempty"`
+	Error      string `json:"error,omitempty"`
+	StartTime  string `json:"start_time,omitempty"`
+	EndTime    string `json:"end_time,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+
+	Attempts     []CommandAttempt `json:"attempts,omitempty"`      // Recorded for steps with a retry or timeout policy
+	ErrorIgnored bool             `json:"error_ignored,omitempty"` // Failed, but continue_on_error let the run go on
+}
+
+// RunArtifact is a file a step kept from the run's workspace
+type RunArtifact struct {
+	Path      string    `json:"path"` // <step_id>/<name>, used to download it
+	Name      string    `json:"name"` // Path within the workspace
+	StepID    string    `json:"step_id"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	CreatedAt time.Time `json:"created_at"`
+	Expired   bool      `json:"expired,omitempty"` // Removed by artifact retention
+}
+
+// RunRetention bounds how many workflow runs are kept
+type RunRetention struct {
+	MaxRunsPerWorkflow  int   `json:"max_runs_per_workflow"`  // 0 = unlimited
+	MaxAgeDays          int   `json:"max_age_days"`           // 0 = unlimited
+	ArtifactMaxAgeDays  int   `json:"artifact_max_age_days"`  // 0 = kept as long as the run
+	ArtifactMaxRunBytes int64 `json:"artifact_max_run_bytes"` // 0 = unlimited
+}
+
+// DryRunResult previews a workflow run without executing anything
+type DryRunResult struct {
+	WorkflowID       string            `json:"workflow_id"`
+	WorkflowName     string            `json:"workflow_name"`
+	Variables        map[string]string `json:"variables"`              // Secret values are masked
+	InputErrors      []string          `json:"input_errors,omitempty"` // Inputs a real run would reject
+	Environment      string            `json:"environment,omitempty"`
+	Warnings         []string          `json:"warnings,omitempty"` // e.g. protected environment
+	MissingVariables []string          `json:"missing_variables"`  // Across reachable steps
+	Steps            []DryRunStep      `json:"steps"`
+}
+
+// DryRunStep is the rendered form of one step in a dry run
+type DryRunStep struct {
+	StepID           string            `json:"step_id"`
+	Name             string            `json:"name,omitempty"`
+	Type             string            `json:"type"`
+	ParentID         string            `json:"parent_id,omitempty"` // Enclosing parallel or loop step
+	Command          string            `json:"command,omitempty"`
+	Highlights       []TemplateSpan    `json:"highlights,omitempty"` // Unresolved placeholders in Command
+	MissingVariables []string          `json:"missing_variables,omitempty"`
+	RuntimeVariables []string          `json:"runtime_variables,omitempty"` // Filled by step outputs while running
+	Reachable        bool              `json:"reachable"`
+	Branches         []DryRunBranch    `json:"branches,omitempty"`
+	WorkflowRef      string            `json:"workflow_ref,omitempty"`
+	With             map[string]string `json:"with,omitempty"` // Rendered native step parameters or workflow_ref inputs
+	Message          string            `json:"message,omitempty"`
+}
+
+// DryRunBranch describes where control can go after a step
+type DryRunBranch struct {
+	When   string `json:"when"`             // success, failure or a condition
+	Action string `json:"action"`           // continue, stop, jump_to, execute_step
+	Target string `json:"target,omitempty"` // Step ID; empty when the run ends
+}
+
+// CommandPreview is a rendered command template
+type CommandPreview struct {
+	Command    string         `json:"command"`
+	Unresolved []string       `json:"unresolved"`
+	Highlights []TemplateSpan `json:"highlights,omitempty"` // Unresolved placeholders in Command
+	Error      string         `json:"error,omitempty"`      // Malformed placeholder
+}
+
+// TemplateSpan marks a placeholder left in rendered text
+type TemplateSpan struct {
+	Start int    `json:"start"` // Byte offset
+	End   int    `json:"end"`
+	Name  string `json:"name"`
 }
 
 // ExecutionLog represents a single log entry during execution
 type ExecutionLog struct {
 	Timestamp string `json:"timestamp"`
 	StepID    string `json:"step_id,omitempty"`
+	Attempt   int    `json:"attempt,omitempty"`
 	Level     string `json:"level"` // info, warning, error
 	Message   string `json:"message"`
 	Output    string `json:"output,omitempty"`
@@ -75,8 +225,16 @@ type ExecutionLog struct {
 // GlobalVariable represents a reusable global variable
 type GlobalVariable struct {
 	Name        string    `json:"name"`
-	Value       string    `json:"value"`
+	Value       string    `json:"value"` // Masked when read back for secrets
 	Description string    `json:"description"`
+	Secret      bool      `json:"secret"` // Stored encrypted, masked in responses and logs
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
+
+// WorkflowBundle is a set of workflows exported or imported together
+type WorkflowBundle struct {
+	Version    int        `json:"version" yaml:"version"`
+	ExportedAt time.Time  `json:"exported_at" yaml:"exported_at,omitempty"`
+	Workflows  []Workflow `json:"workflows" yaml:"workflows"`
+}
@@ -0,0 +1,242 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/devopstools/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+const (
+	ApprovalApprove = "approve"
+	ApprovalReject  = "reject"
+
+	// maxDecidedApprovals bounds the decided requests kept in memory; the
+	// decision itself stays in the run log
+	maxDecidedApprovals = 200
+)
+
+var ErrNotApprover = fmt.Errorf("user is not allowed to decide this approval")
+
+// ApprovalService tracks approval gates waiting for a human decision
+type ApprovalService struct {
+	mu        sync.RWMutex
+	requests  map[string]*models.ApprovalRequest
+	decisions map[string]chan struct{} // closed when a pending request is decided
+	onChange  func(models.ApprovalRequest)
+}
+
+func NewApprovalService() *ApprovalService {
+	return &ApprovalService{
+		requests:  make(map[string]*models.ApprovalRequest),
+		decisions: make(map[string]chan struct{}),
+	}
+}
+
+// SetChangeCallback sets the callback invoked when a request is opened or decided
+func (s *ApprovalService) SetChangeCallback(callback func(models.ApprovalRequest)) {
+	s.onChange = callback
+}
+
+// Await opens an approval request and blocks until it is decided, times out
+// or ctx ends. The returned request holds the final decision.
+func (s *ApprovalService) Await(ctx context.Context, request models.ApprovalRequest, config *models.ApprovalConfig) (models.ApprovalRequest, error) {
+	if config == nil {
+		config = &models.ApprovalConfig{}
+	}
+	if err := ValidateApprovalConfig(config); err != nil {
+		return request, err
+	}
+
+	request.ID = uuid.New().String()
+	request.Status = "pending"
+	request.Approvers = config.Approvers
+	request.RequestedAt = time.Now()
+
+	var expired <-chan time.Time
+	if config.TimeoutMs > 0 {
+		timeout := time.Duration(config.TimeoutMs) * time.Millisecond
+		expiresAt := request.RequestedAt.Add(timeout)
+		request.ExpiresAt = &expiresAt
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	done := make(chan struct{})
+	s.mu.Lock()
+	stored := request
+	s.requests[request.ID] = &stored
+	s.decisions[request.ID] = done
+	s.mu.Unlock()
+	s.notify(request)
+
+	select {
+	case <-done:
+	case <-expired:
+		status := "timed_out"
+		if config.TimeoutAction == ApprovalApprove {
+			status = "approved"
+		}
+		s.close(request.ID, status, "", "")
+	case <-ctx.Done():
+		s.close(request.ID, "cancelled", "", "")
+	}
+
+	decided, err := s.Get(request.ID)
+	if err != nil {
+		return request, err
+	}
+	return *decided, ctx.Err()
+}
+
+// Decide approves or rejects a pending request on behalf of a user
+func (s *ApprovalService) Decide(id, decision, user, comment string) (*models.ApprovalRequest, error) {
+	var status string
+	switch decision {
+	case ApprovalApprove:
+		status = "approved"
+	case ApprovalReject:
+		status = "rejected"
+	default:
+		return nil, fmt.Errorf("invalid decision: %s (expected approve or reject)", decision)
+	}
+
+	s.mu.RLock()
+	request, ok := s.requests[id]
+	var approvers []string
+	var current string
+	if ok {
+		approvers = request.Approvers
+		current = request.Status
+	}
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("approval not found: %s", id)
+	}
+	if current != "pending" {
+		return nil, fmt.Errorf("approval already %s", current)
+	}
+	if len(approvers) > 0 && !containsString(approvers, user) {
+		return nil, ErrNotApprover
+	}
+
+	if !s.close(id, status, user, comment) {
+		return nil, fmt.Errorf("approval is no longer pending")
+	}
+	return s.Get(id)
+}
+
+// close records the outcome of a pending request, returning false if it was
+// already decided
+func (s *ApprovalService) close(id, status, user, comment string) bool {
+	s.mu.Lock()
+	request, ok := s.requests[id]
+	if !ok || request.Status != "pending" {
+		s.mu.Unlock()
+		return false
+	}
+
+	now := time.Now()
+	request.Status = status
+	request.DecidedBy = user
+	request.Comment = comment
+	request.DecidedAt = &now
+	if done, ok := s.decisions[id]; ok {
+		close(done)
+		delete(s.decisions, id)
+	}
+	s.pruneDecided()
+	decided := *request
+	s.mu.Unlock()
+
+	s.notify(decided)
+	return true
+}
+
+// pruneDecided drops the oldest decided requests. Callers hold s.mu.
+func (s *ApprovalService) pruneDecided() {
+	var decided []*models.ApprovalRequest
+	for _, request := range s.requests {
+		if request.Status != "pending" {
+			decided = append(decided, request)
+		}
+	}
+	if len(decided) <= maxDecidedApprovals {
+		return
+	}
+
+	sort.Slice(decided, func(i, j int) bool {
+		return decided[i].DecidedAt.Before(*decided[j].DecidedAt)
+	})
+	for _, request := range decided[:len(decided)-maxDecidedApprovals] {
+		delete(s.requests, request.ID)
+	}
+}
+
+// Get retrieves an approval request by ID
+func (s *ApprovalService) Get(id string) (*models.ApprovalRequest, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	request, ok := s.requests[id]
+	if !ok {
+		return nil, fmt.Errorf("approval not found: %s", id)
+	}
+	copied := *request
+	return &copied, nil
+}
+
+// List returns approval requests, optionally filtered by status, newest first
+func (s *ApprovalService) List(status string) []models.ApprovalRequest {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]models.ApprovalRequest, 0)
+	for _, request := range s.requests {
+		if status == "" || request.Status == status {
+			result = append(result, *request)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].RequestedAt.After(result[j].RequestedAt)
+	})
+	return result
+}
+
+func (s *ApprovalService) notify(request models.ApprovalRequest) {
+	if s.onChange != nil {
+		s.onChange(request)
+	}
+}
+
+// ValidateApprovalConfig checks the settings of an approval step
+func ValidateApprovalConfig(config *models.ApprovalConfig) error {
+	if config == nil {
+		return nil
+	}
+	if config.TimeoutMs < 0 {
+		return fmt.Errorf("approval timeout_ms must not be negative")
+	}
+	switch config.TimeoutAction {
+	case "", ApprovalReject, ApprovalApprove:
+	default:
+		return fmt.Errorf("invalid approval timeout_action: %s (expected reject or approve)", config.TimeoutAction)
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -44,7 +44,12 @@ func (s *ArgoCDService) ListApplications() ([]models.ArgoAppDetail, error) {
 
 // GetApplication gets details of a specific application
 func (s *ArgoCDService) GetApplication(appName string) (*models.ArgoAppDetail, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	return s.GetApplicationContext(context.Background(), appName)
+}
+
+// GetApplicationContext is GetApplication stopped early when ctx ends
+func (s *ArgoCDService) GetApplicationContext(ctx context.Context, appName string) (*models.ArgoAppDetail, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, "argocd", "app", "get", appName, "-o", "json")
@@ -63,7 +68,13 @@ func (s *ArgoCDService) GetApplication(appName string) (*models.ArgoAppDetail, e
 
 // SyncApplication syncs an application
 func (s *ArgoCDService) SyncApplication(appName string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	return s.SyncApplicationContext(context.Background(), appName)
+}
+
+// SyncApplicationContext is SyncApplication stopped early when ctx ends;
+// the argocd process is killed rather than left running
+func (s *ArgoCDService) SyncApplicationContext(ctx context.Context, appName string) error {
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, "argocd", "app", "sync", appName)
@@ -7,6 +7,7 @@ import (
 	"os/exec"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/devopstools/backend/internal/models"
@@ -18,6 +19,7 @@ type CommandService struct {
 	executions map[string]*models.CommandExecution
 	mu         sync.RWMutex
 	onOutput   func(execID string, output string)
+	onFinish   func(models.CommandExecution)
 }
 
 // NewCommandService creates a new command service
@@ -32,12 +34,38 @@ func (s *CommandService) SetOutputCallback(callback func(execID string, output s
 	s.onOutput = callback
 }
 
+// SetFinishCallback sets the callback invoked with an execution once it ends
+func (s *CommandService) SetFinishCallback(callback func(models.CommandExecution)) {
+	s.onFinish = callback
+}
+
 // Execute runs a command and streams output
 func (s *CommandService) Execute(ctx context.Context, userID, command string, args []string, workDir string) (*models.CommandExecution, error) {
+	return s.ExecuteWithPolicy(ctx, userID, command, args, workDir, nil, nil)
+}
+
+// ExecuteWithPolicy runs a command in the background applying retry and timeout policies
+func (s *CommandService) ExecuteWithPolicy(ctx context.Context, userID, command string, args []string, workDir string, retry *models.CommandRetry, timeout *models.CommandTimeout) (*models.CommandExecution, error) {
+	execution, err := s.Prepare(userID, command, args, workDir, retry, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// Run command in goroutine
+	go s.Run(ctx, execution)
+
+	return execution, nil
+}
+
+// Prepare validates a command and registers a pending execution for it
+func (s *CommandService) Prepare(userID, command string, args []string, workDir string, retry *models.CommandRetry, timeout *models.CommandTimeout) (*models.CommandExecution, error) {
 	// Validate command (security)
 	if !s.isCommandAllowed(command) {
 		return nil, fmt.Errorf("command not allowed: %s", command)
 	}
+	if err := ValidateExecutionPolicy(retry, timeout); err != nil {
+		return nil, err
+	}
 
 	execution := &models.CommandExecution{
 		ID:        uuid.New().String(),
@@ -45,6 +73,8 @@ func (s *CommandService) Execute(ctx context.Context, userID, command string, ar
 		Command:   command,
 		Args:      args,
 		WorkDir:   workDir,
+		Retry:     retry,
+		Timeout:   timeout,
 		Status:    "pending",
 		StartedAt: time.Now(),
 	}
@@ -53,12 +83,51 @@ func (s *CommandService) Execute(ctx context.Context, userID, command string, ar
 	s.executions[execution.ID] = execution
 	s.mu.Unlock()
 
-	// Run command in goroutine
-	go s.runCommand(ctx, execution)
-
 	return execution, nil
 }
 
+// Discard removes an execution that was prepared but never run
+func (s *CommandService) Discard(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.executions, id)
+}
+
+// Cancel marks a prepared execution as cancelled without running it
+func (s *CommandService) Cancel(execution *models.CommandExecution, reason string) {
+	s.mu.Lock()
+	endTime := time.Now()
+	execution.Status = "cancelled"
+	execution.Error = reason
+	execution.EndedAt = &endTime
+	s.mu.Unlock()
+
+	s.finished(execution)
+}
+
+// Fail marks a prepared execution as failed without running it
+func (s *CommandService) Fail(execution *models.CommandExecution, reason string) {
+	s.mu.Lock()
+	endTime := time.Now()
+	execution.Status = "failed"
+	execution.Error = reason
+	execution.EndedAt = &endTime
+	s.mu.Unlock()
+
+	s.finished(execution)
+}
+
+// finished passes a copy of an ended execution to the finish callback
+func (s *CommandService) finished(execution *models.CommandExecution) {
+	if s.onFinish == nil {
+		return
+	}
+	s.mu.RLock()
+	snapshot := *execution
+	s.mu.RUnlock()
+	s.onFinish(snapshot)
+}
+
 // GetExecution retrieves an execution by ID
 func (s *CommandService) GetExecution(id string) (*models.CommandExecution, error) {
 	s.mu.RLock()
@@ -71,11 +140,78 @@ func (s *CommandService) GetExecution(id string) (*models.CommandExecution, erro
 	return exec, nil
 }
 
-// runCommand executes the command and streams output
-func (s *CommandService) runCommand(ctx context.Context, execution *models.CommandExecution) {
+// Run executes a prepared execution and blocks until it finishes.
+// Failed attempts are retried according to the execution's retry policy.
+func (s *CommandService) Run(ctx context.Context, execution *models.CommandExecution) {
+	s.mu.Lock()
 	execution.Status = "running"
+	execution.StartedAt = time.Now()
+	s.mu.Unlock()
+
+	total := maxAttempts(execution.Retry)
+	var attempt models.CommandAttempt
+	for number := 1; ; number++ {
+		if total > 1 {
+			s.emitOutput(execution, fmt.Sprintf("[ATTEMPT %d/%d]\n", number, total))
+		}
+
+		attempt = s.runAttempt(ctx, execution, number)
+
+		s.mu.Lock()
+		execution.Attempts = append(execution.Attempts, attempt)
+		s.mu.Unlock()
 
-	cmd := exec.CommandContext(ctx, execution.Command, execution.Args...)
+		if attempt.Status == "success" || ctx.Err() != nil || !shouldRetry(execution.Retry, number, attempt.ExitCode) {
+			break
+		}
+
+		delay := retryDelay(execution.Retry, number)
+		s.emitOutput(execution, fmt.Sprintf("[RETRY] Attempt %d %s (exit code %d), retrying in %s\n", number, attempt.Status, attempt.ExitCode, delay))
+		if !waitForRetry(ctx, delay) {
+			break
+		}
+	}
+
+	s.mu.Lock()
+	endTime := time.Now()
+	execution.EndedAt = &endTime
+	execution.Duration = endTime.Sub(execution.StartedAt).Milliseconds()
+	execution.Status = attempt.Status
+	execution.ExitCode = attempt.ExitCode
+	execution.Error = attempt.Error
+	s.mu.Unlock()
+
+	s.finished(execution)
+}
+
+// runAttempt executes the command once and streams its output
+func (s *CommandService) runAttempt(ctx context.Context, execution *models.CommandExecution, number int) models.CommandAttempt {
+	attempt := models.CommandAttempt{
+		Number:    number,
+		StartedAt: time.Now(),
+	}
+	var output strings.Builder
+	var timedOut atomic.Bool
+
+	finish := func(status string, exitCode int, errMsg string) models.CommandAttempt {
+		endTime := time.Now()
+		attempt.EndedAt = &endTime
+		attempt.Duration = endTime.Sub(attempt.StartedAt).Milliseconds()
+		attempt.Status = status
+		attempt.ExitCode = exitCode
+		attempt.Error = errMsg
+		attempt.Output = output.String()
+		attempt.TimedOut = timedOut.Load()
+		return attempt
+	}
+
+	attemptCtx, cancel := withAttemptTimeout(ctx, execution.Timeout, func() {
+		timedOut.Store(true)
+		s.emitOutput(execution, fmt.Sprintf("[WARN] Command exceeded timeout of %dms, letting it continue\n", execution.Timeout.DurationMs))
+	})
+	defer cancel()
+
+	cmd := exec.CommandContext(attemptCtx, execution.Command, execution.Args...)
 	if execution.WorkDir != "" {
 		cmd.Dir = execution.WorkDir
 	}
@@ -83,36 +219,37 @@ func (s *CommandService) runCommand(ctx context.Context, execution *models.Comma
 	// Capture stdout and stderr
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		s.failExecution(execution, fmt.Sprintf("Failed to create stdout pipe: %v", err))
-		return
+		return finish("failed", -1, fmt.Sprintf("Failed to create stdout pipe: %v", err))
 	}
 
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		s.failExecution(execution, fmt.Sprintf("Failed to create stderr pipe: %v", err))
-		return
+		return finish("failed", -1, fmt.Sprintf("Failed to create stderr pipe: %v", err))
 	}
 
 	// Start command
 	if err := cmd.Start(); err != nil {
-		s.failExecution(execution, fmt.Sprintf("Failed to start command: %v", err))
-		return
+		return finish("failed", -1, fmt.Sprintf("Failed to start command: %v", err))
 	}
 
 	// Stream output
 	var wg sync.WaitGroup
+	var outputMu sync.Mutex
 	wg.Add(2)
 
+	stream := func(prefix string, line string) {
+		outputMu.Lock()
+		output.WriteString(prefix + line + "\n")
+		outputMu.Unlock()
+		s.emitOutput(execution, prefix+line+"\n")
+	}
+
 	// Read stdout
 	go func() {
 		defer wg.Done()
 		scanner := bufio.NewScanner(stdout)
 		for scanner.Scan() {
-			line := scanner.Text()
-			s.appendOutput(execution, line+"\n")
-			if s.onOutput != nil {
-				s.onOutput(execution.ID, line+"\n")
-			}
+			stream("", scanner.Text())
 		}
 	}()
 
@@ -121,11 +258,7 @@ func (s *CommandService) runCommand(ctx context.Context, execution *models.Comma
 		defer wg.Done()
 		scanner := bufio.NewScanner(stderr)
 		for scanner.Scan() {
-			line := scanner.Text()
-			s.appendOutput(execution, "[ERROR] "+line+"\n")
-			if s.onOutput != nil {
-				s.onOutput(execution.ID, "[ERROR] "+line+"\n")
-			}
+			stream("[ERROR] ", scanner.Text())
 		}
 	}()
 
@@ -134,19 +267,27 @@ func (s *CommandService) runCommand(ctx context.Context, execution *models.Comma
 
 	// Wait for command to finish
 	err = cmd.Wait()
-	endTime := time.Now()
-	execution.EndedAt = &endTime
-	execution.Duration = endTime.Sub(execution.StartedAt).Milliseconds()
+	if err == nil {
+		return finish("success", 0, "")
+	}
 
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			execution.ExitCode = exitErr.ExitCode()
-		}
-		execution.Status = "failed"
-		execution.Error = err.Error()
-	} else {
-		execution.Status = "success"
-		execution.ExitCode = 0
+	if attemptCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		timedOut.Store(true)
+		return finish("timeout", -1, fmt.Sprintf("command killed after timeout of %dms", execution.Timeout.DurationMs))
+	}
+
+	exitCode := -1
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		exitCode = exitErr.ExitCode()
+	}
+	return finish("failed", exitCode, err.Error())
+}
+
+// emitOutput appends output to the execution and forwards it to the stream callback
+func (s *CommandService) emitOutput(execution *models.CommandExecution, output string) {
+	s.appendOutput(execution, output)
+	if s.onOutput != nil {
+		s.onOutput(execution.ID, output)
 	}
 }
 
@@ -157,18 +298,6 @@ func (s *CommandService) appendOutput(execution *models.CommandExecution, output
 	execution.Output += output
 }
 
-// failExecution marks execution as failed
-func (s *CommandService) failExecution(execution *models.CommandExecution, errMsg string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	execution.Status = "failed"
-	execution.Error = errMsg
-	endTime := time.Now()
-	execution.EndedAt = &endTime
-	execution.Duration = endTime.Sub(execution.StartedAt).Milliseconds()
-}
-
 // isCommandAllowed validates if command is allowed (security)
 func (s *CommandService) isCommandAllowed(command string) bool {
 	// Whitelist of allowed commands
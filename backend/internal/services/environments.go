@@ -0,0 +1,366 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/devopstools/backend/internal/logger"
+	"github.com/devopstools/backend/internal/models"
+)
+
+var (
+	ErrEnvironmentNotFound = errors.New("environment not found")
+	ErrEnvironmentInUse    = errors.New("environment is the base of another environment")
+)
+
+var environmentName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// environmentSet is an environment and its own variables
+type environmentSet struct {
+	environment models.Environment
+	variables   map[string]storedVariable
+}
+
+// storedEnvironment is an environment as written to disk
+type storedEnvironment struct {
+	models.Environment
+	Variables []storedVariable `json:"variables"`
+}
+
+func (vs *VariableService) loadEnvironments() error {
+	var environments []storedEnvironment
+	if err := readDataFile(filepath.Join(vs.dataDir, "environments.json"), &environments); err != nil {
+		return err
+	}
+
+	for _, env := range environments {
+		set := &environmentSet{
+			environment: env.Environment,
+			variables:   make(map[string]storedVariable, len(env.Variables)),
+		}
+		for _, v := range env.Variables {
+			set.variables[v.Name] = v
+		}
+		vs.environments[env.Name] = set
+	}
+	return nil
+}
+
+// saveEnvironments writes environments and their variables to disk. Callers
+// hold vs.mu.
+func (vs *VariableService) saveEnvironments() error {
+	environments := make([]storedEnvironment, 0, len(vs.environments))
+	for _, set := range vs.environments {
+		env := storedEnvironment{Environment: set.environment, Variables: make([]storedVariable, 0, len(set.variables))}
+		for _, v := range set.variables {
+			env.Variables = append(env.Variables, v)
+		}
+		sort.Slice(env.Variables, func(i, j int) bool { return env.Variables[i].Name < env.Variables[j].Name })
+		environments = append(environments, env)
+	}
+	sort.Slice(environments, func(i, j int) bool { return environments[i].Name < environments[j].Name })
+
+	return writeDataFile(filepath.Join(vs.dataDir, "environments.json"), environments)
+}
+
+// ListEnvironments returns all environments in name order
+func (vs *VariableService) ListEnvironments() []models.Environment {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+
+	environments := make([]models.Environment, 0, len(vs.environments))
+	for _, set := range vs.environments {
+		environments = append(environments, set.environment)
+	}
+	sort.Slice(environments, func(i, j int) bool { return environments[i].Name < environments[j].Name })
+	return environments
+}
+
+// GetEnvironment returns an environment
+func (vs *VariableService) GetEnvironment(name string) (*models.Environment, error) {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+
+	set, ok := vs.environments[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrEnvironmentNotFound, name)
+	}
+	env := set.environment
+	return &env, nil
+}
+
+// SaveEnvironment creates or updates an environment. Its variables are kept.
+func (vs *VariableService) SaveEnvironment(env models.Environment) (*models.Environment, error) {
+	if !environmentName.MatchString(env.Name) {
+		return nil, fmt.Errorf("invalid environment name %q (letters, digits, '_', '.' and '-')", env.Name)
+	}
+	if env.Approval != nil && !env.Protected {
+		return nil, fmt.Errorf("approval requires a protected environment")
+	}
+	if err := ValidateApprovalConfig(env.Approval); err != nil {
+		return nil, err
+	}
+
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	// The base chain must end at the global variables
+	for base := env.Base; base != ""; {
+		if base == env.Name {
+			return nil, fmt.Errorf("environment %s cannot inherit from itself", env.Name)
+		}
+		set, ok := vs.environments[base]
+		if !ok {
+			return nil, fmt.Errorf("base %w: %s", ErrEnvironmentNotFound, base)
+		}
+		base = set.environment.Base
+	}
+
+	now := time.Now()
+	set, ok := vs.environments[env.Name]
+	if ok {
+		env.CreatedAt = set.environment.CreatedAt
+	} else {
+		env.CreatedAt = now
+		set = &environmentSet{variables: make(map[string]storedVariable)}
+	}
+	env.UpdatedAt = now
+	set.environment = env
+	vs.environments[env.Name] = set
+
+	if err := vs.saveEnvironments(); err != nil {
+		return nil, err
+	}
+	return &env, nil
+}
+
+// DeleteEnvironment removes an environment and its variables. Environments
+// other environments inherit from cannot be deleted.
+func (vs *VariableService) DeleteEnvironment(name string) error {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	if _, ok := vs.environments[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrEnvironmentNotFound, name)
+	}
+	for _, set := range vs.environments {
+		if set.environment.Base == name {
+			return fmt.Errorf("%w: %s", ErrEnvironmentInUse, set.environment.Name)
+		}
+	}
+	delete(vs.environments, name)
+
+	return vs.saveEnvironments()
+}
+
+// EnvironmentVariables returns the variables an environment sets itself, or
+// with resolved every variable a run in it sees, with where each value
+// comes from. Secret values are masked.
+func (vs *VariableService) EnvironmentVariables(name string, resolved bool) ([]models.ResolvedVariable, error) {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+
+	chain, err := vs.chain(name)
+	if err != nil {
+		return nil, err
+	}
+
+	variables := make(map[string]models.ResolvedVariable)
+	if resolved {
+		for varName, v := range vs.cache {
+			variables[varName] = models.ResolvedVariable{GlobalVariable: v.masked(), Source: "global"}
+		}
+	} else {
+		chain = chain[len(chain)-1:]
+	}
+	for _, set := range chain {
+		for varName, v := range set.variables {
+			variables[varName] = models.ResolvedVariable{GlobalVariable: v.masked(), Source: set.environment.Name}
+		}
+	}
+
+	result := make([]models.ResolvedVariable, 0, len(variables))
+	for _, v := range variables {
+		result = append(result, v)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// GetEnvironmentVariable returns a variable an environment sets itself, with
+// a secret value masked
+func (vs *VariableService) GetEnvironmentVariable(name, varName string) (*models.GlobalVariable, error) {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+
+	set, ok := vs.environments[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrEnvironmentNotFound, name)
+	}
+	v, ok := set.variables[varName]
+	if !ok {
+		return nil, fmt.Errorf("variable not found: %s", varName)
+	}
+	masked := v.masked()
+	return &masked, nil
+}
+
+// SetEnvironmentVariable creates or replaces a variable of an environment
+func (vs *VariableService) SetEnvironmentVariable(name string, variable models.GlobalVariable) error {
+	if variable.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	set, ok := vs.environments[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrEnvironmentNotFound, name)
+	}
+	stored, err := vs.encode(variable, set.variables)
+	if err != nil {
+		return err
+	}
+	set.variables[variable.Name] = stored
+
+	return vs.saveEnvironments()
+}
+
+// DeleteEnvironmentVariable removes a variable of an environment, so the
+// value from its base applies again
+func (vs *VariableService) DeleteEnvironmentVariable(name, varName string) error {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	set, ok := vs.environments[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrEnvironmentNotFound, name)
+	}
+	if _, ok := set.variables[varName]; !ok {
+		return fmt.Errorf("variable not found: %s", varName)
+	}
+	delete(set.variables, varName)
+
+	return vs.saveEnvironments()
+}
+
+// GetAllIn returns the decrypted variables of an environment, merged over its
+// bases and the global variables. An empty name means the global variables.
+func (vs *VariableService) GetAllIn(name string) (map[string]string, error) {
+	if name == "" {
+		return vs.GetAll(), nil
+	}
+
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+
+	chain, err := vs.chain(name)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]string)
+	vs.decryptInto(result, vs.cache)
+	for _, set := range chain {
+		vs.decryptInto(result, set.variables)
+	}
+	return result, nil
+}
+
+// SecretNamesIn returns the names of the secret variables a run in an
+// environment sees. A name stays secret when any set in the chain marks it
+// secret.
+func (vs *VariableService) SecretNamesIn(name string) ([]string, error) {
+	if name == "" {
+		return vs.SecretNames(), nil
+	}
+
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+
+	chain, err := vs.chain(name)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for varName, v := range vs.cache {
+		if v.Secret {
+			seen[varName] = true
+		}
+	}
+	for _, set := range chain {
+		for varName, v := range set.variables {
+			if v.Secret {
+				seen[varName] = true
+			}
+		}
+	}
+
+	names := make([]string, 0, len(seen))
+	for varName := range seen {
+		names = append(names, varName)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// KnownNames returns the names set as global variables or in any environment
+func (vs *VariableService) KnownNames() map[string]bool {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+
+	names := make(map[string]bool, len(vs.cache))
+	for name := range vs.cache {
+		names[name] = true
+	}
+	for _, set := range vs.environments {
+		for name := range set.variables {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// chain returns an environment and its bases, outermost base first. Callers
+// hold vs.mu.
+func (vs *VariableService) chain(name string) ([]*environmentSet, error) {
+	var chain []*environmentSet
+	for current := name; current != ""; {
+		set, ok := vs.environments[current]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrEnvironmentNotFound, current)
+		}
+		if len(chain) > len(vs.environments) {
+			return nil, fmt.Errorf("environment %s inherits from itself", name)
+		}
+		chain = append([]*environmentSet{set}, chain...)
+		current = set.environment.Base
+	}
+	return chain, nil
+}
+
+// decryptInto copies the values of a variable set into result, decrypting
+// secrets. Callers hold vs.mu.
+func (vs *VariableService) decryptInto(result map[string]string, set map[string]storedVariable) {
+	for name, v := range set {
+		if !v.Secret {
+			result[name] = v.Value
+			continue
+		}
+
+		value, err := vs.decrypt(v)
+		if err != nil {
+			logger.Error("Failed to decrypt secret variable", err, logger.WithFields(map[string]interface{}{
+				"variable": name,
+			}).Data)
+			continue
+		}
+		result[name] = value
+	}
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/devopstools/backend/internal/models"
+)
+
+const (
+	TimeoutActionKill     = "kill"
+	TimeoutActionContinue = "continue"
+)
+
+var ErrStepTimeout = errors.New("step timed out")
+
+// ValidateExecutionPolicy checks that retry and timeout settings are usable
+func ValidateExecutionPolicy(retry *models.CommandRetry, timeout *models.CommandTimeout) error {
+	if retry != nil {
+		if retry.MaxAttempts < 0 {
+			return fmt.Errorf("retry max_attempts must not be negative")
+		}
+		if retry.DelayMs < 0 {
+			return fmt.Errorf("retry delay_ms must not be negative")
+		}
+		if retry.Backoff != 0 && retry.Backoff < 1 {
+			return fmt.Errorf("retry backoff must be at least 1")
+		}
+	}
+
+	if timeout != nil {
+		if timeout.DurationMs <= 0 {
+			return fmt.Errorf("timeout duration_ms must be positive")
+		}
+		switch timeout.Action {
+		case "", TimeoutActionKill, TimeoutActionContinue:
+		default:
+			return fmt.Errorf("invalid timeout action: %s (expected kill or continue)", timeout.Action)
+		}
+	}
+
+	return nil
+}
+
+// maxAttempts returns how many times a command may run under the retry policy
+func maxAttempts(retry *models.CommandRetry) int {
+	if retry == nil || retry.MaxAttempts < 1 {
+		return 1
+	}
+	return retry.MaxAttempts
+}
+
+// shouldRetry reports whether a failed attempt may be retried.
+// Timeouts and start failures carry exit code -1, so they are only retried
+// when the policy does not restrict retries to specific exit codes.
+func shouldRetry(retry *models.CommandRetry, attempt int, exitCode int) bool {
+	if attempt >= maxAttempts(retry) {
+		return false
+	}
+	if len(retry.RetryOnExitCodes) == 0 {
+		return true
+	}
+	for _, code := range retry.RetryOnExitCodes {
+		if code == exitCode {
+			return true
+		}
+	}
+	return false
+}
+
+// retryDelay returns the wait before the attempt following the given one
+func retryDelay(retry *models.CommandRetry, attempt int) time.Duration {
+	if retry == nil || retry.DelayMs <= 0 {
+		return 0
+	}
+	delay := float64(retry.DelayMs)
+	if retry.Backoff > 1 {
+		delay *= math.Pow(retry.Backoff, float64(attempt-1))
+	}
+	return time.Duration(delay) * time.Millisecond
+}
+
+// waitForRetry sleeps for the retry delay, returning false if ctx ends first
+func waitForRetry(ctx context.Context, delay time.Duration) bool {
+	if delay <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// withAttemptTimeout derives the context for a single attempt.
+// With the kill action the context expires after the timeout; with the
+// continue action the process is left running and onTimeout is called instead.
+func withAttemptTimeout(ctx context.Context, timeout *models.CommandTimeout, onTimeout func()) (context.Context, context.CancelFunc) {
+	if timeout == nil || timeout.DurationMs <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	duration := time.Duration(timeout.DurationMs) * time.Millisecond
+	if timeout.Action == TimeoutActionContinue {
+		attemptCtx, cancel := context.WithCancel(ctx)
+		timer := time.AfterFunc(duration, onTimeout)
+		return attemptCtx, func() {
+			timer.Stop()
+			cancel()
+		}
+	}
+
+	return context.WithTimeout(ctx, duration)
+}
+
+// stepRetries reports whether a workflow step type honours its retry policy.
+// Groups, loops and approvals would repeat work or decisions, so they run once.
+func stepRetries(stepType string) bool {
+	return stepType == "command" || stepType == "workflow_ref" || IsNativeStep(stepType)
+}
+
+// validateStepPolicy checks the retry and timeout settings of a workflow step
+func validateStepPolicy(step models.Step) error {
+	if err := ValidateExecutionPolicy(step.Retry, step.Timeout); err != nil {
+		return err
+	}
+	if step.Timeout != nil && step.Timeout.Action == TimeoutActionContinue && step.Type != "command" {
+		return fmt.Errorf("timeout action continue is only supported by command steps")
+	}
+	return nil
+}
+
+// ignoreFailure reports whether a failed step lets its run, group or
+// iteration go on. Cancellation always stops it.
+func ignoreFailure(ctx context.Context, step models.Step, err error) bool {
+	return err != nil && step.ContinueOnError && ctx.Err() == nil
+}
@@ -0,0 +1,256 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devopstools/backend/internal/logger"
+	"github.com/devopstools/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// Lock policies: what a run does when a lock it declares is held
+const (
+	LockPolicyWait = "wait" // queue until the lock is released (default)
+	LockPolicyFail = "fail" // fail at once
+)
+
+var (
+	ErrLockHeld     = errors.New("lock is held")
+	ErrLockNotFound = errors.New("lock not found")
+)
+
+// LockHeldError reports the lock that stopped a run with the fail policy
+type LockHeldError struct {
+	Name   string
+	Holder models.LockHolder
+}
+
+func (e *LockHeldError) Error() string {
+	holder := e.Holder.ID
+	if e.Holder.Label != "" {
+		holder = fmt.Sprintf("%s (%s)", e.Holder.Label, e.Holder.ID)
+	}
+	return fmt.Sprintf("lock %s is held by %s %s", e.Name, e.Holder.Type, holder)
+}
+
+func (e *LockHeldError) Is(target error) bool {
+	return target == ErrLockHeld
+}
+
+// heldLock is an acquired lock and the token of the acquisition
+type heldLock struct {
+	token string
+	lock  models.Lock
+}
+
+// lockWaiter is a run blocked in Acquire
+type lockWaiter struct {
+	names  []string
+	holder models.LockHolder
+}
+
+// LockManager hands out named locks to workflow runs and commands. A run
+// takes all of its locks at once or none, so runs declaring the same locks
+// in any order cannot deadlock.
+type LockManager struct {
+	mu        sync.Mutex
+	held      map[string]*heldLock
+	waiting   []*lockWaiter
+	changed   chan struct{} // closed and replaced whenever a lock is released
+	onRelease func()
+}
+
+func NewLockManager() *LockManager {
+	return &LockManager{
+		held:    make(map[string]*heldLock),
+		changed: make(chan struct{}),
+	}
+}
+
+// SetReleaseCallback sets a function called after locks are released
+func (m *LockManager) SetReleaseCallback(callback func()) {
+	m.onRelease = callback
+}
+
+// ValidateLockPolicy checks a lock policy; empty means wait
+func ValidateLockPolicy(policy string) error {
+	switch policy {
+	case "", LockPolicyWait, LockPolicyFail:
+		return nil
+	}
+	return fmt.Errorf("invalid lock policy: %s (expected %s or %s)", policy, LockPolicyWait, LockPolicyFail)
+}
+
+// NormalizeLockNames trims, sorts and de-duplicates lock names
+func NormalizeLockNames(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	normalized := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		normalized = append(normalized, name)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// Acquire takes the named locks for holder. Locks already held by one of the
+// inherited holder IDs, such as the run that started a sub-workflow, count
+// as taken and are left to that holder. With the wait policy Acquire blocks
+// until every lock is free or ctx is done; with the fail policy it returns a
+// *LockHeldError. The returned function releases the locks.
+func (m *LockManager) Acquire(ctx context.Context, names []string, holder models.LockHolder, policy string, inherited []string) (func(), error) {
+	names = NormalizeLockNames(names)
+	token := uuid.New().String()
+	var waiter *lockWaiter
+
+	for {
+		m.mu.Lock()
+		var taken []string
+		var blocked *heldLock
+		for _, name := range names {
+			h, ok := m.held[name]
+			if !ok {
+				taken = append(taken, name)
+				continue
+			}
+			if !containsString(inherited, h.lock.Holder.ID) {
+				blocked = h
+				break
+			}
+		}
+
+		if blocked == nil {
+			now := time.Now()
+			for _, name := range taken {
+				m.held[name] = &heldLock{token: token, lock: models.Lock{Name: name, Holder: holder, AcquiredAt: now}}
+			}
+			m.removeWaiter(waiter)
+			m.mu.Unlock()
+			return func() { m.release(token, taken) }, nil
+		}
+
+		if policy == LockPolicyFail {
+			m.mu.Unlock()
+			return nil, &LockHeldError{Name: blocked.lock.Name, Holder: blocked.lock.Holder}
+		}
+		if waiter == nil {
+			waiter = &lockWaiter{names: names, holder: holder}
+			m.waiting = append(m.waiting, waiter)
+		}
+		changed := m.changed
+		m.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			m.mu.Lock()
+			m.removeWaiter(waiter)
+			m.mu.Unlock()
+			return nil, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Available reports whether none of the named locks is held
+func (m *LockManager) Available(names []string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, name := range names {
+		if _, ok := m.held[name]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// List returns the held locks in name order with the runs waiting for them
+func (m *LockManager) List() []models.Lock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	locks := make([]models.Lock, 0, len(m.held))
+	for _, h := range m.held {
+		lock := h.lock
+		for _, w := range m.waiting {
+			if containsString(w.names, lock.Name) {
+				lock.Waiting = append(lock.Waiting, w.holder)
+			}
+		}
+		locks = append(locks, lock)
+	}
+	sort.Slice(locks, func(i, j int) bool { return locks[i].Name < locks[j].Name })
+	return locks
+}
+
+// ForceRelease frees a lock regardless of its holder and returns what it
+// was. The holder keeps running; releasing its locks later does not affect
+// whoever takes the lock next.
+func (m *LockManager) ForceRelease(name string) (*models.Lock, error) {
+	m.mu.Lock()
+	h, ok := m.held[name]
+	if !ok {
+		m.mu.Unlock()
+		return nil, ErrLockNotFound
+	}
+	delete(m.held, name)
+	m.notify()
+	m.mu.Unlock()
+
+	logger.Warn("Lock force-released", logger.WithFields(map[string]interface{}{
+		"lock":        name,
+		"holder_type": h.lock.Holder.Type,
+		"holder_id":   h.lock.Holder.ID,
+	}).Data)
+	if m.onRelease != nil {
+		m.onRelease()
+	}
+	lock := h.lock
+	return &lock, nil
+}
+
+// release frees the locks of one acquisition that it still holds
+func (m *LockManager) release(token string, names []string) {
+	m.mu.Lock()
+	for _, name := range names {
+		if h, ok := m.held[name]; ok && h.token == token {
+			delete(m.held, name)
+		}
+	}
+	m.notify()
+	m.mu.Unlock()
+
+	if m.onRelease != nil {
+		m.onRelease()
+	}
+}
+
+// notify wakes waiting runs. Callers hold m.mu.
+func (m *LockManager) notify() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// removeWaiter drops a waiter once it has its locks or gave up. Callers
+// hold m.mu.
+func (m *LockManager) removeWaiter(waiter *lockWaiter) {
+	if waiter == nil {
+		return
+	}
+	for i, w := range m.waiting {
+		if w == waiter {
+			m.waiting = append(m.waiting[:i], m.waiting[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,725 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devopstools/backend/internal/crypto"
+	"github.com/devopstools/backend/internal/logger"
+	"github.com/devopstools/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// Notification channel types
+const (
+	ChannelWebhook = "webhook" // JSON POST of the event and message
+	ChannelSlack   = "slack"   // Slack-compatible incoming webhook
+	ChannelEmail   = "email"   // SMTP
+)
+
+// Notification event types
+const (
+	EventWorkflowRun = "workflow_run"
+	EventCommand     = "command"
+	EventScheduleRun = "schedule_run"
+)
+
+const (
+	// maxNotificationDeliveries bounds the delivery history kept
+	maxNotificationDeliveries = 500
+
+	defaultNotificationSubject = "[{STATUS|upper}] {NAME}"
+	defaultNotificationBody    = "{EVENT} {NAME}: {STATUS}\nID: {ID}\nTrigger: {TRIGGER|-}\nDuration: {DURATION|-}\nError: {ERROR|-}"
+)
+
+var (
+	ErrChannelNotFound = errors.New("notification channel not found")
+	ErrChannelInUse    = errors.New("notification channel is used by a rule")
+	ErrRuleNotFound    = errors.New("notification rule not found")
+)
+
+// defaultNotificationRetry applies to channels without a retry policy
+var defaultNotificationRetry = models.CommandRetry{MaxAttempts: 3, DelayMs: 2000, Backoff: 2}
+
+// notificationStatuses are the final statuses rules can match
+var notificationStatuses = map[string]bool{
+	"completed": true, "failed": true, "cancelled": true, "interrupted": true, // workflow runs
+	"success": true, "timeout": true, // commands, which also fail or are cancelled
+	"skipped": true, // schedule runs, which also complete or fail
+}
+
+// notificationTemplateVars are the placeholders message templates can use
+var notificationTemplateVars = []string{"EVENT", "STATUS", "ID", "NAME", "WORKFLOW_ID", "TRIGGER", "USER", "ERROR", "DURATION", "DURATION_MS", "TIME"}
+
+// NotificationService sends messages to webhook, Slack and email channels
+// when workflow runs, commands and scheduled activations end
+type NotificationService struct {
+	dataDir    string
+	key        []byte // encrypts channel secrets
+	parser     *TemplateParser
+	client     *http.Client
+	smtpTLS    *tls.Config // Base STARTTLS config; nil uses the system roots
+	mu         sync.RWMutex
+	channels   map[string]*storedChannel
+	rules      map[string]*models.NotificationRule
+	deliveries []*models.NotificationDelivery // oldest first
+	ctx        context.Context
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+// storedChannel is a channel as written to disk, with its secret encrypted
+type storedChannel struct {
+	models.NotificationChannel
+	IV string `json:"iv,omitempty"`
+}
+
+// notificationMessage is a rendered message
+type notificationMessage struct {
+	Subject string
+	Body    string
+}
+
+// NewNotificationService creates the service and loads persisted channels,
+// rules and delivery history
+func NewNotificationService(dataDir string, key []byte) (*NotificationService, error) {
+	// Templates only see the event, never the server environment
+	parser := NewTemplateParser()
+	parser.lookupEnv = func(string) (string, bool) { return "", false }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &NotificationService{
+		dataDir:  dataDir,
+		key:      key,
+		parser:   parser,
+		client:   &http.Client{Timeout: 15 * time.Second},
+		channels: make(map[string]*storedChannel),
+		rules:    make(map[string]*models.NotificationRule),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	var channels []*storedChannel
+	if err := readDataFile(filepath.Join(dataDir, "notification_channels.json"), &channels); err != nil {
+		return nil, err
+	}
+	for _, channel := range channels {
+		s.channels[channel.ID] = channel
+	}
+
+	var rules []*models.NotificationRule
+	if err := readDataFile(filepath.Join(dataDir, "notification_rules.json"), &rules); err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		s.rules[rule.ID] = rule
+	}
+
+	if err := readDataFile(filepath.Join(dataDir, "notification_deliveries.json"), &s.deliveries); err != nil {
+		return nil, err
+	}
+	for _, delivery := range s.deliveries {
+		if delivery.Status == "pending" {
+			delivery.Status = "failed"
+			delivery.Error = "server restarted before the notification was sent"
+		}
+	}
+
+	return s, nil
+}
+
+// Stop abandons pending retries and waits for deliveries in flight
+func (s *NotificationService) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// save persists channels, rules and delivery history. Callers hold s.mu.
+func (s *NotificationService) save() error {
+	channels := make([]*storedChannel, 0, len(s.channels))
+	for _, channel := range s.channels {
+		channels = append(channels, channel)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].CreatedAt.Before(channels[j].CreatedAt) })
+
+	rules := make([]*models.NotificationRule, 0, len(s.rules))
+	for _, rule := range s.rules {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].CreatedAt.Before(rules[j].CreatedAt) })
+
+	if err := writeDataFile(filepath.Join(s.dataDir, "notification_channels.json"), channels); err != nil {
+		return err
+	}
+	if err := writeDataFile(filepath.Join(s.dataDir, "notification_rules.json"), rules); err != nil {
+		return err
+	}
+	return writeDataFile(filepath.Join(s.dataDir, "notification_deliveries.json"), s.deliveries)
+}
+
+// validateChannel normalises and checks a channel definition
+func validateChannel(channel *models.NotificationChannel) error {
+	if channel.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	switch channel.Type {
+	case ChannelWebhook, ChannelSlack:
+		u, err := url.Parse(channel.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("url must be an http or https URL")
+		}
+	case ChannelEmail:
+		if channel.SMTPHost == "" {
+			return fmt.Errorf("smtp_host is required")
+		}
+		if channel.SMTPPort == 0 {
+			channel.SMTPPort = 25
+		}
+		if channel.SMTPPort < 0 || channel.SMTPPort > 65535 {
+			return fmt.Errorf("invalid smtp_port: %d", channel.SMTPPort)
+		}
+		if !strings.Contains(channel.From, "@") || strings.ContainsAny(channel.From, "\r\n") {
+			return fmt.Errorf("from must be an email address")
+		}
+		if len(channel.To) == 0 {
+			return fmt.Errorf("to needs at least one recipient")
+		}
+		for _, to := range channel.To {
+			if !strings.Contains(to, "@") || strings.ContainsAny(to, "\r\n,") {
+				return fmt.Errorf("invalid recipient: %q", to)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid type: %s (expected webhook, slack or email)", channel.Type)
+	}
+
+	for name, value := range channel.Headers {
+		if strings.ContainsAny(name+value, "\r\n") {
+			return fmt.Errorf("header %s contains a line break", name)
+		}
+	}
+	return ValidateExecutionPolicy(channel.Retry, nil)
+}
+
+// ListChannels returns all channels with their secrets masked
+func (s *NotificationService) ListChannels() []models.NotificationChannel {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	channels := make([]models.NotificationChannel, 0, len(s.channels))
+	for _, channel := range s.channels {
+		channels = append(channels, channel.masked())
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].CreatedAt.Before(channels[j].CreatedAt) })
+	return channels
+}
+
+// GetChannel returns a channel with its secret masked
+func (s *NotificationService) GetChannel(id string) (*models.NotificationChannel, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	channel, ok := s.channels[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrChannelNotFound, id)
+	}
+	masked := channel.masked()
+	return &masked, nil
+}
+
+// SaveChannel creates or replaces a channel. Without a secret, or with the
+// masked value, the current secret is kept.
+func (s *NotificationService) SaveChannel(channel models.NotificationChannel) (*models.NotificationChannel, error) {
+	if err := validateChannel(&channel); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if channel.ID == "" {
+		channel.ID = uuid.New().String()
+	}
+	existing, ok := s.channels[channel.ID]
+	if ok {
+		channel.CreatedAt = existing.CreatedAt
+	} else {
+		channel.CreatedAt = now
+	}
+	channel.UpdatedAt = now
+
+	stored := &storedChannel{NotificationChannel: channel}
+	switch {
+	case channel.Secret == "" || channel.Secret == secretMask:
+		stored.Secret, stored.IV = "", ""
+		if ok {
+			stored.Secret, stored.IV = existing.Secret, existing.IV
+		}
+	default:
+		ciphertext, iv, err := crypto.Encrypt(channel.Secret, s.key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt channel secret: %w", err)
+		}
+		stored.Secret, stored.IV = ciphertext, iv
+	}
+
+	s.channels[channel.ID] = stored
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+
+	result := stored.masked()
+	return &result, nil
+}
+
+// DeleteChannel removes a channel that no rule sends to
+func (s *NotificationService) DeleteChannel(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.channels[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrChannelNotFound, id)
+	}
+	for _, rule := range s.rules {
+		if containsString(rule.Channels, id) {
+			return fmt.Errorf("%w: %s", ErrChannelInUse, rule.Name)
+		}
+	}
+	delete(s.channels, id)
+
+	return s.save()
+}
+
+// validateRule normalises and checks a rule definition. Callers hold s.mu.
+func (s *NotificationService) validateRule(rule *models.NotificationRule) error {
+	if rule.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(rule.Channels) == 0 {
+		return fmt.Errorf("channels needs at least one channel")
+	}
+	for _, id := range rule.Channels {
+		if _, ok := s.channels[id]; !ok {
+			return fmt.Errorf("%w: %s", ErrChannelNotFound, id)
+		}
+	}
+
+	for _, event := range rule.Events {
+		switch event {
+		case EventWorkflowRun, EventCommand, EventScheduleRun:
+		default:
+			return fmt.Errorf("invalid event: %s (expected workflow_run, command or schedule_run)", event)
+		}
+	}
+	for _, status := range rule.Statuses {
+		if !notificationStatuses[status] {
+			return fmt.Errorf("invalid status: %s", status)
+		}
+	}
+	for _, trigger := range rule.Triggers {
+		switch trigger {
+		case "manual", "schedule", "webhook", "workflow":
+		default:
+			return fmt.Errorf("invalid trigger: %s (expected manual, schedule, webhook or workflow)", trigger)
+		}
+	}
+
+	if err := s.checkTemplate(rule.Subject); err != nil {
+		return fmt.Errorf("subject: %w", err)
+	}
+	if err := s.checkTemplate(rule.Body); err != nil {
+		return fmt.Errorf("body: %w", err)
+	}
+	return nil
+}
+
+// checkTemplate rejects malformed placeholders and ones no event provides
+func (s *NotificationService) checkTemplate(template string) error {
+	placeholders, errs := s.parser.placeholders(template)
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	for _, p := range placeholders {
+		if p.Env {
+			return fmt.Errorf("{env:%s}: environment variables are not available in notifications", p.Name)
+		}
+		if !containsString(notificationTemplateVars, p.Name) {
+			return fmt.Errorf("unknown placeholder {%s} (available: %s)", p.Name, strings.Join(notificationTemplateVars, ", "))
+		}
+	}
+	return nil
+}
+
+// ListRules returns all rules
+func (s *NotificationService) ListRules() []models.NotificationRule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rules := make([]models.NotificationRule, 0, len(s.rules))
+	for _, rule := range s.rules {
+		rules = append(rules, *rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].CreatedAt.Before(rules[j].CreatedAt) })
+	return rules
+}
+
+// GetRule returns a rule
+func (s *NotificationService) GetRule(id string) (*models.NotificationRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rule, ok := s.rules[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrRuleNotFound, id)
+	}
+	result := *rule
+	return &result, nil
+}
+
+// SaveRule creates or replaces a rule
+func (s *NotificationService) SaveRule(rule models.NotificationRule) (*models.NotificationRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.validateRule(&rule); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if rule.ID == "" {
+		rule.ID = uuid.New().String()
+	}
+	if existing, ok := s.rules[rule.ID]; ok {
+		rule.CreatedAt = existing.CreatedAt
+	} else {
+		rule.CreatedAt = now
+	}
+	rule.UpdatedAt = now
+
+	s.rules[rule.ID] = &rule
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+
+	result := rule
+	return &result, nil
+}
+
+// DeleteRule removes a rule
+func (s *NotificationService) DeleteRule(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.rules[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrRuleNotFound, id)
+	}
+	delete(s.rules, id)
+
+	return s.save()
+}
+
+// ListDeliveries returns the delivery history, newest first, optionally only
+// for one channel or rule
+func (s *NotificationService) ListDeliveries(channelID, ruleID string) []models.NotificationDelivery {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	deliveries := make([]models.NotificationDelivery, 0)
+	for i := len(s.deliveries) - 1; i >= 0; i-- {
+		delivery := s.deliveries[i]
+		if (channelID != "" && delivery.ChannelID != channelID) || (ruleID != "" && delivery.RuleID != ruleID) {
+			continue
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+	return deliveries
+}
+
+// Notify sends the messages of every enabled rule that matches an event.
+// Messages are delivered in the background; Notify does not block.
+func (s *NotificationService) Notify(event models.NotificationEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rules := make([]*models.NotificationRule, 0)
+	for _, rule := range s.rules {
+		if rule.Enabled && ruleMatches(rule, event) {
+			rules = append(rules, rule)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].CreatedAt.Before(rules[j].CreatedAt) })
+
+	queued := false
+	for _, rule := range rules {
+		message := s.render(rule.Subject, rule.Body, event)
+		for _, channelID := range rule.Channels {
+			channel, ok := s.channels[channelID]
+			if !ok || !channel.Enabled {
+				continue
+			}
+			delivery := s.newDelivery(rule.ID, channelID, event, message)
+			retry := defaultNotificationRetry
+			if channel.Retry != nil {
+				retry = *channel.Retry
+			}
+
+			s.wg.Add(1)
+			go func(channel storedChannel) {
+				defer s.wg.Done()
+				s.deliver(s.ctx, delivery, channel, message, &retry)
+			}(*channel)
+			queued = true
+		}
+	}
+
+	if queued {
+		if err := s.save(); err != nil {
+			logger.Error("Failed to save notification deliveries", err)
+		}
+	}
+}
+
+// TestChannel sends a test message to a channel once, without retries, and
+// returns the delivery
+func (s *NotificationService) TestChannel(ctx context.Context, id string) (*models.NotificationDelivery, error) {
+	s.mu.Lock()
+	stored, ok := s.channels[id]
+	if !ok {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrChannelNotFound, id)
+	}
+	channel := *stored
+
+	now := time.Now()
+	event := models.NotificationEvent{
+		Type:   "test",
+		Status: "test",
+		ID:     uuid.New().String(),
+		Name:   "Test notification for " + channel.Name,
+		Time:   now,
+	}
+	message := notificationMessage{
+		Subject: "Test notification",
+		Body:    fmt.Sprintf("This is a test message for the %s channel %s.", channel.Type, channel.Name),
+	}
+	delivery := s.newDelivery("", id, event, message)
+	if err := s.save(); err != nil {
+		logger.Error("Failed to save notification deliveries", err)
+	}
+	s.mu.Unlock()
+
+	s.deliver(ctx, delivery, channel, message, nil)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := *delivery
+	return &result, nil
+}
+
+// newDelivery records a pending delivery, dropping the oldest. Callers hold s.mu.
+func (s *NotificationService) newDelivery(ruleID, channelID string, event models.NotificationEvent, message notificationMessage) *models.NotificationDelivery {
+	delivery := &models.NotificationDelivery{
+		ID:        uuid.New().String(),
+		RuleID:    ruleID,
+		ChannelID: channelID,
+		Event:     event,
+		Subject:   message.Subject,
+		Status:    "pending",
+		CreatedAt: time.Now(),
+	}
+	s.deliveries = append(s.deliveries, delivery)
+	if len(s.deliveries) > maxNotificationDeliveries {
+		s.deliveries = s.deliveries[len(s.deliveries)-maxNotificationDeliveries:]
+	}
+	return delivery
+}
+
+// deliver sends a message to a channel, retrying failed attempts under the
+// retry policy, and records the outcome on the delivery
+func (s *NotificationService) deliver(ctx context.Context, delivery *models.NotificationDelivery, channel storedChannel, message notificationMessage, retry *models.CommandRetry) {
+	secret := ""
+	if channel.Secret != "" {
+		var err error
+		if secret, err = crypto.Decrypt(channel.Secret, channel.IV, s.key); err != nil {
+			s.finishDelivery(delivery, 0, fmt.Errorf("failed to decrypt channel secret: %w", err))
+			return
+		}
+	}
+
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		err = s.send(ctx, channel.NotificationChannel, secret, delivery, message)
+		if err == nil || ctx.Err() != nil || attempt >= maxAttempts(retry) {
+			break
+		}
+
+		s.mu.Lock()
+		delivery.Attempts = attempt
+		delivery.Error = err.Error()
+		s.mu.Unlock()
+		if !waitForRetry(ctx, retryDelay(retry, attempt)) {
+			break
+		}
+	}
+	s.finishDelivery(delivery, attempt, err)
+}
+
+// finishDelivery records the outcome of a delivery
+func (s *NotificationService) finishDelivery(delivery *models.NotificationDelivery, attempts int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delivery.Attempts = attempts
+	if err != nil {
+		delivery.Status = "failed"
+		delivery.Error = err.Error()
+		logger.Warn("Notification delivery failed", logger.WithFields(map[string]interface{}{
+			"delivery_id": delivery.ID,
+			"channel_id":  delivery.ChannelID,
+			"rule_id":     delivery.RuleID,
+			"attempts":    attempts,
+			"error":       err.Error(),
+		}).Data)
+	} else {
+		now := time.Now()
+		delivery.Status = "sent"
+		delivery.Error = ""
+		delivery.SentAt = &now
+	}
+
+	if err := s.save(); err != nil {
+		logger.Error("Failed to save notification deliveries", err)
+	}
+}
+
+// render fills a rule's subject and body templates, or the defaults, with an event
+func (s *NotificationService) render(subject, body string, event models.NotificationEvent) notificationMessage {
+	if subject == "" {
+		subject = defaultNotificationSubject
+	}
+	if body == "" {
+		body = defaultNotificationBody
+	}
+
+	values := map[string]string{
+		"EVENT":       event.Type,
+		"STATUS":      event.Status,
+		"ID":          event.ID,
+		"NAME":        event.Name,
+		"WORKFLOW_ID": event.WorkflowID,
+		"TRIGGER":     event.Trigger,
+		"USER":        event.UserID,
+		"ERROR":       event.Error,
+		"DURATION":    "",
+		"DURATION_MS": "",
+		"TIME":        event.Time.Format(time.RFC3339),
+	}
+	if event.DurationMs > 0 {
+		values["DURATION"] = (time.Duration(event.DurationMs) * time.Millisecond).Round(time.Second).String()
+		values["DURATION_MS"] = strconv.FormatInt(event.DurationMs, 10)
+	}
+
+	message := notificationMessage{Subject: subject, Body: body}
+	if rendered, err := s.parser.Render(subject, values, RenderOptions{}); err == nil {
+		message.Subject = rendered.Text
+	}
+	if rendered, err := s.parser.Render(body, values, RenderOptions{}); err == nil {
+		message.Body = rendered.Text
+	}
+	// A subject is one line, whatever the event name holds
+	message.Subject = strings.Join(strings.Fields(message.Subject), " ")
+	return message
+}
+
+// ruleMatches reports whether an event passes a rule's filters
+func ruleMatches(rule *models.NotificationRule, event models.NotificationEvent) bool {
+	if len(rule.Events) > 0 && !containsString(rule.Events, event.Type) {
+		return false
+	}
+	if len(rule.Statuses) > 0 && !containsString(rule.Statuses, event.Status) {
+		return false
+	}
+	if len(rule.WorkflowIDs) > 0 && !containsString(rule.WorkflowIDs, event.WorkflowID) {
+		return false
+	}
+	if len(rule.Triggers) > 0 && (event.Type != EventWorkflowRun || !containsString(rule.Triggers, event.Trigger)) {
+		return false
+	}
+	return true
+}
+
+// masked returns the channel as shown to API clients
+func (c storedChannel) masked() models.NotificationChannel {
+	channel := c.NotificationChannel
+	if channel.Secret != "" {
+		channel.Secret = secretMask
+	}
+	return channel
+}
+
+// WorkflowRunEvent describes a finished workflow run
+func WorkflowRunEvent(run models.WorkflowExecution) models.NotificationEvent {
+	trigger := "manual"
+	if run.Trigger != nil {
+		trigger = run.Trigger.Type
+	}
+	return models.NotificationEvent{
+		Type:       EventWorkflowRun,
+		Status:     run.Status,
+		ID:         run.ID,
+		Name:       run.WorkflowName,
+		WorkflowID: run.WorkflowID,
+		Trigger:    trigger,
+		Error:      run.Error,
+		DurationMs: run.DurationMs,
+		Time:       time.Now(),
+	}
+}
+
+// CommandEvent describes a finished command execution
+func CommandEvent(execution models.CommandExecution) models.NotificationEvent {
+	name := strings.TrimSpace(execution.Command + " " + strings.Join(execution.Args, " "))
+	return models.NotificationEvent{
+		Type:       EventCommand,
+		Status:     execution.Status,
+		ID:         execution.ID,
+		Name:       name,
+		UserID:     execution.UserID,
+		Error:      execution.Error,
+		DurationMs: execution.Duration,
+		Time:       time.Now(),
+	}
+}
+
+// ScheduleRunEvent describes a finished or skipped scheduled activation
+func ScheduleRunEvent(schedule models.Schedule, run models.ScheduleRun) models.NotificationEvent {
+	event := models.NotificationEvent{
+		Type:    EventScheduleRun,
+		Status:  run.Status,
+		ID:      run.ID,
+		Name:    schedule.Name,
+		Trigger: run.Trigger,
+		Error:   run.Error,
+		Time:    time.Now(),
+	}
+	if run.TargetType == "workflow" {
+		event.WorkflowID = run.TargetID
+	}
+	if run.StartedAt != nil && run.EndedAt != nil {
+		event.DurationMs = run.EndedAt.Sub(*run.StartedAt).Milliseconds()
+	}
+	return event
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devopstools/backend/internal/models"
+)
+
+// smtpTimeout bounds a whole SMTP conversation
+const smtpTimeout = 30 * time.Second
+
+// send makes one attempt to deliver a message to a channel
+func (s *NotificationService) send(ctx context.Context, channel models.NotificationChannel, secret string, delivery *models.NotificationDelivery, message notificationMessage) error {
+	switch channel.Type {
+	case ChannelWebhook:
+		payload := map[string]interface{}{
+			"delivery_id": delivery.ID,
+			"subject":     message.Subject,
+			"text":        message.Body,
+			"event":       delivery.Event,
+		}
+		return s.post(ctx, channel, secret, delivery.ID, payload)
+	case ChannelSlack:
+		payload := map[string]string{
+			"text": fmt.Sprintf("*%s*\n%s", message.Subject, message.Body),
+		}
+		return s.post(ctx, channel, "", delivery.ID, payload)
+	case ChannelEmail:
+		return s.sendEmail(ctx, channel, secret, message)
+	}
+	return fmt.Errorf("unknown channel type: %s", channel.Type)
+}
+
+// post sends a JSON payload. With a secret the body is signed like inbound
+// webhooks expect by default: X-Hub-Signature-256: sha256=<hex HMAC>.
+func (s *NotificationService) post(ctx context.Context, channel models.NotificationChannel, secret, deliveryID string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, channel.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, value := range channel.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if channel.Type == ChannelWebhook {
+		req.Header.Set("X-Delivery-ID", deliveryID)
+	}
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set(defaultSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// sendEmail delivers a plain text message over SMTP, upgrading to TLS when
+// the server offers STARTTLS and authenticating when a username is set
+func (s *NotificationService) sendEmail(ctx context.Context, channel models.NotificationChannel, password string, message notificationMessage) error {
+	addr := net.JoinHostPort(channel.SMTPHost, strconv.Itoa(channel.SMTPPort))
+	dialer := net.Dialer{Timeout: smtpTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	client, err := smtp.NewClient(conn, channel.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		config := &tls.Config{}
+		if s.smtpTLS != nil {
+			config = s.smtpTLS.Clone()
+		}
+		config.ServerName = channel.SMTPHost
+		if err := client.StartTLS(config); err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+	if channel.SMTPUsername != "" {
+		if err := client.Auth(smtp.PlainAuth("", channel.SMTPUsername, password, channel.SMTPHost)); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+
+	if err := client.Mail(channel.From); err != nil {
+		return err
+	}
+	for _, to := range channel.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s: %w", to, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", channel.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(channel.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(strings.ReplaceAll(message.Body, "\r\n", "\n"), "\n", "\r\n"))
+	msg.WriteString("\r\n")
+
+	if _, err := io.WriteString(w, msg.String()); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,488 @@
+package services
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/devopstools/backend/internal/crypto"
+	"github.com/devopstools/backend/internal/models"
+)
+
+func newTestNotificationService(t *testing.T) *NotificationService {
+	t.Helper()
+	s, err := NewNotificationService(t.TempDir(), crypto.DeriveKey("test", []byte("notification-salt")))
+	if err != nil {
+		t.Fatalf("NewNotificationService: %v", err)
+	}
+	t.Cleanup(s.Stop)
+	return s
+}
+
+func saveTestChannel(t *testing.T, s *NotificationService, channel models.NotificationChannel) *models.NotificationChannel {
+	t.Helper()
+	channel.Enabled = true
+	saved, err := s.SaveChannel(channel)
+	if err != nil {
+		t.Fatalf("SaveChannel: %v", err)
+	}
+	return saved
+}
+
+// capturedRequest is a request received by a stand-in HTTP server
+type capturedRequest struct {
+	header http.Header
+	body   []byte
+	at     time.Time
+}
+
+// httpStandIn records requests and answers them with the given statuses in
+// turn, then with 200
+func httpStandIn(t *testing.T, statuses ...int) (*httptest.Server, func() []capturedRequest) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []capturedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, capturedRequest{header: r.Header.Clone(), body: body, at: time.Now()})
+		n := len(requests)
+		mu.Unlock()
+
+		if n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+			fmt.Fprint(w, "unavailable")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []capturedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]capturedRequest(nil), requests...)
+	}
+}
+
+func TestWebhookChannelSignsBody(t *testing.T) {
+	s := newTestNotificationService(t)
+	server, requests := httpStandIn(t)
+	channel := saveTestChannel(t, s, models.NotificationChannel{
+		Name:    "hook",
+		Type:    ChannelWebhook,
+		URL:     server.URL,
+		Secret:  "s3cret",
+		Headers: map[string]string{"X-Team": "ops"},
+	})
+
+	delivery, err := s.TestChannel(t.Context(), channel.ID)
+	if err != nil {
+		t.Fatalf("TestChannel: %v", err)
+	}
+	if delivery.Status != "sent" || delivery.Attempts != 1 {
+		t.Fatalf("delivery = %s after %d attempts (%s), want sent after 1", delivery.Status, delivery.Attempts, delivery.Error)
+	}
+
+	got := requests()
+	if len(got) != 1 {
+		t.Fatalf("got %d requests, want 1", len(got))
+	}
+	req := got[0]
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(req.body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if signature := req.header.Get(defaultSignatureHeader); signature != want {
+		t.Errorf("%s = %q, want %q", defaultSignatureHeader, signature, want)
+	}
+	if id := req.header.Get("X-Delivery-ID"); id != delivery.ID {
+		t.Errorf("X-Delivery-ID = %q, want %q", id, delivery.ID)
+	}
+	if team := req.header.Get("X-Team"); team != "ops" {
+		t.Errorf("X-Team = %q, want ops", team)
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(req.body, &payload); err != nil {
+		t.Fatalf("payload is not JSON: %v", err)
+	}
+	if payload["delivery_id"] != delivery.ID || payload["subject"] != "Test notification" {
+		t.Errorf("unexpected payload: %s", req.body)
+	}
+}
+
+func TestSlackChannelPostsText(t *testing.T) {
+	s := newTestNotificationService(t)
+	server, requests := httpStandIn(t)
+	channel := saveTestChannel(t, s, models.NotificationChannel{Name: "slack", Type: ChannelSlack, URL: server.URL})
+
+	delivery, err := s.TestChannel(t.Context(), channel.ID)
+	if err != nil {
+		t.Fatalf("TestChannel: %v", err)
+	}
+	if delivery.Status != "sent" {
+		t.Fatalf("delivery = %s (%s), want sent", delivery.Status, delivery.Error)
+	}
+
+	got := requests()
+	if len(got) != 1 {
+		t.Fatalf("got %d requests, want 1", len(got))
+	}
+	var payload map[string]string
+	if err := json.Unmarshal(got[0].body, &payload); err != nil {
+		t.Fatalf("payload is not JSON: %v", err)
+	}
+	if !strings.HasPrefix(payload["text"], "*Test notification*\n") {
+		t.Errorf("text = %q, want it to start with the bold subject", payload["text"])
+	}
+	if signature := got[0].header.Get(defaultSignatureHeader); signature != "" {
+		t.Errorf("slack message is signed: %q", signature)
+	}
+}
+
+func TestDefaultNotificationRetry(t *testing.T) {
+	retry := defaultNotificationRetry
+	if n := maxAttempts(&retry); n != 3 {
+		t.Errorf("maxAttempts = %d, want 3", n)
+	}
+	for attempt, want := range map[int]time.Duration{1: 2 * time.Second, 2: 4 * time.Second} {
+		if got := retryDelay(&retry, attempt); got != want {
+			t.Errorf("retryDelay after attempt %d = %v, want %v", attempt, got, want)
+		}
+	}
+}
+
+// notifyFailedCommand sends a failed command event through a rule to a
+// channel and waits for the delivery to finish
+func notifyFailedCommand(t *testing.T, s *NotificationService, channelID string) models.NotificationDelivery {
+	t.Helper()
+	if _, err := s.SaveRule(models.NotificationRule{
+		Name:     "failures",
+		Events:   []string{EventCommand},
+		Statuses: []string{"failed"},
+		Channels: []string{channelID},
+		Enabled:  true,
+	}); err != nil {
+		t.Fatalf("SaveRule: %v", err)
+	}
+
+	s.Notify(models.NotificationEvent{Type: EventCommand, Status: "failed", ID: "exec-1", Name: "kubectl get pods", Time: time.Now()})
+	s.wg.Wait()
+
+	deliveries := s.ListDeliveries(channelID, "")
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(deliveries))
+	}
+	return deliveries[0]
+}
+
+func TestNotifyRetriesWithBackoff(t *testing.T) {
+	saved := defaultNotificationRetry
+	defaultNotificationRetry = models.CommandRetry{MaxAttempts: saved.MaxAttempts, DelayMs: 20, Backoff: saved.Backoff}
+	t.Cleanup(func() { defaultNotificationRetry = saved })
+
+	s := newTestNotificationService(t)
+	server, requests := httpStandIn(t, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	channel := saveTestChannel(t, s, models.NotificationChannel{Name: "hook", Type: ChannelWebhook, URL: server.URL})
+
+	delivery := notifyFailedCommand(t, s, channel.ID)
+	if delivery.Status != "sent" || delivery.Attempts != 3 {
+		t.Fatalf("delivery = %s after %d attempts (%s), want sent after 3", delivery.Status, delivery.Attempts, delivery.Error)
+	}
+
+	got := requests()
+	if len(got) != 3 {
+		t.Fatalf("got %d requests, want 3", len(got))
+	}
+	if gap := got[1].at.Sub(got[0].at); gap < 20*time.Millisecond {
+		t.Errorf("first retry after %v, want at least 20ms", gap)
+	}
+	if gap := got[2].at.Sub(got[1].at); gap < 40*time.Millisecond {
+		t.Errorf("second retry after %v, want at least 40ms (backoff 2)", gap)
+	}
+}
+
+func TestNotifyGivesUpAfterMaxAttempts(t *testing.T) {
+	s := newTestNotificationService(t)
+	server, requests := httpStandIn(t, 500, 500, 500, 500)
+	channel := saveTestChannel(t, s, models.NotificationChannel{
+		Name:  "hook",
+		Type:  ChannelWebhook,
+		URL:   server.URL,
+		Retry: &models.CommandRetry{MaxAttempts: 2, DelayMs: 1},
+	})
+
+	delivery := notifyFailedCommand(t, s, channel.ID)
+	if delivery.Status != "failed" || delivery.Attempts != 2 {
+		t.Fatalf("delivery = %s after %d attempts, want failed after 2", delivery.Status, delivery.Attempts)
+	}
+	if !strings.Contains(delivery.Error, "HTTP 500") {
+		t.Errorf("error = %q, want the HTTP status", delivery.Error)
+	}
+	if n := len(requests()); n != 2 {
+		t.Errorf("got %d requests, want 2", n)
+	}
+}
+
+// smtpStandIn is a minimal SMTP server for one connection. With tlsConfig it
+// offers STARTTLS; with a username it offers PLAIN auth, after STARTTLS when
+// that is offered.
+type smtpStandIn struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	username  string
+	password  string
+	done      chan struct{}
+
+	// What the client did
+	upgraded   bool
+	authUser   string
+	authPass   string
+	from       string
+	to         []string
+	data       string
+	transcript []string
+}
+
+func newSMTPStandIn(t *testing.T, tlsConfig *tls.Config, username, password string) *smtpStandIn {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &smtpStandIn{listener: listener, tlsConfig: tlsConfig, username: username, password: password, done: make(chan struct{})}
+	go server.serve()
+	return server
+}
+
+func (s *smtpStandIn) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpStandIn) serve() {
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer func() { conn.Close() }()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	reader := bufio.NewReader(conn)
+	reply := func(lines ...string) {
+		for _, line := range lines {
+			fmt.Fprintf(conn, "%s\r\n", line)
+		}
+	}
+
+	reply("220 standin ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		s.transcript = append(s.transcript, line)
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			reply("500 empty command")
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "EHLO", "HELO":
+			lines := []string{"250-standin"}
+			if s.tlsConfig != nil && !s.upgraded {
+				lines = append(lines, "250-STARTTLS")
+			}
+			if s.username != "" && (s.tlsConfig == nil || s.upgraded) {
+				lines = append(lines, "250-AUTH PLAIN")
+			}
+			reply(append(lines, "250 8BITMIME")...)
+		case "STARTTLS":
+			reply("220 ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, reader, s.upgraded = tlsConn, bufio.NewReader(tlsConn), true
+		case "AUTH":
+			if len(fields) < 3 || strings.ToUpper(fields[1]) != "PLAIN" {
+				reply("504 only PLAIN with an initial response")
+				continue
+			}
+			decoded, _ := base64.StdEncoding.DecodeString(fields[2])
+			parts := strings.Split(string(decoded), "\x00")
+			if len(parts) == 3 {
+				s.authUser, s.authPass = parts[1], parts[2]
+			}
+			if s.authUser == s.username && s.authPass == s.password {
+				reply("235 authenticated")
+			} else {
+				reply("535 bad credentials")
+			}
+		case "MAIL":
+			s.from = smtpAddress(line)
+			reply("250 ok")
+		case "RCPT":
+			s.to = append(s.to, smtpAddress(line))
+			reply("250 ok")
+		case "DATA":
+			reply("354 end with <CRLF>.<CRLF>")
+			var data strings.Builder
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(dataLine)
+			}
+			s.data = data.String()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// smtpAddress returns the <address> of a MAIL FROM or RCPT TO command
+func smtpAddress(line string) string {
+	start := strings.Index(line, "<")
+	end := strings.Index(line, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}
+
+// selfSignedTLS returns a server config for 127.0.0.1 and a client config
+// that trusts it
+func selfSignedTLS(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "smtp stand-in"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	server = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client = &tls.Config{RootCAs: roots}
+	return server, client
+}
+
+func TestEmailChannelStartTLSAndAuth(t *testing.T) {
+	serverTLS, clientTLS := selfSignedTLS(t)
+	standIn := newSMTPStandIn(t, serverTLS, "alerts", "hunter2")
+
+	s := newTestNotificationService(t)
+	s.smtpTLS = clientTLS
+	channel := saveTestChannel(t, s, models.NotificationChannel{
+		Name:         "mail",
+		Type:         ChannelEmail,
+		SMTPHost:     "127.0.0.1",
+		SMTPPort:     standIn.port(),
+		SMTPUsername: "alerts",
+		Secret:       "hunter2",
+		From:         "devops@example.com",
+		To:           []string{"oncall@example.com", "lead@example.com"},
+	})
+
+	delivery, err := s.TestChannel(t.Context(), channel.ID)
+	if err != nil {
+		t.Fatalf("TestChannel: %v", err)
+	}
+	<-standIn.done
+	if delivery.Status != "sent" {
+		t.Fatalf("delivery = %s (%s), want sent; transcript: %q", delivery.Status, delivery.Error, standIn.transcript)
+	}
+
+	if !standIn.upgraded {
+		t.Error("client did not use STARTTLS")
+	}
+	if standIn.authUser != "alerts" || standIn.authPass != "hunter2" {
+		t.Errorf("auth = %q/%q, want alerts/hunter2", standIn.authUser, standIn.authPass)
+	}
+	if standIn.from != "devops@example.com" {
+		t.Errorf("MAIL FROM = %q", standIn.from)
+	}
+	if strings.Join(standIn.to, ",") != "oncall@example.com,lead@example.com" {
+		t.Errorf("RCPT TO = %q", standIn.to)
+	}
+	if !strings.Contains(standIn.data, "Subject: Test notification\r\n") || !strings.Contains(standIn.data, "This is a test message") {
+		t.Errorf("unexpected message:\n%s", standIn.data)
+	}
+}
+
+func TestEmailChannelWithoutTLSOrAuth(t *testing.T) {
+	standIn := newSMTPStandIn(t, nil, "", "")
+
+	s := newTestNotificationService(t)
+	channel := saveTestChannel(t, s, models.NotificationChannel{
+		Name:     "mail",
+		Type:     ChannelEmail,
+		SMTPHost: "127.0.0.1",
+		SMTPPort: standIn.port(),
+		From:     "devops@example.com",
+		To:       []string{"oncall@example.com"},
+	})
+
+	delivery, err := s.TestChannel(t.Context(), channel.ID)
+	if err != nil {
+		t.Fatalf("TestChannel: %v", err)
+	}
+	<-standIn.done
+	if delivery.Status != "sent" {
+		t.Fatalf("delivery = %s (%s), want sent; transcript: %q", delivery.Status, delivery.Error, standIn.transcript)
+	}
+	if standIn.upgraded || standIn.authUser != "" {
+		t.Errorf("upgraded = %v, auth user = %q; want neither without STARTTLS or a username", standIn.upgraded, standIn.authUser)
+	}
+}
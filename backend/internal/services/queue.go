@@ -2,36 +2,123 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/devopstools/backend/internal/logger"
 	"github.com/devopstools/backend/internal/models"
 	"github.com/google/uuid"
 )
 
+// defaultQueueTimeout bounds queued commands that do not declare a timeout
+const defaultQueueTimeout = 5 * time.Minute
+
+// batchRetention is how long a finished batch can still be looked up
+const batchRetention = time.Hour
+
+// Queue priorities. Higher priorities are always dispatched first; within a
+// priority, users share the workers in proportion to their weight.
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+var queuePriorities = []string{PriorityHigh, PriorityNormal, PriorityLow}
+
+// ErrQueueClosed is returned when submitting to a queue that is shutting down
+var ErrQueueClosed = errors.New("command queue is closed")
+
+// QueueLimitError is returned when a submission would exceed a queue quota
+type QueueLimitError struct {
+	UserID string
+	Limit  string // max_queued_total, max_queued_per_user
+	Max    int
+}
+
+func (e *QueueLimitError) Error() string {
+	if e.Limit == "max_queued_total" {
+		return fmt.Sprintf("command queue is at capacity (%d queued commands); try again when running commands finish", e.Max)
+	}
+	return fmt.Sprintf("user %s already has %d commands queued (%s); wait for some to finish before submitting more", e.UserID, e.Max, e.Limit)
+}
+
+// queuedCommand is a pending unit of work in the scheduler
+type queuedCommand struct {
+	execution *models.CommandExecution
+	priority  string
+	ctx       context.Context
+	done      chan struct{}
+	announced chan struct{} // closed once the queued event is sent; nil when there is none
+}
+
+// queuedBatch tracks how many commands of a batch have not finished yet
+type queuedBatch struct {
+	*models.CommandBatch
+	remaining  int
+	finishedAt time.Time
+}
+
+// queueControl holds the control state of a running queue, guarded by CommandQueue.mu
+type queueControl struct {
+	ctx         context.Context
+	cancel      context.CancelFunc
+	resume      chan struct{} // closed to wake a paused queue
+	currentItem string
+	cancelItem  context.CancelFunc
+}
+
 // CommandQueue manages queued command execution
 type CommandQueue struct {
-	queue          chan *models.CommandExecution
 	maxConcurrent  int
 	activeCommands sync.WaitGroup
+	workers        sync.WaitGroup
 	cmdService     *CommandService
 	onProgress     func(progress models.CommandProgress)
+	locks          *LockManager
 	mu             sync.RWMutex
 	queues         map[string]*models.CommandQueue
+	controls       map[string]*queueControl // running or paused queues
+	batches        map[string]*queuedBatch
+
+	// Scheduler state, guarded by schedMu
+	schedMu     sync.Mutex
+	cond        *sync.Cond
+	limits      models.QueueLimits
+	pending     map[string]map[string][]*queuedCommand // priority -> user -> FIFO
+	queued      map[string]int                         // queued commands per user
+	running     map[string]int                         // running commands per user
+	served      map[string]float64                     // weighted dispatch count per user with queued or running commands
+	total       int
+	avgDuration time.Duration // moving average of finished command durations
+	closed      bool
 }
 
 // NewCommandQueue creates a new command queue
 func NewCommandQueue(cmdService *CommandService, maxConcurrent int) *CommandQueue {
 	cq := &CommandQueue{
-		queue:         make(chan *models.CommandExecution, 100),
 		maxConcurrent: maxConcurrent,
 		cmdService:    cmdService,
 		queues:        make(map[string]*models.CommandQueue),
+		controls:      make(map[string]*queueControl),
+		batches:       make(map[string]*queuedBatch),
+		limits:        models.QueueLimits{MaxQueuedTotal: 100},
+		pending:       make(map[string]map[string][]*queuedCommand),
+		queued:        make(map[string]int),
+		running:       make(map[string]int),
+		served:        make(map[string]float64),
+	}
+	cq.cond = sync.NewCond(&cq.schedMu)
+	for _, priority := range queuePriorities {
+		cq.pending[priority] = make(map[string][]*queuedCommand)
 	}
 
 	// Start workers
 	for i := 0; i < maxConcurrent; i++ {
+		cq.workers.Add(1)
 		go cq.worker()
 	}
 
@@ -43,98 +130,538 @@ func (cq *CommandQueue) SetProgressCallback(callback func(progress models.Comman
 	cq.onProgress = callback
 }
 
+// SetLockManager enables the locks commands can declare. Commands that
+// would wait for a held lock stay queued without taking a worker.
+func (cq *CommandQueue) SetLockManager(locks *LockManager) {
+	cq.locks = locks
+	locks.SetReleaseCallback(cq.cond.Broadcast)
+}
+
+// SetLimits replaces the fairness quotas applied to new and pending commands
+func (cq *CommandQueue) SetLimits(limits models.QueueLimits) error {
+	if limits.MaxQueuedTotal < 0 || limits.MaxQueuedPerUser < 0 || limits.MaxConcurrentPerUser < 0 {
+		return fmt.Errorf("queue limits must not be negative")
+	}
+	for userID, override := range limits.Users {
+		if override.MaxQueued < 0 || override.MaxConcurrent < 0 || override.Weight < 0 {
+			return fmt.Errorf("queue limits for user %s must not be negative", userID)
+		}
+	}
+
+	cq.schedMu.Lock()
+	cq.limits = limits
+	cq.schedMu.Unlock()
+
+	// Raised concurrency limits may unblock pending commands
+	cq.cond.Broadcast()
+	return nil
+}
+
+// GetLimits returns the current fairness quotas
+func (cq *CommandQueue) GetLimits() models.QueueLimits {
+	cq.schedMu.Lock()
+	defer cq.schedMu.Unlock()
+	return cq.limits
+}
+
 // Enqueue adds a command to the queue
-func (cq *CommandQueue) Enqueue(ctx context.Context, userID, command string, args []string, workDir string, timeout time.Duration) (*models.CommandExecution, error) {
-	execution := &models.CommandExecution{
-		ID:        uuid.New().String(),
-		UserID:    userID,
-		Command:   command,
-		Args:      args,
-		WorkDir:   workDir,
-		Status:    "queued",
-		StartedAt: time.Now(),
+func (cq *CommandQueue) Enqueue(ctx context.Context, userID string, cmd models.BatchCommand) (*models.CommandExecution, error) {
+	batch, err := cq.EnqueueBatch(ctx, userID, []models.BatchCommand{cmd}, "")
+	if err != nil {
+		return nil, err
 	}
+	return cq.cmdService.GetExecution(batch.Executions[0])
+}
 
-	// Send progress update
-	if cq.onProgress != nil {
-		cq.onProgress(models.CommandProgress{
-			ExecutionID: execution.ID,
-			Step:        "queued",
-			Percentage:  0,
-			Message:     "Command queued for execution",
-			Timestamp:   time.Now(),
-		})
+// EnqueueBatch validates and queues several commands at once. Commands
+// without their own priority use defaultPriority. If any command is invalid
+// or the batch does not fit in the user's quota, nothing is queued.
+func (cq *CommandQueue) EnqueueBatch(ctx context.Context, userID string, commands []models.BatchCommand, defaultPriority string) (*models.CommandBatch, error) {
+	if len(commands) == 0 {
+		return nil, fmt.Errorf("no commands to queue")
+	}
+	if defaultPriority == "" {
+		defaultPriority = PriorityNormal
+	}
+
+	batch := &models.CommandBatch{
+		ID:         uuid.New().String(),
+		UserID:     userID,
+		Executions: make([]string, 0, len(commands)),
+		CreatedAt:  time.Now(),
+	}
+
+	items := make([]*queuedCommand, 0, len(commands))
+	discard := func() {
+		for _, item := range items {
+			cq.cmdService.Discard(item.execution.ID)
+		}
+	}
+
+	for i, cmd := range commands {
+		priority := cmd.Priority
+		if priority == "" {
+			priority = defaultPriority
+		}
+		if !isValidPriority(priority) {
+			discard()
+			return nil, fmt.Errorf("command %d: invalid priority: %s (expected high, normal or low)", i+1, priority)
+		}
+
+		execution, err := cq.cmdService.Prepare(userID, cmd.Command, cmd.Args, cmd.WorkDir, cmd.Retry, cmd.Timeout)
+		if err == nil {
+			err = ValidateLockPolicy(cmd.LockPolicy)
+		}
+		if err == nil && len(cmd.Locks) > 0 && cq.locks == nil {
+			err = fmt.Errorf("locks are not enabled")
+		}
+		if err != nil {
+			if execution != nil {
+				cq.cmdService.Discard(execution.ID)
+			}
+			discard()
+			if len(commands) == 1 {
+				return nil, err
+			}
+			return nil, fmt.Errorf("command %d: %w", i+1, err)
+		}
+		execution.Status = "queued"
+		execution.Locks = NormalizeLockNames(cmd.Locks)
+		execution.LockPolicy = cmd.LockPolicy
+		execution.Priority = priority
+		if len(commands) > 1 {
+			execution.BatchID = batch.ID
+		}
+
+		items = append(items, &queuedCommand{execution: execution, priority: priority, ctx: context.Background(), announced: make(chan struct{})})
+		batch.Executions = append(batch.Executions, execution.ID)
+	}
+
+	// Register the batch before its commands can finish
+	if len(commands) > 1 {
+		cq.mu.Lock()
+		cq.pruneBatches(time.Now())
+		cq.batches[batch.ID] = &queuedBatch{CommandBatch: batch, remaining: len(items)}
+		cq.mu.Unlock()
 	}
 
 	// Add to queue
-	select {
-	case cq.queue <- execution:
-		return execution, nil
-	default:
-		return nil, fmt.Errorf("queue is full")
+	if err := cq.submit(items...); err != nil {
+		discard()
+		cq.mu.Lock()
+		delete(cq.batches, batch.ID)
+		cq.mu.Unlock()
+		return nil, err
+	}
+
+	// Send progress update. Workers wait for it, so no command is reported
+	// as starting before it was reported as queued.
+	for _, item := range items {
+		cq.emitProgress(item.execution, "queued", 0, fmt.Sprintf("Command queued for execution (%s priority)", item.priority))
+		close(item.announced)
+	}
+
+	return batch, nil
+}
+
+// GetBatch retrieves a batch by ID
+func (cq *CommandQueue) GetBatch(id string) (*models.CommandBatch, error) {
+	cq.mu.RLock()
+	defer cq.mu.RUnlock()
+
+	batch, ok := cq.batches[id]
+	if !ok {
+		return nil, fmt.Errorf("batch not found: %s", id)
+	}
+	return batch.CommandBatch, nil
+}
+
+// batchCommandDone counts a finished command against its batch
+func (cq *CommandQueue) batchCommandDone(batchID string) {
+	if batchID == "" {
+		return
+	}
+
+	cq.mu.Lock()
+	defer cq.mu.Unlock()
+
+	batch, ok := cq.batches[batchID]
+	if !ok {
+		return
+	}
+	batch.remaining--
+	now := time.Now()
+	if batch.remaining == 0 {
+		batch.finishedAt = now
+	}
+	cq.pruneBatches(now)
+}
+
+// pruneBatches drops batches that finished more than batchRetention ago.
+// Callers hold cq.mu.
+func (cq *CommandQueue) pruneBatches(now time.Time) {
+	for id, batch := range cq.batches {
+		if batch.remaining == 0 && now.Sub(batch.finishedAt) > batchRetention {
+			delete(cq.batches, id)
+		}
 	}
 }
 
+// Position estimates where a queued command stands and when it will start.
+// It returns nil once the command has left the queue.
+func (cq *CommandQueue) Position(executionID string) *models.QueuePosition {
+	cq.schedMu.Lock()
+	defer cq.schedMu.Unlock()
+
+	for i, item := range cq.dispatchOrder() {
+		if item.execution.ID != executionID {
+			continue
+		}
+
+		position := &models.QueuePosition{ExecutionID: executionID, Position: i + 1}
+
+		// Commands start as workers free up; the wait is estimated from
+		// the average duration of recently finished commands.
+		free := cq.maxConcurrent
+		for _, n := range cq.running {
+			free -= n
+		}
+		if free < 0 {
+			free = 0
+		}
+		start := time.Now()
+		if position.Position > free && cq.maxConcurrent > 0 {
+			if cq.avgDuration == 0 {
+				return position
+			}
+			rounds := (position.Position - free + cq.maxConcurrent - 1) / cq.maxConcurrent
+			start = start.Add(time.Duration(rounds) * cq.avgDuration)
+		}
+		position.EstimatedStartAt = &start
+		return position
+	}
+
+	return nil
+}
+
+// dispatchOrder simulates the scheduler to list pending commands in the
+// order they are expected to start. Per-user concurrency limits are ignored
+// as they depend on when running commands finish. Callers hold schedMu.
+func (cq *CommandQueue) dispatchOrder() []*queuedCommand {
+	served := make(map[string]float64, len(cq.served))
+	for userID, v := range cq.served {
+		served[userID] = v
+	}
+
+	order := make([]*queuedCommand, 0, cq.total)
+	for _, priority := range queuePriorities {
+		next := make(map[string]int)
+		for {
+			var chosen string
+			for userID, items := range cq.pending[priority] {
+				if next[userID] >= len(items) {
+					continue
+				}
+				if chosen == "" || served[userID] < served[chosen] ||
+					(served[userID] == served[chosen] && items[next[userID]].execution.StartedAt.Before(cq.pending[priority][chosen][next[chosen]].execution.StartedAt)) {
+					chosen = userID
+				}
+			}
+			if chosen == "" {
+				break
+			}
+			order = append(order, cq.pending[priority][chosen][next[chosen]])
+			next[chosen]++
+			served[chosen] += 1 / float64(cq.userWeight(chosen))
+		}
+	}
+	return order
+}
+
+// emitProgress sends a progress update for a queued command
+func (cq *CommandQueue) emitProgress(execution *models.CommandExecution, step string, percentage float64, message string) {
+	if cq.onProgress == nil {
+		return
+	}
+	cq.onProgress(models.CommandProgress{
+		ExecutionID: execution.ID,
+		BatchID:     execution.BatchID,
+		Step:        step,
+		Percentage:  percentage,
+		Message:     message,
+		Timestamp:   time.Now(),
+	})
+}
+
+// submit places commands of a single user in the scheduler, enforcing queue
+// quotas. Either all of the commands are accepted or none is.
+func (cq *CommandQueue) submit(items ...*queuedCommand) error {
+	if len(items) == 0 {
+		return nil
+	}
+	userID := items[0].execution.UserID
+
+	cq.schedMu.Lock()
+	defer cq.schedMu.Unlock()
+
+	if cq.closed {
+		return ErrQueueClosed
+	}
+	if cq.limits.MaxQueuedTotal > 0 && cq.total+len(items) > cq.limits.MaxQueuedTotal {
+		return &QueueLimitError{UserID: userID, Limit: "max_queued_total", Max: cq.limits.MaxQueuedTotal}
+	}
+	if maxQueued := cq.userMaxQueued(userID); maxQueued > 0 && cq.queued[userID]+len(items) > maxQueued {
+		return &QueueLimitError{UserID: userID, Limit: "max_queued_per_user", Max: maxQueued}
+	}
+
+	// Idle users are forgotten; one that comes back starts level with the
+	// least served active user, so past inactivity does not turn into a burst
+	// of priority later.
+	if cq.queued[userID] == 0 && cq.running[userID] == 0 {
+		if floor, ok := cq.minActiveServed(); ok {
+			cq.served[userID] = floor
+		}
+	}
+
+	for _, item := range items {
+		if item.done == nil {
+			item.done = make(chan struct{})
+		}
+		cq.pending[item.priority][userID] = append(cq.pending[item.priority][userID], item)
+		cq.queued[userID]++
+		cq.total++
+	}
+	cq.cond.Broadcast()
+	return nil
+}
+
+// next blocks until a command may be dispatched, or returns nil once the queue is closed and drained
+func (cq *CommandQueue) next() *queuedCommand {
+	cq.schedMu.Lock()
+	defer cq.schedMu.Unlock()
+
+	for {
+		if item := cq.pick(); item != nil {
+			cq.activeCommands.Add(1)
+			return item
+		}
+		if cq.closed && cq.total == 0 {
+			return nil
+		}
+		cq.cond.Wait()
+	}
+}
+
+// pick selects the next command using strict priority across levels and
+// weighted fair sharing across users within a level. Commands waiting for a
+// held lock are passed over, leaving them first in line once it is free.
+// Callers hold schedMu.
+func (cq *CommandQueue) pick() *queuedCommand {
+	for _, priority := range queuePriorities {
+		var chosen string
+		var chosenIndex int
+		for userID, items := range cq.pending[priority] {
+			if len(items) == 0 {
+				continue
+			}
+			if maxRunning := cq.userMaxConcurrent(userID); maxRunning > 0 && cq.running[userID] >= maxRunning {
+				continue
+			}
+			index := 0
+			for index < len(items) && cq.waitsForLock(items[index]) {
+				index++
+			}
+			if index == len(items) {
+				continue
+			}
+			if chosen == "" || cq.served[userID] < cq.served[chosen] ||
+				(cq.served[userID] == cq.served[chosen] && items[index].execution.StartedAt.Before(cq.pending[priority][chosen][chosenIndex].execution.StartedAt)) {
+				chosen = userID
+				chosenIndex = index
+			}
+		}
+		if chosen == "" {
+			continue
+		}
+
+		items := cq.pending[priority][chosen]
+		item := items[chosenIndex]
+		if len(items) == 1 {
+			delete(cq.pending[priority], chosen)
+		} else {
+			cq.pending[priority][chosen] = append(items[:chosenIndex:chosenIndex], items[chosenIndex+1:]...)
+		}
+
+		cq.queued[chosen]--
+		cq.running[chosen]++
+		cq.total--
+		cq.served[chosen] += 1 / float64(cq.userWeight(chosen))
+		return item
+	}
+	return nil
+}
+
+// waitsForLock reports whether a command would wait for a held lock.
+// Commands with the fail policy are dispatched to fail at once.
+func (cq *CommandQueue) waitsForLock(item *queuedCommand) bool {
+	execution := item.execution
+	return cq.locks != nil && len(execution.Locks) > 0 && execution.LockPolicy != LockPolicyFail && !cq.locks.Available(execution.Locks)
+}
+
+// release records that a dispatched command finished
+func (cq *CommandQueue) release(item *queuedCommand) {
+	userID := item.execution.UserID
+
+	cq.schedMu.Lock()
+	// Keep a moving average of run time to estimate start times
+	duration := time.Duration(item.execution.Duration) * time.Millisecond
+	if cq.avgDuration == 0 {
+		cq.avgDuration = duration
+	} else {
+		cq.avgDuration = (cq.avgDuration*4 + duration) / 5
+	}
+	cq.running[userID]--
+	if cq.running[userID] == 0 && cq.queued[userID] == 0 {
+		delete(cq.running, userID)
+		delete(cq.queued, userID)
+		delete(cq.served, userID)
+	}
+	cq.schedMu.Unlock()
+
+	// A finished command may unblock a user held back by its concurrency limit
+	cq.cond.Broadcast()
+	cq.activeCommands.Done()
+}
+
+// minActiveServed returns the lowest weighted dispatch count among users with work in the queue
+func (cq *CommandQueue) minActiveServed() (float64, bool) {
+	found := false
+	var floor float64
+	for userID := range cq.queued {
+		if cq.queued[userID] == 0 && cq.running[userID] == 0 {
+			continue
+		}
+		if !found || cq.served[userID] < floor {
+			floor = cq.served[userID]
+			found = true
+		}
+	}
+	return floor, found
+}
+
+func (cq *CommandQueue) userMaxQueued(userID string) int {
+	if override, ok := cq.limits.Users[userID]; ok && override.MaxQueued > 0 {
+		return override.MaxQueued
+	}
+	return cq.limits.MaxQueuedPerUser
+}
+
+func (cq *CommandQueue) userMaxConcurrent(userID string) int {
+	if override, ok := cq.limits.Users[userID]; ok && override.MaxConcurrent > 0 {
+		return override.MaxConcurrent
+	}
+	return cq.limits.MaxConcurrentPerUser
+}
+
+func (cq *CommandQueue) userWeight(userID string) int {
+	if override, ok := cq.limits.Users[userID]; ok && override.Weight > 0 {
+		return override.Weight
+	}
+	return 1
+}
+
+func isValidPriority(priority string) bool {
+	for _, p := range queuePriorities {
+		if p == priority {
+			return true
+		}
+	}
+	return false
+}
+
 // worker processes commands from the queue
 func (cq *CommandQueue) worker() {
-	for execution := range cq.queue {
-		cq.activeCommands.Add(1)
-		cq.processCommand(execution)
-		cq.activeCommands.Done()
+	defer cq.workers.Done()
+
+	for {
+		item := cq.next()
+		if item == nil {
+			return
+		}
+		if item.announced != nil {
+			<-item.announced
+		}
+		cq.processCommand(item.ctx, item.execution)
+		close(item.done)
+		cq.release(item)
+		cq.batchCommandDone(item.execution.BatchID)
 	}
 }
 
 // processCommand executes a single command
-func (cq *CommandQueue) processCommand(execution *models.CommandExecution) {
-	// Update status
-	execution.Status = "running"
-
-	// Send progress update
-	if cq.onProgress != nil {
-		cq.onProgress(models.CommandProgress{
-			ExecutionID: execution.ID,
-			Step:        "starting",
-			Percentage:  10,
-			Message:     "Starting command execution",
-			Timestamp:   time.Now(),
-		})
+func (cq *CommandQueue) processCommand(ctx context.Context, execution *models.CommandExecution) {
+	// Commands cancelled while waiting in the queue never start
+	if ctx.Err() != nil {
+		cq.cmdService.Cancel(execution, "cancelled before it started")
+		cq.emitProgress(execution, "completed", 0, "Command cancelled")
+		return
 	}
 
-	// Execute command with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-	defer cancel()
+	if len(execution.Locks) > 0 && cq.locks != nil {
+		if !cq.locks.Available(execution.Locks) && execution.LockPolicy != LockPolicyFail {
+			cq.emitProgress(execution, "waiting_lock", 5, fmt.Sprintf("Waiting for locks: %s", strings.Join(execution.Locks, ", ")))
+		}
+		holder := models.LockHolder{
+			Type:   "command",
+			ID:     execution.ID,
+			Label:  strings.TrimSpace(execution.Command + " " + strings.Join(execution.Args, " ")),
+			UserID: execution.UserID,
+		}
+		release, err := cq.locks.Acquire(ctx, execution.Locks, holder, execution.LockPolicy, nil)
+		if err != nil {
+			if ctx.Err() != nil {
+				cq.cmdService.Cancel(execution, "cancelled while waiting for locks")
+				cq.emitProgress(execution, "completed", 0, "Command cancelled")
+			} else {
+				cq.cmdService.Fail(execution, err.Error())
+				cq.emitProgress(execution, "completed", 0, fmt.Sprintf("Command failed: %v", err))
+			}
+			return
+		}
+		defer release()
+	}
 
-	// Use the command service to execute
-	result, err := cq.cmdService.Execute(ctx, execution.UserID, execution.Command, execution.Args, execution.WorkDir)
+	// Send progress update
+	cq.emitProgress(execution, "starting", 10, "Starting command execution")
 
-	if err != nil {
-		execution.Status = "failed"
-		execution.Error = err.Error()
-	} else {
-		execution.Status = result.Status
-		execution.Output = result.Output
-		execution.ExitCode = result.ExitCode
+	// Queued commands without an explicit timeout keep the default limit
+	if execution.Timeout == nil {
+		execution.Timeout = &models.CommandTimeout{DurationMs: defaultQueueTimeout.Milliseconds(), Action: TimeoutActionKill}
 	}
 
+	// Use the command service to execute
+	cq.cmdService.Run(ctx, execution)
+
 	// Send completion progress
-	if cq.onProgress != nil {
-		percentage := 100.0
-		if execution.Status == "failed" {
-			percentage = 0
-		}
-
-		cq.onProgress(models.CommandProgress{
-			ExecutionID: execution.ID,
-			Step:        "completed",
-			Percentage:  percentage,
-			Message:     fmt.Sprintf("Command %s", execution.Status),
-			Timestamp:   time.Now(),
-		})
+	percentage := 100.0
+	if execution.Status != "success" {
+		percentage = 0
 	}
+	cq.emitProgress(execution, "completed", percentage, fmt.Sprintf("Command %s", execution.Status))
 }
 
 // CreateQueue creates a new command queue
-func (cq *CommandQueue) CreateQueue(userID, name string, commands []string) (*models.CommandQueue, error) {
+func (cq *CommandQueue) CreateQueue(userID, name string, commands []string, priority string, retry *models.CommandRetry, timeout *models.CommandTimeout) (*models.CommandQueue, error) {
+	if priority == "" {
+		priority = PriorityNormal
+	}
+	if !isValidPriority(priority) {
+		return nil, fmt.Errorf("invalid priority: %s (expected high, normal or low)", priority)
+	}
+	if err := ValidateExecutionPolicy(retry, timeout); err != nil {
+		return nil, err
+	}
+
 	cq.mu.Lock()
 	defer cq.mu.Unlock()
 
@@ -143,16 +670,27 @@ func (cq *CommandQueue) CreateQueue(userID, name string, commands []string) (*mo
 		UserID:      userID,
 		Name:        name,
 		Commands:    commands,
+		Items:       make([]models.QueueItem, 0, len(commands)),
+		Priority:    priority,
+		Retry:       retry,
+		Timeout:     timeout,
 		Status:      "pending",
 		CurrentStep: 0,
 		CreatedAt:   time.Now(),
 	}
+	for _, cmdID := range commands {
+		queue.Items = append(queue.Items, models.QueueItem{
+			ID:        uuid.New().String(),
+			CommandID: cmdID,
+			Status:    "pending",
+		})
+	}
 
 	cq.queues[queue.ID] = queue
-	return queue, nil
+	return copyQueue(queue), nil
 }
 
-// GetQueue retrieves a queue by ID
+// GetQueue retrieves a snapshot of a queue by ID
 func (cq *CommandQueue) GetQueue(id string) (*models.CommandQueue, error) {
 	cq.mu.RLock()
 	defer cq.mu.RUnlock()
@@ -161,63 +699,418 @@ func (cq *CommandQueue) GetQueue(id string) (*models.CommandQueue, error) {
 	if !ok {
 		return nil, fmt.Errorf("queue not found: %s", id)
 	}
-	return queue, nil
+	return copyQueue(queue), nil
+}
+
+// StartQueue begins executing a queue in the background
+func (cq *CommandQueue) StartQueue(queueID string) error {
+	ctrl, err := cq.beginQueue(context.Background(), queueID)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := cq.runQueue(queueID, ctrl); err != nil {
+			logger.Error("Failed to execute queue", err, logger.WithFields(map[string]interface{}{
+				"queue_id": queueID,
+			}).Data)
+		}
+	}()
+	return nil
 }
 
-// ExecuteQueue executes all commands in a queue sequentially
+// ExecuteQueue executes all commands in a queue sequentially and waits for it to finish
 func (cq *CommandQueue) ExecuteQueue(ctx context.Context, queueID string) error {
-	queue, err := cq.GetQueue(queueID)
+	ctrl, err := cq.beginQueue(ctx, queueID)
 	if err != nil {
 		return err
 	}
+	return cq.runQueue(queueID, ctrl)
+}
 
+// beginQueue marks a queue as running and registers its control state
+func (cq *CommandQueue) beginQueue(ctx context.Context, queueID string) (*queueControl, error) {
 	cq.mu.Lock()
+	defer cq.mu.Unlock()
+
+	queue, ok := cq.queues[queueID]
+	if !ok {
+		return nil, fmt.Errorf("queue not found: %s", queueID)
+	}
+	if _, active := cq.controls[queueID]; active {
+		return nil, fmt.Errorf("queue is already %s", queue.Status)
+	}
+
+	// Re-running a finished queue starts again from the first item
+	if queue.Status != "pending" {
+		for i := range queue.Items {
+			queue.Items[i].Status = "pending"
+			queue.Items[i].ExecutionID = ""
+		}
+		queue.CompletedAt = nil
+	}
+
+	queueCtx, cancel := context.WithCancel(ctx)
+	ctrl := &queueControl{ctx: queueCtx, cancel: cancel}
+	cq.controls[queueID] = ctrl
+
 	queue.Status = "running"
+	queue.PauseRequested = false
 	now := time.Now()
 	queue.StartedAt = &now
-	cq.mu.Unlock()
 
-	// Execute commands sequentially
-	for i, cmdID := range queue.Commands {
+	return ctrl, nil
+}
+
+// runQueue executes pending items in order until the queue finishes, fails or is cancelled
+func (cq *CommandQueue) runQueue(queueID string, ctrl *queueControl) error {
+	defer ctrl.cancel()
+
+	for {
 		cq.mu.Lock()
-		queue.CurrentStep = i
-		cq.mu.Unlock()
+		queue := cq.queues[queueID]
+
+		if ctrl.ctx.Err() != nil {
+			cq.finishQueue(queue, "cancelled")
+			cq.mu.Unlock()
+			return nil
+		}
+
+		if queue.PauseRequested {
+			queue.Status = "paused"
+			queue.PauseRequested = false
+			ctrl.resume = make(chan struct{})
+			resume := ctrl.resume
+			cq.mu.Unlock()
+
+			cq.emitQueueProgress(queueID, "", "paused", "Queue paused")
+			select {
+			case <-resume:
+			case <-ctrl.ctx.Done():
+			}
+			continue
+		}
+
+		index := nextPendingItem(queue)
+		if index < 0 {
+			cq.finishQueue(queue, "completed")
+			cq.mu.Unlock()
+			cq.emitQueueProgress(queueID, "", "completed", "Queue completed")
+			return nil
+		}
+
+		queue.CurrentStep = index
+		item := &queue.Items[index]
+		item.Status = "running"
+		itemID := item.ID
 
 		// Get command execution
-		execution, err := cq.cmdService.GetExecution(cmdID)
+		execution, err := cq.cmdService.GetExecution(item.CommandID)
 		if err != nil {
-			cq.mu.Lock()
-			queue.Status = "failed"
+			item.Status = "failed"
+			cq.finishQueue(queue, "failed")
 			cq.mu.Unlock()
 			return err
 		}
 
-		// Execute
-		_, err = cq.cmdService.Execute(ctx, queue.UserID, execution.Command, execution.Args, execution.WorkDir)
+		// The queue policy takes precedence over the one the command was created with
+		retry, timeout := execution.Retry, execution.Timeout
+		if queue.Retry != nil {
+			retry = queue.Retry
+		}
+		if queue.Timeout != nil {
+			timeout = queue.Timeout
+		}
+
+		run, err := cq.cmdService.Prepare(queue.UserID, execution.Command, execution.Args, execution.WorkDir, retry, timeout)
 		if err != nil {
+			item.Status = "failed"
+			cq.finishQueue(queue, "failed")
+			cq.mu.Unlock()
+			return err
+		}
+		item.ExecutionID = run.ID
+
+		// Queue steps share the workers with ad-hoc commands, under the same quotas
+		itemCtx, itemCancel := context.WithCancel(ctrl.ctx)
+		ctrl.currentItem = itemID
+		ctrl.cancelItem = itemCancel
+		run.Status = "queued"
+		run.Priority = queue.Priority
+		priority := queue.Priority
+		cq.mu.Unlock()
+
+		cq.emitQueueProgress(queueID, run.ID, "item_started", fmt.Sprintf("Running %s", execution.Command))
+
+		scheduled := &queuedCommand{execution: run, priority: priority, ctx: itemCtx}
+		if err := cq.submit(scheduled); err != nil {
+			itemCancel()
 			cq.mu.Lock()
-			queue.Status = "failed"
+			queue.Items[indexOfItem(queue, itemID)].Status = "failed"
+			cq.finishQueue(queue, "failed")
 			cq.mu.Unlock()
 			return err
 		}
+		<-scheduled.done
+		itemCancel()
+
+		cq.mu.Lock()
+		item = &queue.Items[indexOfItem(queue, itemID)]
+		ctrl.currentItem = ""
+		ctrl.cancelItem = nil
+
+		switch {
+		case item.Status == "skipped":
+			// Skipped while running; move on to the next item
+		case ctrl.ctx.Err() != nil:
+			item.Status = "cancelled"
+		case run.Status == "success":
+			item.Status = "completed"
+		default:
+			item.Status = "failed"
+			cq.finishQueue(queue, "failed")
+			cq.mu.Unlock()
+			cq.emitQueueProgress(queueID, run.ID, "failed", fmt.Sprintf("Command %s %s", run.Command, run.Status))
+			return fmt.Errorf("command %s %s after %d attempt(s): %s", run.Command, run.Status, len(run.Attempts), run.Error)
+		}
+		cq.mu.Unlock()
 	}
+}
 
-	cq.mu.Lock()
-	queue.Status = "completed"
-	now = time.Now()
+// finishQueue records the final queue state. Callers hold cq.mu.
+func (cq *CommandQueue) finishQueue(queue *models.CommandQueue, status string) {
+	queue.Status = status
+	queue.PauseRequested = false
+	now := time.Now()
 	queue.CompletedAt = &now
+
+	if status == "cancelled" {
+		for i := range queue.Items {
+			if queue.Items[i].Status == "pending" || queue.Items[i].Status == "running" {
+				queue.Items[i].Status = "cancelled"
+			}
+		}
+	}
+	delete(cq.controls, queue.ID)
+}
+
+// PauseQueue stops a running queue once the current item finishes
+func (cq *CommandQueue) PauseQueue(queueID string) error {
+	cq.mu.Lock()
+	queue, ok := cq.queues[queueID]
+	if !ok {
+		cq.mu.Unlock()
+		return fmt.Errorf("queue not found: %s", queueID)
+	}
+	if queue.Status != "running" {
+		cq.mu.Unlock()
+		return fmt.Errorf("cannot pause a queue that is %s", queue.Status)
+	}
+	queue.PauseRequested = true
 	cq.mu.Unlock()
 
+	cq.emitQueueProgress(queueID, "", "pausing", "Queue will pause after the current command")
 	return nil
 }
 
+// ResumeQueue continues a paused queue, or withdraws a pending pause request
+func (cq *CommandQueue) ResumeQueue(queueID string) error {
+	cq.mu.Lock()
+	queue, ok := cq.queues[queueID]
+	if !ok {
+		cq.mu.Unlock()
+		return fmt.Errorf("queue not found: %s", queueID)
+	}
+
+	switch {
+	case queue.Status == "paused":
+		ctrl := cq.controls[queueID]
+		queue.Status = "running"
+		close(ctrl.resume)
+		ctrl.resume = nil
+	case queue.Status == "running" && queue.PauseRequested:
+		queue.PauseRequested = false
+	default:
+		cq.mu.Unlock()
+		return fmt.Errorf("cannot resume a queue that is %s", queue.Status)
+	}
+	cq.mu.Unlock()
+
+	cq.emitQueueProgress(queueID, "", "resumed", "Queue resumed")
+	return nil
+}
+
+// CancelQueue stops a queue, killing the running command and cancelling pending items
+func (cq *CommandQueue) CancelQueue(queueID string) error {
+	cq.mu.Lock()
+	queue, ok := cq.queues[queueID]
+	if !ok {
+		cq.mu.Unlock()
+		return fmt.Errorf("queue not found: %s", queueID)
+	}
+
+	if ctrl, active := cq.controls[queueID]; active {
+		ctrl.cancel()
+	} else if queue.Status == "pending" {
+		cq.finishQueue(queue, "cancelled")
+	} else {
+		cq.mu.Unlock()
+		return fmt.Errorf("cannot cancel a queue that is %s", queue.Status)
+	}
+	cq.mu.Unlock()
+
+	cq.emitQueueProgress(queueID, "", "cancelled", "Queue cancelled")
+	return nil
+}
+
+// SkipItem marks a pending item as skipped, or stops the item that is currently running
+func (cq *CommandQueue) SkipItem(queueID, itemID string) error {
+	cq.mu.Lock()
+	queue, ok := cq.queues[queueID]
+	if !ok {
+		cq.mu.Unlock()
+		return fmt.Errorf("queue not found: %s", queueID)
+	}
+	index := indexOfItem(queue, itemID)
+	if index < 0 {
+		cq.mu.Unlock()
+		return fmt.Errorf("queue item not found: %s", itemID)
+	}
+
+	item := &queue.Items[index]
+	switch item.Status {
+	case "pending":
+		item.Status = "skipped"
+	case "running":
+		item.Status = "skipped"
+		if ctrl, active := cq.controls[queueID]; active && ctrl.currentItem == itemID && ctrl.cancelItem != nil {
+			ctrl.cancelItem()
+		}
+	default:
+		cq.mu.Unlock()
+		return fmt.Errorf("cannot skip an item that is %s", item.Status)
+	}
+	executionID := item.ExecutionID
+	cq.mu.Unlock()
+
+	cq.emitQueueProgress(queueID, executionID, "item_skipped", fmt.Sprintf("Item %s skipped", itemID))
+	return nil
+}
+
+// ReorderItems changes the order of the pending items. itemIDs must list
+// every pending item exactly once; items that already ran keep their place.
+func (cq *CommandQueue) ReorderItems(queueID string, itemIDs []string) error {
+	cq.mu.Lock()
+	queue, ok := cq.queues[queueID]
+	if !ok {
+		cq.mu.Unlock()
+		return fmt.Errorf("queue not found: %s", queueID)
+	}
+
+	var slots []int
+	pending := make(map[string]models.QueueItem)
+	for i, item := range queue.Items {
+		if item.Status == "pending" {
+			slots = append(slots, i)
+			pending[item.ID] = item
+		}
+	}
+	if len(itemIDs) != len(pending) {
+		cq.mu.Unlock()
+		return fmt.Errorf("reorder must list all %d pending items, got %d", len(pending), len(itemIDs))
+	}
+
+	reordered := make([]models.QueueItem, 0, len(itemIDs))
+	for _, id := range itemIDs {
+		item, ok := pending[id]
+		if !ok {
+			cq.mu.Unlock()
+			return fmt.Errorf("item %s is not pending in this queue (or listed twice)", id)
+		}
+		delete(pending, id)
+		reordered = append(reordered, item)
+	}
+
+	for i, slot := range slots {
+		queue.Items[slot] = reordered[i]
+	}
+	for i, item := range queue.Items {
+		queue.Commands[i] = item.CommandID
+	}
+	cq.mu.Unlock()
+
+	cq.emitQueueProgress(queueID, "", "reordered", "Pending items reordered")
+	return nil
+}
+
+// emitQueueProgress sends a queue state change to the progress callback
+func (cq *CommandQueue) emitQueueProgress(queueID, executionID, step, message string) {
+	if cq.onProgress == nil {
+		return
+	}
+
+	cq.mu.RLock()
+	queue := cq.queues[queueID]
+	done := 0
+	for _, item := range queue.Items {
+		if item.Status != "pending" && item.Status != "running" {
+			done++
+		}
+	}
+	percentage := 100.0
+	if len(queue.Items) > 0 {
+		percentage = float64(done) / float64(len(queue.Items)) * 100
+	}
+	cq.mu.RUnlock()
+
+	cq.onProgress(models.CommandProgress{
+		ExecutionID: executionID,
+		QueueID:     queueID,
+		Step:        step,
+		Percentage:  percentage,
+		Message:     message,
+		Timestamp:   time.Now(),
+	})
+}
+
+// nextPendingItem returns the index of the first pending item, or -1
+func nextPendingItem(queue *models.CommandQueue) int {
+	for i, item := range queue.Items {
+		if item.Status == "pending" {
+			return i
+		}
+	}
+	return -1
+}
+
+func indexOfItem(queue *models.CommandQueue, itemID string) int {
+	for i, item := range queue.Items {
+		if item.ID == itemID {
+			return i
+		}
+	}
+	return -1
+}
+
+// copyQueue returns a snapshot that is safe to use outside cq.mu
+func copyQueue(queue *models.CommandQueue) *models.CommandQueue {
+	snapshot := *queue
+	snapshot.Commands = append([]string(nil), queue.Commands...)
+	snapshot.Items = append([]models.QueueItem(nil), queue.Items...)
+	return &snapshot
+}
+
 // Wait waits for all active commands to complete
 func (cq *CommandQueue) Wait() {
 	cq.activeCommands.Wait()
 }
 
-// Close closes the queue
+// Close stops accepting commands and waits for the queued ones to finish
 func (cq *CommandQueue) Close() {
-	close(cq.queue)
-	cq.Wait()
+	cq.schedMu.Lock()
+	cq.closed = true
+	cq.schedMu.Unlock()
+
+	cq.cond.Broadcast()
+	cq.workers.Wait()
 }
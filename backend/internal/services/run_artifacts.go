@@ -0,0 +1,172 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/devopstools/backend/internal/models"
+)
+
+var ErrArtifactNotFound = errors.New("artifact not found")
+
+// runDir holds the files of a run next to its <id>.json: the workspace
+// while it runs and the artifacts its steps kept
+func (s *RunStore) runDir(id string) string {
+	return filepath.Join(s.dataDir, id)
+}
+
+// Workspace creates the working directory of a run
+func (s *RunStore) Workspace(id string) (string, error) {
+	dir, err := filepath.Abs(filepath.Join(s.runDir(id), "workspace"))
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create run workspace: %w", err)
+	}
+	return dir, nil
+}
+
+// RemoveWorkspace deletes the working directory of a finished run
+func (s *RunStore) RemoveWorkspace(id string) error {
+	return os.RemoveAll(filepath.Join(s.runDir(id), "workspace"))
+}
+
+// ArtifactFile returns the stored file of an artifact
+func (s *RunStore) ArtifactFile(runID, path string) (string, error) {
+	if runID == "" || runID != filepath.Base(runID) {
+		return "", ErrArtifactNotFound
+	}
+	dir := filepath.Join(s.runDir(runID), "artifacts")
+	file := filepath.Join(dir, filepath.FromSlash(path))
+	if !strings.HasPrefix(file, dir+string(filepath.Separator)) {
+		return "", ErrArtifactNotFound
+	}
+	if info, err := os.Stat(file); err != nil || info.IsDir() {
+		return "", ErrArtifactNotFound
+	}
+	return file, nil
+}
+
+// KeepArtifacts copies the workspace files matching patterns into the run's
+// artifacts. Patterns are relative to the workspace and may be globs; a
+// matching directory is kept with its contents. Files that would take the
+// run past the artifact size limit are skipped and reported. used is the
+// size of the artifacts the run already kept.
+func (s *RunStore) KeepArtifacts(runID, stepID, workspace string, patterns []string, used int64) ([]models.RunArtifact, []error) {
+	limit := s.GetRetention().ArtifactMaxRunBytes
+	var kept []models.RunArtifact
+	var problems []error
+
+	for _, pattern := range patterns {
+		if err := ValidateArtifactPattern(pattern); err != nil {
+			problems = append(problems, err)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(workspace, filepath.FromSlash(pattern)))
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", pattern, err))
+			continue
+		}
+		if len(matches) == 0 {
+			problems = append(problems, fmt.Errorf("%s: no matching files", pattern))
+			continue
+		}
+
+		var files []string
+		for _, match := range matches {
+			filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
+				if err == nil && info.Mode().IsRegular() {
+					files = append(files, path)
+				}
+				return nil
+			})
+		}
+		sort.Strings(files)
+
+		for _, file := range files {
+			name, err := filepath.Rel(workspace, file)
+			if err != nil || strings.HasPrefix(name, "..") {
+				continue
+			}
+			info, err := os.Stat(file)
+			if err != nil {
+				problems = append(problems, err)
+				continue
+			}
+			if limit > 0 && used+info.Size() > limit {
+				problems = append(problems, fmt.Errorf("%s: skipped, run artifacts would exceed %d bytes", filepath.ToSlash(name), limit))
+				continue
+			}
+
+			artifact, err := s.copyArtifact(runID, stepID, file, filepath.ToSlash(name))
+			if err != nil {
+				problems = append(problems, err)
+				continue
+			}
+			used += artifact.Size
+			kept = append(kept, *artifact)
+		}
+	}
+	return kept, problems
+}
+
+// copyArtifact stores one workspace file as an artifact of a step
+func (s *RunStore) copyArtifact(runID, stepID, file, name string) (*models.RunArtifact, error) {
+	path := stepID + "/" + name
+	dir := filepath.Join(s.runDir(runID), "artifacts")
+	target := filepath.Join(dir, filepath.FromSlash(path))
+	if !strings.HasPrefix(target, dir+string(filepath.Separator)) {
+		return nil, fmt.Errorf("%s: artifact path is outside the run", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return nil, err
+	}
+
+	in, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	out, err := os.Create(target)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, hash), in)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return &models.RunArtifact{
+		Path:      path,
+		Name:      name,
+		StepID:    stepID,
+		Size:      size,
+		SHA256:    hex.EncodeToString(hash.Sum(nil)),
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// ValidateArtifactPattern rejects artifact paths outside the workspace and
+// malformed globs
+func ValidateArtifactPattern(pattern string) error {
+	clean := filepath.ToSlash(filepath.Clean(filepath.FromSlash(pattern)))
+	if strings.TrimSpace(pattern) == "" || filepath.IsAbs(pattern) || strings.HasPrefix(pattern, "/") || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("artifact path %q must be relative to the run workspace", pattern)
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return fmt.Errorf("artifact path %q: %w", pattern, err)
+	}
+	return nil
+}
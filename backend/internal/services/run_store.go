@@ -0,0 +1,253 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/devopstools/backend/internal/models"
+)
+
+var ErrRunNotFound = errors.New("run not found")
+
+// RunStore persists workflow executions, one JSON file per run
+type RunStore struct {
+	dataDir   string
+	mu        sync.RWMutex
+	retention models.RunRetention
+}
+
+// NewRunStore opens the run store. Runs left active by a previous process
+// are marked as interrupted and their workspaces removed.
+func NewRunStore(dataDir string, retention models.RunRetention) (*RunStore, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	s := &RunStore{dataDir: dataDir}
+	if err := s.SetRetention(retention); err != nil {
+		return nil, err
+	}
+
+	runs, err := s.readAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, run := range runs {
+		if isActiveRunStatus(run.Status) {
+			run.Status = "interrupted"
+			run.Error = "server stopped while the run was active"
+			if err := s.Save(run); err != nil {
+				return nil, err
+			}
+			s.RemoveWorkspace(run.ID)
+		}
+	}
+
+	return s, nil
+}
+
+// isActiveRunStatus reports whether a run in this status has not finished,
+// including runs waiting for a lock or an approval
+func isActiveRunStatus(status string) bool {
+	switch status {
+	case "pending", "running", "waiting_lock", "waiting_approval":
+		return true
+	}
+	return false
+}
+
+// Save writes the current state of a run
+func (s *RunStore) Save(run *models.WorkflowExecution) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.dataDir, run.ID+".json"), data, 0644)
+}
+
+// Get retrieves a run by ID
+func (s *RunStore) Get(id string) (*models.WorkflowExecution, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if id == "" || id != filepath.Base(id) {
+		return nil, ErrRunNotFound
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dataDir, id+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrRunNotFound
+		}
+		return nil, err
+	}
+
+	var run models.WorkflowExecution
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// ListByWorkflow returns the runs of a workflow, newest first
+func (s *RunStore) ListByWorkflow(workflowID string) ([]*models.WorkflowExecution, error) {
+	runs, err := s.readAll()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*models.WorkflowExecution, 0)
+	for _, run := range runs {
+		if run.WorkflowID == workflowID {
+			result = append(result, run)
+		}
+	}
+	return result, nil
+}
+
+// GetRetention returns the current retention settings
+func (s *RunStore) GetRetention() models.RunRetention {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.retention
+}
+
+// SetRetention replaces the retention settings
+func (s *RunStore) SetRetention(retention models.RunRetention) error {
+	if retention.MaxRunsPerWorkflow < 0 || retention.MaxAgeDays < 0 ||
+		retention.ArtifactMaxAgeDays < 0 || retention.ArtifactMaxRunBytes < 0 {
+		return fmt.Errorf("retention limits must not be negative")
+	}
+
+	s.mu.Lock()
+	s.retention = retention
+	s.mu.Unlock()
+	return nil
+}
+
+// Prune deletes finished runs beyond the retention limits, with their
+// artifacts, and returns how many were removed. Artifacts older than the
+// artifact age limit are deleted from the runs that are kept. Active runs
+// are never touched.
+func (s *RunStore) Prune() (int, error) {
+	retention := s.GetRetention()
+	if retention.MaxRunsPerWorkflow == 0 && retention.MaxAgeDays == 0 && retention.ArtifactMaxAgeDays == 0 {
+		return 0, nil
+	}
+
+	runs, err := s.readAll()
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -retention.MaxAgeDays)
+	artifactCutoff := time.Now().AddDate(0, 0, -retention.ArtifactMaxAgeDays)
+	kept := make(map[string]int)
+	removed := 0
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, run := range runs {
+		if isActiveRunStatus(run.Status) {
+			continue
+		}
+
+		kept[run.WorkflowID]++
+		expired := retention.MaxAgeDays > 0 && runStartTime(run).Before(cutoff)
+		overLimit := retention.MaxRunsPerWorkflow > 0 && kept[run.WorkflowID] > retention.MaxRunsPerWorkflow
+		if !expired && !overLimit {
+			if retention.ArtifactMaxAgeDays > 0 {
+				if err := s.expireArtifacts(run, artifactCutoff); err != nil {
+					return removed, err
+				}
+			}
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(s.dataDir, run.ID+".json")); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		if err := os.RemoveAll(s.runDir(run.ID)); err != nil {
+			return removed, err
+		}
+		kept[run.WorkflowID]--
+		removed++
+	}
+
+	return removed, nil
+}
+
+// expireArtifacts deletes the artifacts of a run created before cutoff and
+// marks them expired. Callers hold s.mu.
+func (s *RunStore) expireArtifacts(run *models.WorkflowExecution, cutoff time.Time) error {
+	changed := false
+	for i := range run.Artifacts {
+		artifact := &run.Artifacts[i]
+		if artifact.Expired || !artifact.CreatedAt.Before(cutoff) {
+			continue
+		}
+		file := filepath.Join(s.runDir(run.ID), "artifacts", filepath.FromSlash(artifact.Path))
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		artifact.Expired = true
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.dataDir, run.ID+".json"), data, 0644)
+}
+
+// readAll loads every stored run, newest first
+func (s *RunStore) readAll() ([]*models.WorkflowExecution, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries, err := os.ReadDir(s.dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var runs []*models.WorkflowExecution
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(s.dataDir, entry.Name()))
+		if err != nil {
+			continue // Skip unreadable files
+		}
+
+		var run models.WorkflowExecution
+		if err := json.Unmarshal(data, &run); err == nil {
+			runs = append(runs, &run)
+		}
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runStartTime(runs[i]).After(runStartTime(runs[j]))
+	})
+	return runs, nil
+}
+
+func runStartTime(run *models.WorkflowExecution) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, run.StartTime)
+	return t
+}
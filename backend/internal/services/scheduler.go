@@ -0,0 +1,593 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+	_ "time/tzdata" // Time zones must resolve even on hosts without zoneinfo
+
+	"github.com/devopstools/backend/internal/logger"
+	"github.com/devopstools/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+const (
+	OverlapSkip  = "skip"
+	OverlapQueue = "queue"
+	OverlapAllow = "allow"
+
+	// maxScheduleRuns bounds the run history kept per schedule
+	maxScheduleRuns = 100
+)
+
+// SchedulerService starts workflows and command queues on cron schedules
+type SchedulerService struct {
+	dataDir   string
+	store     *WorkflowStore
+	executor  *WorkflowExecutor
+	queue     *CommandQueue
+	mu        sync.RWMutex
+	schedules map[string]*models.Schedule
+	runs      map[string][]*models.ScheduleRun // schedule ID -> history, oldest first
+	active    map[string]int                   // running activations per schedule
+	waiting   map[string]*models.ScheduleRun   // activation held back by the queue overlap policy
+	onRunEnd  func(models.Schedule, models.ScheduleRun)
+	wake      chan struct{}
+	stop      chan struct{}
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+// NewSchedulerService creates the scheduler and loads persisted schedules
+func NewSchedulerService(dataDir string, store *WorkflowStore, executor *WorkflowExecutor, queue *CommandQueue) (*SchedulerService, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &SchedulerService{
+		dataDir:   dataDir,
+		store:     store,
+		executor:  executor,
+		queue:     queue,
+		schedules: make(map[string]*models.Schedule),
+		runs:      make(map[string][]*models.ScheduleRun),
+		active:    make(map[string]int),
+		waiting:   make(map[string]*models.ScheduleRun),
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// SetRunEndCallback sets the callback invoked when an activation ends or is
+// skipped. It is called with the scheduler locked and must not block.
+func (s *SchedulerService) SetRunEndCallback(callback func(models.Schedule, models.ScheduleRun)) {
+	s.onRunEnd = callback
+}
+
+func (s *SchedulerService) load() error {
+	var schedules []*models.Schedule
+	if err := readDataFile(filepath.Join(s.dataDir, "schedules.json"), &schedules); err != nil {
+		return err
+	}
+	for _, schedule := range schedules {
+		s.schedules[schedule.ID] = schedule
+	}
+
+	var runs []*models.ScheduleRun
+	if err := readDataFile(filepath.Join(s.dataDir, "schedule_runs.json"), &runs); err != nil {
+		return err
+	}
+	for _, run := range runs {
+		s.runs[run.ScheduleID] = append(s.runs[run.ScheduleID], run)
+	}
+
+	// Activations that were in flight when the server stopped will never finish
+	now := time.Now()
+	for _, history := range s.runs {
+		for _, run := range history {
+			if run.Status == "running" || run.Status == "queued" {
+				run.Status = "interrupted"
+				run.Error = "server restarted before the run finished"
+				run.EndedAt = &now
+			}
+		}
+	}
+
+	// Activations missed while the server was down are not replayed
+	for _, schedule := range s.schedules {
+		if !schedule.Enabled {
+			schedule.NextRunAt = nil
+			continue
+		}
+		if err := s.computeNextRun(schedule, now); err != nil {
+			logger.Warn("Disabling schedule with invalid definition", logger.WithFields(map[string]interface{}{
+				"schedule_id": schedule.ID,
+				"error":       err.Error(),
+			}).Data)
+			schedule.Enabled = false
+			schedule.NextRunAt = nil
+		}
+	}
+
+	return nil
+}
+
+// save persists schedules and run history. Callers hold s.mu.
+func (s *SchedulerService) save() error {
+	schedules := make([]*models.Schedule, 0, len(s.schedules))
+	for _, schedule := range s.schedules {
+		schedules = append(schedules, schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].CreatedAt.Before(schedules[j].CreatedAt) })
+
+	runs := make([]*models.ScheduleRun, 0)
+	for _, history := range s.runs {
+		runs = append(runs, history...)
+	}
+
+	if err := writeDataFile(filepath.Join(s.dataDir, "schedules.json"), schedules); err != nil {
+		return err
+	}
+	return writeDataFile(filepath.Join(s.dataDir, "schedule_runs.json"), runs)
+}
+
+// readDataFile decodes a JSON data file, leaving v untouched if it does not exist yet
+func readDataFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeDataFile encodes v as indented JSON
+func writeDataFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Start begins dispatching due schedules in the background
+func (s *SchedulerService) Start() {
+	go s.loop()
+}
+
+// Stop halts dispatching and cancels running activations
+func (s *SchedulerService) Stop() {
+	close(s.stop)
+	s.cancel()
+}
+
+func (s *SchedulerService) loop() {
+	for {
+		wait := s.dispatchDue(time.Now())
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+			timer.Stop()
+		case <-s.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// notify wakes the dispatch loop after schedules change
+func (s *SchedulerService) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatchDue fires every schedule whose next run is due and returns how long to sleep
+func (s *SchedulerService) dispatchDue(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wait := time.Minute
+	fired := false
+	for _, schedule := range s.schedules {
+		if !schedule.Enabled || schedule.NextRunAt == nil {
+			continue
+		}
+
+		if !schedule.NextRunAt.After(now) {
+			scheduledFor := *schedule.NextRunAt
+			if err := s.computeNextRun(schedule, now); err != nil {
+				schedule.Enabled = false
+				schedule.NextRunAt = nil
+				continue
+			}
+			s.fire(schedule, scheduledFor, "cron")
+			fired = true
+		}
+
+		if schedule.NextRunAt != nil {
+			if until := schedule.NextRunAt.Sub(now); until < wait {
+				wait = until
+			}
+		}
+	}
+
+	if fired {
+		if err := s.save(); err != nil {
+			logger.Error("Failed to save schedules", err)
+		}
+	}
+
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait
+}
+
+// computeNextRun sets the schedule's next activation after now
+func (s *SchedulerService) computeNextRun(schedule *models.Schedule, now time.Time) error {
+	cron, err := ParseCron(schedule.CronExpression)
+	if err != nil {
+		return err
+	}
+	loc, err := loadScheduleLocation(schedule.TimeZone)
+	if err != nil {
+		return err
+	}
+
+	next := cron.Next(now.In(loc))
+	if next.IsZero() {
+		schedule.NextRunAt = nil
+		return nil
+	}
+	schedule.NextRunAt = &next
+	return nil
+}
+
+// fire records an activation and starts it according to the overlap policy. Callers hold s.mu.
+func (s *SchedulerService) fire(schedule *models.Schedule, scheduledFor time.Time, trigger string) *models.ScheduleRun {
+	run := &models.ScheduleRun{
+		ID:           uuid.New().String(),
+		ScheduleID:   schedule.ID,
+		TargetType:   schedule.TargetType,
+		TargetID:     schedule.TargetID,
+		Trigger:      trigger,
+		ScheduledFor: scheduledFor,
+	}
+	s.recordRun(run)
+
+	if s.active[schedule.ID] > 0 {
+		switch schedule.OverlapPolicy {
+		case OverlapQueue:
+			if previous, ok := s.waiting[schedule.ID]; ok {
+				s.skipRun(previous, "superseded by a newer activation while the previous run was still active")
+			}
+			run.Status = "queued"
+			s.waiting[schedule.ID] = run
+			return run
+		case OverlapAllow:
+		default:
+			s.skipRun(run, "previous run still active")
+			schedule.LastStatus = run.Status
+			return run
+		}
+	}
+
+	s.start(schedule, run)
+	return run
+}
+
+// start launches an activation. Callers hold s.mu.
+func (s *SchedulerService) start(schedule *models.Schedule, run *models.ScheduleRun) {
+	now := time.Now()
+	run.Status = "running"
+	run.StartedAt = &now
+	schedule.LastRunAt = &now
+	schedule.LastStatus = run.Status
+	s.active[schedule.ID]++
+
+	target := *schedule
+	go s.execute(target, run)
+}
+
+// execute runs the schedule target and records the outcome
+func (s *SchedulerService) execute(schedule models.Schedule, run *models.ScheduleRun) {
+	status := "completed"
+	var runErr error
+
+	switch schedule.TargetType {
+	case "workflow":
+		outputChan := make(chan string, 100)
+		trigger := models.RunTrigger{Type: "schedule", ID: schedule.ID}
+		execution, err := s.executor.ExecuteTriggered(s.ctx, schedule.TargetID, schedule.Environment, schedule.Variables, trigger, outputChan)
+		if err != nil {
+			runErr = err
+			break
+		}
+
+		s.mu.Lock()
+		run.RunID = execution.ID
+		s.mu.Unlock()
+
+		// The channel closes once the workflow finishes
+		for range outputChan {
+		}
+		if execution.Status != "completed" {
+			runErr = fmt.Errorf("workflow %s", execution.Status)
+		}
+	case "queue":
+		s.mu.Lock()
+		run.RunID = schedule.TargetID
+		s.mu.Unlock()
+
+		runErr = s.queue.ExecuteQueue(s.ctx, schedule.TargetID)
+		if runErr == nil {
+			if queue, err := s.queue.GetQueue(schedule.TargetID); err == nil && queue.Status != "completed" {
+				runErr = fmt.Errorf("queue %s", queue.Status)
+			}
+		}
+	default:
+		runErr = fmt.Errorf("unknown target type: %s", schedule.TargetType)
+	}
+
+	if runErr != nil {
+		status = "failed"
+		logger.Error("Scheduled run failed", runErr, logger.WithFields(map[string]interface{}{
+			"schedule_id": schedule.ID,
+			"run_id":      run.ID,
+		}).Data)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	run.Status = status
+	run.EndedAt = &now
+	if runErr != nil {
+		run.Error = runErr.Error()
+	}
+
+	s.active[schedule.ID]--
+	s.runEnded(run)
+	if current, ok := s.schedules[schedule.ID]; ok {
+		current.LastStatus = status
+
+		// Start the activation held back by the queue overlap policy
+		if waiting, ok := s.waiting[schedule.ID]; ok && s.active[schedule.ID] == 0 {
+			delete(s.waiting, schedule.ID)
+			s.start(current, waiting)
+		}
+	}
+
+	if err := s.save(); err != nil {
+		logger.Error("Failed to save schedule runs", err)
+	}
+}
+
+// skipRun marks an activation as skipped. Callers hold s.mu.
+func (s *SchedulerService) skipRun(run *models.ScheduleRun, reason string) {
+	now := time.Now()
+	run.Status = "skipped"
+	run.Error = reason
+	run.EndedAt = &now
+	s.runEnded(run)
+}
+
+// runEnded passes an ended activation to the callback. Callers hold s.mu.
+func (s *SchedulerService) runEnded(run *models.ScheduleRun) {
+	if s.onRunEnd == nil {
+		return
+	}
+	schedule, ok := s.schedules[run.ScheduleID]
+	if !ok {
+		return
+	}
+	s.onRunEnd(*schedule, *run)
+}
+
+// recordRun appends an activation to the history, dropping the oldest. Callers hold s.mu.
+func (s *SchedulerService) recordRun(run *models.ScheduleRun) {
+	history := append(s.runs[run.ScheduleID], run)
+	if len(history) > maxScheduleRuns {
+		history = history[len(history)-maxScheduleRuns:]
+	}
+	s.runs[run.ScheduleID] = history
+}
+
+// validate normalises and checks a schedule definition
+func (s *SchedulerService) validate(schedule *models.Schedule) error {
+	if schedule.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if _, err := ParseCron(schedule.CronExpression); err != nil {
+		return err
+	}
+	if schedule.TimeZone == "" {
+		schedule.TimeZone = "UTC"
+	}
+	if _, err := loadScheduleLocation(schedule.TimeZone); err != nil {
+		return err
+	}
+	if schedule.OverlapPolicy == "" {
+		schedule.OverlapPolicy = OverlapSkip
+	}
+
+	switch schedule.OverlapPolicy {
+	case OverlapSkip, OverlapQueue, OverlapAllow:
+	default:
+		return fmt.Errorf("invalid overlap_policy: %s (expected skip, queue or allow)", schedule.OverlapPolicy)
+	}
+
+	switch schedule.TargetType {
+	case "workflow":
+		if _, err := s.store.Get(schedule.TargetID); err != nil {
+			return fmt.Errorf("target workflow %s: %w", schedule.TargetID, err)
+		}
+		if schedule.Environment != "" {
+			if _, err := s.executor.variableService.GetEnvironment(schedule.Environment); err != nil {
+				return err
+			}
+		}
+	case "queue":
+		if _, err := s.queue.GetQueue(schedule.TargetID); err != nil {
+			return err
+		}
+		if schedule.Environment != "" {
+			return fmt.Errorf("environment is only supported for workflow targets")
+		}
+		if schedule.OverlapPolicy == OverlapAllow {
+			return fmt.Errorf("overlap_policy allow is not supported for queues, a queue cannot run twice at once")
+		}
+	default:
+		return fmt.Errorf("invalid target_type: %s (expected workflow or queue)", schedule.TargetType)
+	}
+
+	return nil
+}
+
+// List returns all schedules
+func (s *SchedulerService) List() []models.Schedule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	schedules := make([]models.Schedule, 0, len(s.schedules))
+	for _, schedule := range s.schedules {
+		schedules = append(schedules, *schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].CreatedAt.Before(schedules[j].CreatedAt) })
+	return schedules
+}
+
+// Get returns a schedule by ID
+func (s *SchedulerService) Get(id string) (*models.Schedule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	schedule, ok := s.schedules[id]
+	if !ok {
+		return nil, fmt.Errorf("schedule not found: %s", id)
+	}
+	snapshot := *schedule
+	return &snapshot, nil
+}
+
+// Save creates or replaces a schedule
+func (s *SchedulerService) Save(schedule models.Schedule) (*models.Schedule, error) {
+	if err := s.validate(&schedule); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if schedule.ID == "" {
+		schedule.ID = uuid.New().String()
+	}
+	if existing, ok := s.schedules[schedule.ID]; ok {
+		schedule.CreatedAt = existing.CreatedAt
+		schedule.LastRunAt = existing.LastRunAt
+		schedule.LastStatus = existing.LastStatus
+	} else {
+		schedule.CreatedAt = now
+	}
+	schedule.UpdatedAt = now
+
+	schedule.NextRunAt = nil
+	if schedule.Enabled {
+		if err := s.computeNextRun(&schedule, now); err != nil {
+			return nil, err
+		}
+	}
+
+	s.schedules[schedule.ID] = &schedule
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+	s.notify()
+
+	snapshot := schedule
+	return &snapshot, nil
+}
+
+// Delete removes a schedule and its history
+func (s *SchedulerService) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.schedules[id]; !ok {
+		return fmt.Errorf("schedule not found: %s", id)
+	}
+	delete(s.schedules, id)
+	delete(s.runs, id)
+	delete(s.waiting, id)
+
+	return s.save()
+}
+
+// ListRuns returns the run history of a schedule, newest first
+func (s *SchedulerService) ListRuns(id string) ([]models.ScheduleRun, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.schedules[id]; !ok {
+		return nil, fmt.Errorf("schedule not found: %s", id)
+	}
+
+	history := s.runs[id]
+	runs := make([]models.ScheduleRun, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		runs = append(runs, *history[i])
+	}
+	return runs, nil
+}
+
+// Trigger starts a schedule immediately, applying its overlap policy
+func (s *SchedulerService) Trigger(id string) (*models.ScheduleRun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedule, ok := s.schedules[id]
+	if !ok {
+		return nil, fmt.Errorf("schedule not found: %s", id)
+	}
+
+	run := s.fire(schedule, time.Now(), "manual")
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+
+	snapshot := *run
+	return &snapshot, nil
+}
+
+func loadScheduleLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time_zone %q: %w", name, err)
+	}
+	return loc, nil
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/devopstools/backend/internal/crypto"
+)
+
+const secretKeySize = 32 // AES-256
+
+// LoadSecretKey returns the key used to encrypt secret variables. With a
+// passphrase the key is derived from it and a salt kept in dataDir; without
+// one a random key is generated once and stored in dataDir.
+func LoadSecretKey(dataDir, passphrase string) ([]byte, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	if passphrase == "" {
+		return readOrCreate(filepath.Join(dataDir, "secrets.key"), func() ([]byte, error) {
+			return crypto.GenerateSalt() // 32 random bytes
+		})
+	}
+
+	salt, err := readOrCreate(filepath.Join(dataDir, "secrets.salt"), crypto.GenerateSalt)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.DeriveKey(passphrase, salt), nil
+}
+
+// readOrCreate reads a 32 byte file, creating it with generate if missing
+func readOrCreate(path string, generate func() ([]byte, error)) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if len(data) != secretKeySize {
+			return nil, fmt.Errorf("%s: expected %d bytes, found %d", path, secretKeySize, len(data))
+		}
+		return data, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	data, err = generate()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
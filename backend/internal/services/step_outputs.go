@@ -0,0 +1,137 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/devopstools/backend/internal/models"
+)
+
+// captureOutputs extracts the step's declared outputs into the variables.
+// A missing value falls back to the output's default; without one it fails
+// a successful step, while for a failed step it is only logged.
+func (e *WorkflowExecutor) captureOutputs(step models.Step, stdout string, exitCode int, succeeded bool, variables map[string]string, outputChan chan<- string, execution *models.WorkflowExecution) error {
+	for _, out := range step.Outputs {
+		value, err := extractOutput(out, stdout, exitCode)
+		if err != nil && out.Default != "" {
+			value, err = out.Default, nil
+		}
+		if err != nil {
+			err = fmt.Errorf("output %s: %w", out.Name, err)
+			if succeeded {
+				return err
+			}
+			e.log(outputChan, execution, step.ID, 0, "warning", err.Error())
+			continue
+		}
+
+		e.varsMu.Lock()
+		variables[out.Name] = value
+		e.varsMu.Unlock()
+		e.logInfo(outputChan, execution, step.ID, fmt.Sprintf("Captured %s = %s", out.Name, value))
+	}
+	return nil
+}
+
+// extractOutput applies a single output extraction to a step result
+func extractOutput(out models.StepOutput, stdout string, exitCode int) (string, error) {
+	if out.Name == "" {
+		return "", fmt.Errorf("name is required")
+	}
+
+	switch out.Type {
+	case "stdout":
+		return strings.TrimSpace(stdout), nil
+
+	case "exit_code":
+		return strconv.Itoa(exitCode), nil
+
+	case "regex":
+		re, err := regexp.Compile(out.Pattern)
+		if err != nil {
+			return "", fmt.Errorf("invalid pattern: %w", err)
+		}
+		group := out.Group
+		if group == 0 && re.NumSubexp() > 0 {
+			group = 1
+		}
+		if group < 0 || group > re.NumSubexp() {
+			return "", fmt.Errorf("pattern has no capture group %d", group)
+		}
+		match := re.FindStringSubmatch(stdout)
+		if match == nil {
+			return "", fmt.Errorf("pattern %q did not match", out.Pattern)
+		}
+		return match[group], nil
+
+	case "json":
+		var doc interface{}
+		if err := json.Unmarshal([]byte(stdout), &doc); err != nil {
+			return "", fmt.Errorf("stdout is not valid JSON: %w", err)
+		}
+		value, err := lookupJSONPath(doc, out.Path)
+		if err != nil {
+			return "", err
+		}
+		return jsonValueString(value)
+
+	default:
+		return "", fmt.Errorf("unknown output type: %s (expected regex, json, stdout or exit_code)", out.Type)
+	}
+}
+
+// lookupJSONPath resolves a path such as "$.Instances[0].InstanceId" or
+// "Instances.0.InstanceId" in a decoded JSON document
+func lookupJSONPath(doc interface{}, path string) (interface{}, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	path = strings.NewReplacer("[", ".", "]", "").Replace(path)
+	if path == "" {
+		return doc, nil
+	}
+
+	current := doc
+	for _, key := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[key]
+			if !ok {
+				return nil, fmt.Errorf("path %q: key %q not found", path, key)
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil {
+				return nil, fmt.Errorf("path %q: %q is not an array index", path, key)
+			}
+			if index < 0 {
+				index += len(node)
+			}
+			if index < 0 || index >= len(node) {
+				return nil, fmt.Errorf("path %q: index %s out of range", path, key)
+			}
+			current = node[index]
+		default:
+			return nil, fmt.Errorf("path %q: cannot look up %q in a scalar value", path, key)
+		}
+	}
+	return current, nil
+}
+
+// jsonValueString renders a JSON value as a variable value. Strings are used
+// as-is, other scalars in their JSON form and objects or arrays as JSON.
+func jsonValueString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", fmt.Errorf("value is null")
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -1,37 +1,276 @@
 package services
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
 	"regexp"
 	"strings"
+
+	"github.com/devopstools/backend/internal/models"
 )
 
+// Placeholders look like {NAME}, {NAME|default}, {NAME|lower|quote} or
+// {env:HOME}. Segments after | are filters when they name one, otherwise the
+// default value; a quoted segment is always the default. ${NAME} is left to
+// the shell.
 type TemplateParser struct {
 	variablePattern *regexp.Regexp
+	lookupEnv       func(string) (string, bool)
+}
+
+// templateFilters transform a value or change how it is escaped
+var templateFilters = map[string]bool{
+	"lower":  true,
+	"upper":  true,
+	"json":   true,
+	"base64": true,
+	"quote":  true, // always single-quote
+	"raw":    true, // insert without shell escaping
 }
 
+// shellSafe matches values that need no quoting in a shell word
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// defaultEnvAllowlist names the server environment variables {env:NAME} can
+// read unless TEMPLATE_ENV_ALLOWLIST lists others
+var defaultEnvAllowlist = []string{"HOME", "USER", "HOSTNAME", "PATH", "TMPDIR"}
+
 func NewTemplateParser() *TemplateParser {
 	return &TemplateParser{
-		variablePattern: regexp.MustCompile(`\{([A-Z_][A-Z0-9_]*)\}`),
+		variablePattern: regexp.MustCompile(`\{(env:[A-Za-z_][A-Za-z0-9_]*|[A-Z_][A-Z0-9_]*)((?:\|[^{}|]*)*)\}`),
+		lookupEnv:       allowedEnv(envAllowlist()),
+	}
+}
+
+// envAllowlist reads TEMPLATE_ENV_ALLOWLIST, a comma separated list of names.
+// Set but empty, it turns {env:...} lookups off.
+func envAllowlist() []string {
+	value, ok := os.LookupEnv("TEMPLATE_ENV_ALLOWLIST")
+	if !ok {
+		return defaultEnvAllowlist
+	}
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// allowedEnv looks up server environment variables, limited to names. Others,
+// such as credentials, are never substituted where previews and run logs
+// would show them; they stay unresolved.
+func allowedEnv(names []string) func(string) (string, bool) {
+	allowed := make(map[string]bool, len(names))
+	for _, name := range names {
+		allowed[name] = true
+	}
+	return func(name string) (string, bool) {
+		if !allowed[name] {
+			return "", false
+		}
+		return os.LookupEnv(name)
+	}
+}
+
+// placeholder is one parsed {...} reference in a template
+type placeholder struct {
+	Text       string // as written
+	Start, End int    // byte offsets in the template
+	Name       string // variable name, or the environment variable for env lookups
+	Env        bool
+	Default    string
+	HasDefault bool
+	Filters    []string
+}
+
+// Key is how the placeholder is reported when unresolved
+func (p placeholder) Key() string {
+	if p.Env {
+		return "env:" + p.Name
+	}
+	return p.Name
+}
+
+// RenderOptions controls how a template is rendered
+type RenderOptions struct {
+	// Escape shell-escapes inserted values so each stays one word for sh
+	Escape bool
+	// Secret is asked about every resolved variable; for secrets it returns
+	// the text to insert instead of the value, such as a shell reference
+	Secret func(name, value string) (string, bool)
+}
+
+// RenderResult is a rendered template and the placeholders left in it
+type RenderResult struct {
+	Text       string
+	Unresolved []string
+	Spans      []models.TemplateSpan // unresolved placeholders in Text
+}
+
+// placeholders parses every placeholder in a template. Malformed ones are
+// returned as errors and skipped.
+func (tp *TemplateParser) placeholders(template string) ([]placeholder, []error) {
+	var result []placeholder
+	var errs []error
+	for _, m := range tp.variablePattern.FindAllStringSubmatchIndex(template, -1) {
+		if m[0] > 0 && template[m[0]-1] == '$' {
+			continue // ${NAME} is shell syntax
+		}
+		p := placeholder{
+			Text:  template[m[0]:m[1]],
+			Start: m[0],
+			End:   m[1],
+			Name:  template[m[2]:m[3]],
+		}
+		if strings.HasPrefix(p.Name, "env:") {
+			p.Env = true
+			p.Name = strings.TrimPrefix(p.Name, "env:")
+		}
+
+		if m[5] > m[4] {
+			var err error
+			for _, segment := range strings.Split(template[m[4]+1:m[5]], "|") {
+				segment = strings.TrimSpace(segment)
+				switch {
+				case templateFilters[segment]:
+					p.Filters = append(p.Filters, segment)
+				case isQuoted(segment):
+					p.Default, p.HasDefault = segment[1:len(segment)-1], true
+				case !p.HasDefault:
+					p.Default, p.HasDefault = segment, true
+				default:
+					err = fmt.Errorf("%s: unknown filter %q", p.Text, segment)
+				}
+			}
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		result = append(result, p)
+	}
+	return result, errs
+}
+
+func isQuoted(s string) bool {
+	return len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0]
+}
+
+// Render expands the placeholders of a template. Missing variables without a
+// default are left as written and listed as unresolved. The error reports
+// malformed placeholders, which are also left as written.
+func (tp *TemplateParser) Render(template string, variables map[string]string, opts RenderOptions) (*RenderResult, error) {
+	placeholders, errs := tp.placeholders(template)
+
+	var b strings.Builder
+	result := &RenderResult{Unresolved: []string{}}
+	seen := make(map[string]bool)
+	last := 0
+	for _, p := range placeholders {
+		b.WriteString(template[last:p.Start])
+		last = p.End
+
+		text, ok := tp.resolve(p, variables, opts)
+		if !ok {
+			start := b.Len()
+			b.WriteString(p.Text)
+			result.Spans = append(result.Spans, models.TemplateSpan{Start: start, End: b.Len(), Name: p.Key()})
+			if !seen[p.Key()] {
+				seen[p.Key()] = true
+				result.Unresolved = append(result.Unresolved, p.Key())
+			}
+			continue
+		}
+		b.WriteString(text)
+	}
+	b.WriteString(template[last:])
+	result.Text = b.String()
+
+	if len(errs) > 0 {
+		return result, errs[0]
+	}
+	return result, nil
+}
+
+// resolve returns the text inserted for a placeholder
+func (tp *TemplateParser) resolve(p placeholder, variables map[string]string, opts RenderOptions) (string, bool) {
+	var value string
+	var ok bool
+	if p.Env {
+		value, ok = tp.lookupEnv(p.Name)
+	} else {
+		value, ok = variables[p.Name]
+	}
+	if (!ok || value == "") && p.HasDefault {
+		value, ok = p.Default, true
+	}
+	if !ok {
+		return "", false
+	}
+
+	escape := "auto"
+	for _, filter := range p.Filters {
+		switch filter {
+		case "lower":
+			value = strings.ToLower(value)
+		case "upper":
+			value = strings.ToUpper(value)
+		case "json":
+			encoded, _ := json.Marshal(value)
+			value = string(encoded)
+		case "base64":
+			value = base64.StdEncoding.EncodeToString([]byte(value))
+		case "quote", "raw":
+			escape = filter
+		}
+	}
+
+	if !p.Env && opts.Secret != nil {
+		if ref, secret := opts.Secret(p.Name, value); secret {
+			return ref, true
+		}
 	}
+
+	switch {
+	case escape == "raw" || !opts.Escape && escape == "auto":
+		return value, true
+	case escape == "quote":
+		return shellQuote(value), true
+	default:
+		return ShellEscape(value), true
+	}
+}
+
+// ShellEscape quotes a value for sh unless it is a plain word
+func ShellEscape(value string) string {
+	if shellSafe.MatchString(value) {
+		return value
+	}
+	return shellQuote(value)
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
 }
 
 // ExtractVariables extracts all variable names from a command template
-// Example: "aws ec2 run-instances --image-id {AMI_ID} --instance-type {INSTANCE_TYPE}"
+// Example: "aws ec2 run-instances --image-id {AMI_ID} --instance-type {INSTANCE_TYPE|t3.micro}"
 // Returns: ["AMI_ID", "INSTANCE_TYPE"]
 func (tp *TemplateParser) ExtractVariables(command string) []string {
-	matches := tp.variablePattern.FindAllStringSubmatch(command, -1)
+	placeholders, _ := tp.placeholders(command)
 
 	// Use map to deduplicate
 	seen := make(map[string]bool)
 	variables := make([]string, 0)
 
-	for _, match := range matches {
-		if len(match) > 1 {
-			varName := match[1]
-			if !seen[varName] {
-				seen[varName] = true
-				variables = append(variables, varName)
-			}
+	for _, p := range placeholders {
+		if !p.Env && !seen[p.Name] {
+			seen[p.Name] = true
+			variables = append(variables, p.Name)
 		}
 	}
 
@@ -42,41 +281,28 @@ func (tp *TemplateParser) ExtractVariables(command string) []string {
 // Example: "aws ec2 run-instances --image-id {AMI_ID}" with {"AMI_ID": "ami-12345"}
 // Returns: "aws ec2 run-instances --image-id ami-12345"
 func (tp *TemplateParser) SubstituteVariables(command string, variables map[string]string) string {
-	result := command
-
-	for key, value := range variables {
-		placeholder := "{" + key + "}"
-		result = strings.ReplaceAll(result, placeholder, value)
-	}
-
-	return result
+	result, _ := tp.Render(command, variables, RenderOptions{})
+	return result.Text
 }
 
 // ValidateCommand checks if all variables in the command have values
 // Returns list of missing variables
 func (tp *TemplateParser) ValidateCommand(command string, variables map[string]string) []string {
-	required := tp.ExtractVariables(command)
-	missing := make([]string, 0)
-
-	for _, varName := range required {
-		if _, ok := variables[varName]; !ok {
-			missing = append(missing, varName)
-		}
-	}
-
-	return missing
+	result, _ := tp.Render(command, variables, RenderOptions{})
+	return result.Unresolved
 }
 
-// PreviewCommand shows the command with variables substituted and highlights missing ones
-func (tp *TemplateParser) PreviewCommand(command string, variables map[string]string) string {
-	result := command
-
-	for key, value := range variables {
-		placeholder := "{" + key + "}"
-		if value != "" {
-			result = strings.ReplaceAll(result, placeholder, value)
-		}
+// PreviewCommand renders a command as it would run, shell escaping included,
+// and marks the placeholders that could not be resolved
+func (tp *TemplateParser) PreviewCommand(command string, variables map[string]string) models.CommandPreview {
+	result, err := tp.Render(command, variables, RenderOptions{Escape: true})
+	preview := models.CommandPreview{
+		Command:    result.Text,
+		Unresolved: result.Unresolved,
+		Highlights: result.Spans,
 	}
-
-	return result
+	if err != nil {
+		preview.Error = err.Error()
+	}
+	return preview
 }
@@ -20,6 +20,12 @@ func NewTerraformService() *TerraformService {
 
 // ExecuteCommand executes a generic terraform command
 func (s *TerraformService) ExecuteCommand(workDir string, command string, args ...string) (*models.TerraformExecution, error) {
+	return s.ExecuteCommandContext(context.Background(), workDir, command, args...)
+}
+
+// ExecuteCommandContext is ExecuteCommand stopped early when ctx ends;
+// the terraform process is killed rather than left running
+func (s *TerraformService) ExecuteCommandContext(ctx context.Context, workDir string, command string, args ...string) (*models.TerraformExecution, error) {
 	execution := &models.TerraformExecution{
 		ID:        uuid.New().String(),
 		Command:   command,
@@ -34,7 +40,7 @@ func (s *TerraformService) ExecuteCommand(workDir string, command string, args .
 		"dir":     workDir,
 	}).Data)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second) // 5 min timeout
+	ctx, cancel := context.WithTimeout(ctx, 300*time.Second) // 5 min timeout
 	defer cancel()
 
 	fullArgs := append([]string{command}, args...)
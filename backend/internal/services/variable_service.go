@@ -8,13 +8,25 @@ import (
 	"sync"
 	"time"
 
+	"github.com/devopstools/backend/internal/crypto"
 	"github.com/devopstools/backend/internal/models"
 )
 
+var ErrNoSecretKey = fmt.Errorf("secret variables are not enabled")
+
 type VariableService struct {
-	dataDir string
-	mu      sync.RWMutex
-	cache   map[string]models.GlobalVariable
+	dataDir      string
+	mu           sync.RWMutex
+	cache        map[string]storedVariable
+	environments map[string]*environmentSet
+	key          []byte // encrypts secret values
+}
+
+// storedVariable is a global variable as written to disk. Secret values stay
+// encrypted in the cache too and are only decrypted for runs.
+type storedVariable struct {
+	models.GlobalVariable
+	IV string `json:"iv,omitempty"`
 }
 
 func NewVariableService(dataDir string) (*VariableService, error) {
@@ -23,14 +35,18 @@ func NewVariableService(dataDir string) (*VariableService, error) {
 	}
 
 	vs := &VariableService{
-		dataDir: dataDir,
-		cache:   make(map[string]models.GlobalVariable),
+		dataDir:      dataDir,
+		cache:        make(map[string]storedVariable),
+		environments: make(map[string]*environmentSet),
 	}
 
 	// Load existing variables into cache
 	if err := vs.loadCache(); err != nil {
 		return nil, err
 	}
+	if err := vs.loadEnvironments(); err != nil {
+		return nil, err
+	}
 
 	return vs, nil
 }
@@ -46,7 +62,7 @@ func (vs *VariableService) loadCache() error {
 		return err
 	}
 
-	var variables []models.GlobalVariable
+	var variables []storedVariable
 	if err := json.Unmarshal(data, &variables); err != nil {
 		return err
 	}
@@ -58,11 +74,14 @@ func (vs *VariableService) loadCache() error {
 	return nil
 }
 
-func (vs *VariableService) saveCache() error {
-	vs.mu.RLock()
-	defer vs.mu.RUnlock()
+// SetEncryptionKey sets the key used to encrypt secret variables
+func (vs *VariableService) SetEncryptionKey(key []byte) {
+	vs.key = key
+}
 
-	variables := make([]models.GlobalVariable, 0, len(vs.cache))
+// saveCache writes the cache to disk. Callers hold vs.mu.
+func (vs *VariableService) saveCache() error {
+	variables := make([]storedVariable, 0, len(vs.cache))
 	for _, v := range vs.cache {
 		variables = append(variables, v)
 	}
@@ -82,7 +101,7 @@ func (vs *VariableService) List() []models.GlobalVariable {
 
 	variables := make([]models.GlobalVariable, 0, len(vs.cache))
 	for _, v := range vs.cache {
-		variables = append(variables, v)
+		variables = append(variables, v.masked())
 	}
 
 	return variables
@@ -97,22 +116,50 @@ func (vs *VariableService) Get(name string) (*models.GlobalVariable, error) {
 		return nil, fmt.Errorf("variable not found: %s", name)
 	}
 
-	return &v, nil
+	variable := v.masked()
+	return &variable, nil
 }
 
 func (vs *VariableService) Set(variable models.GlobalVariable) error {
 	vs.mu.Lock()
 	defer vs.mu.Unlock()
 
+	stored, err := vs.encode(variable, vs.cache)
+	if err != nil {
+		return err
+	}
+	vs.cache[variable.Name] = stored
+
+	return vs.saveCache()
+}
+
+// encode prepares a variable for storage in set, encrypting secret values.
+// Callers hold vs.mu.
+func (vs *VariableService) encode(variable models.GlobalVariable, set map[string]storedVariable) (storedVariable, error) {
 	now := time.Now()
 	if variable.CreatedAt.IsZero() {
 		variable.CreatedAt = now
 	}
 	variable.UpdatedAt = now
 
-	vs.cache[variable.Name] = variable
-
-	return vs.saveCache()
+	stored := storedVariable{GlobalVariable: variable}
+	if variable.Secret {
+		existing, ok := set[variable.Name]
+		switch {
+		case variable.Value == secretMask && ok && existing.Secret:
+			// A masked value read back from the API keeps the current secret
+			stored.Value, stored.IV = existing.Value, existing.IV
+		case vs.key == nil:
+			return stored, ErrNoSecretKey
+		default:
+			ciphertext, iv, err := crypto.Encrypt(variable.Value, vs.key)
+			if err != nil {
+				return stored, fmt.Errorf("failed to encrypt secret: %w", err)
+			}
+			stored.Value, stored.IV = ciphertext, iv
+		}
+	}
+	return stored, nil
 }
 
 func (vs *VariableService) Delete(name string) error {
@@ -133,9 +180,36 @@ func (vs *VariableService) GetAll() map[string]string {
 	defer vs.mu.RUnlock()
 
 	result := make(map[string]string)
+	vs.decryptInto(result, vs.cache)
+	return result
+}
+
+// SecretNames returns the names of secret global variables
+func (vs *VariableService) SecretNames() []string {
+	vs.mu.RLock()
+	defer vs.mu.RUnlock()
+
+	names := make([]string, 0)
 	for name, v := range vs.cache {
-		result[name] = v.Value
+		if v.Secret {
+			names = append(names, name)
+		}
 	}
+	return names
+}
 
-	return result
+func (vs *VariableService) decrypt(v storedVariable) (string, error) {
+	if vs.key == nil {
+		return "", ErrNoSecretKey
+	}
+	return crypto.Decrypt(v.Value, v.IV, vs.key)
+}
+
+// masked returns the variable as shown to API clients
+func (v storedVariable) masked() models.GlobalVariable {
+	variable := v.GlobalVariable
+	if variable.Secret {
+		variable.Value = secretMask
+	}
+	return variable
 }
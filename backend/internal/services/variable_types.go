@@ -0,0 +1,90 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/devopstools/backend/internal/models"
+)
+
+// secretMask replaces secret values in responses, logs and stored runs
+const secretMask = "********"
+
+var ErrInvalidInputs = errors.New("invalid workflow inputs")
+
+// checkVariableValue checks a non-empty value against the type, options and
+// pattern of a variable. Secret values are never included in the error.
+func checkVariableValue(variable models.Variable, value string) error {
+	shown := strconv.Quote(value)
+	if variable.Type == "secret" {
+		shown = "value"
+	}
+
+	switch variable.Type {
+	case "", "string", "secret":
+	case "number":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("%s is not a number", shown)
+		}
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%s is not true or false", shown)
+		}
+	case "select":
+		if !containsString(variable.Options, value) {
+			return fmt.Errorf("%s is not one of %s", shown, strings.Join(variable.Options, ", "))
+		}
+	default:
+		return fmt.Errorf("unknown variable type %q", variable.Type)
+	}
+
+	if variable.Pattern != "" {
+		re, err := compileVariablePattern(variable.Pattern)
+		if err != nil {
+			return err
+		}
+		if !re.MatchString(value) {
+			return fmt.Errorf("%s does not match pattern %s", shown, variable.Pattern)
+		}
+	}
+	return nil
+}
+
+// compileVariablePattern anchors a pattern so it must match the whole value
+func compileVariablePattern(pattern string) (*regexp.Regexp, error) {
+	if _, err := regexp.Compile(pattern); err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+	return regexp.MustCompile("^(?:" + pattern + ")$"), nil
+}
+
+// inputProblems checks resolved run variables against the workflow's
+// declarations and lists what a run would reject
+func inputProblems(workflow *models.Workflow, variables map[string]string) []string {
+	var problems []string
+	for _, variable := range workflow.Variables {
+		value := variables[variable.Name]
+		if value == "" {
+			if variable.Required {
+				problems = append(problems, fmt.Sprintf("%s is required", variable.Name))
+			}
+			continue
+		}
+		if err := checkVariableValue(variable, value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", variable.Name, err))
+		}
+	}
+	return problems
+}
+
+// validateInputs rejects run variables that do not match their declarations
+func validateInputs(workflow *models.Workflow, variables map[string]string) error {
+	problems := inputProblems(workflow, variables)
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidInputs, strings.Join(problems, "; "))
+	}
+	return nil
+}
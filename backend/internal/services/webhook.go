@@ -0,0 +1,514 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devopstools/backend/internal/crypto"
+	"github.com/devopstools/backend/internal/logger"
+	"github.com/devopstools/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+const (
+	WebhookAuthToken = "token"
+	WebhookAuthHMAC  = "hmac"
+
+	defaultSignatureHeader = "X-Hub-Signature-256"
+
+	// maxWebhookDeliveries bounds the delivery history kept per webhook
+	maxWebhookDeliveries = 100
+
+	// webhookDedupWindow is how long a delivery ID is remembered
+	webhookDedupWindow = 24 * time.Hour
+
+	// webhookReplayWindow is how long a payload hash is remembered for
+	// deliveries without a delivery header. Alerting tools and CI resend
+	// identical payloads for real re-fires, so only quick resends of the
+	// same call count as duplicates.
+	webhookReplayWindow = time.Minute
+
+	// payloadHashPrefix marks delivery IDs derived from the payload
+	payloadHashPrefix = "sha256:"
+)
+
+var (
+	ErrWebhookNotFound     = errors.New("webhook not found")
+	ErrWebhookUnauthorized = errors.New("invalid webhook token or signature")
+	ErrWebhookDisabled     = errors.New("webhook is disabled")
+)
+
+// deliveryHeaders carry a unique delivery ID in common senders
+var deliveryHeaders = []string{"X-GitHub-Delivery", "X-Gitlab-Event-UUID", "X-Delivery-ID", "X-Request-ID"}
+
+var variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// WebhookRequest is an inbound delivery as received over HTTP
+type WebhookRequest struct {
+	Header func(name string) string
+	Query  func(name string) string
+	Body   []byte
+}
+
+// WebhookService starts workflows from authenticated inbound webhooks
+type WebhookService struct {
+	dataDir    string
+	store      *WorkflowStore
+	executor   *WorkflowExecutor
+	key        []byte // encrypts webhook secrets
+	mu         sync.RWMutex
+	webhooks   map[string]*storedWebhook
+	deliveries map[string][]*models.WebhookDelivery // webhook ID -> history, oldest first
+}
+
+// storedWebhook is a webhook as written to disk, with its secret encrypted
+type storedWebhook struct {
+	models.Webhook
+	IV string `json:"iv"`
+}
+
+// NewWebhookService creates the service and loads persisted webhooks
+func NewWebhookService(dataDir string, store *WorkflowStore, executor *WorkflowExecutor, key []byte) (*WebhookService, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	s := &WebhookService{
+		dataDir:    dataDir,
+		store:      store,
+		executor:   executor,
+		key:        key,
+		webhooks:   make(map[string]*storedWebhook),
+		deliveries: make(map[string][]*models.WebhookDelivery),
+	}
+
+	var webhooks []*storedWebhook
+	if err := readDataFile(filepath.Join(dataDir, "webhooks.json"), &webhooks); err != nil {
+		return nil, err
+	}
+	for _, webhook := range webhooks {
+		s.webhooks[webhook.ID] = webhook
+	}
+
+	var deliveries []*models.WebhookDelivery
+	if err := readDataFile(filepath.Join(dataDir, "webhook_deliveries.json"), &deliveries); err != nil {
+		return nil, err
+	}
+	for _, delivery := range deliveries {
+		if delivery.Status == "received" {
+			delivery.Status = "failed"
+			delivery.Error = "server restarted before the run started"
+		}
+		s.deliveries[delivery.WebhookID] = append(s.deliveries[delivery.WebhookID], delivery)
+	}
+
+	return s, nil
+}
+
+// save persists webhooks and delivery history. Callers hold s.mu.
+func (s *WebhookService) save() error {
+	webhooks := make([]*storedWebhook, 0, len(s.webhooks))
+	for _, webhook := range s.webhooks {
+		webhooks = append(webhooks, webhook)
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt) })
+
+	deliveries := make([]*models.WebhookDelivery, 0)
+	for _, history := range s.deliveries {
+		deliveries = append(deliveries, history...)
+	}
+
+	if err := writeDataFile(filepath.Join(s.dataDir, "webhooks.json"), webhooks); err != nil {
+		return err
+	}
+	return writeDataFile(filepath.Join(s.dataDir, "webhook_deliveries.json"), deliveries)
+}
+
+// validate normalises and checks a webhook definition
+func (s *WebhookService) validate(webhook *models.Webhook) error {
+	if webhook.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if _, err := s.store.Get(webhook.WorkflowID); err != nil {
+		return fmt.Errorf("target workflow %s: %w", webhook.WorkflowID, err)
+	}
+	if webhook.Environment != "" {
+		if _, err := s.executor.variableService.GetEnvironment(webhook.Environment); err != nil {
+			return err
+		}
+	}
+
+	if webhook.AuthType == "" {
+		webhook.AuthType = WebhookAuthToken
+	}
+	switch webhook.AuthType {
+	case WebhookAuthToken:
+		webhook.SignatureHeader = ""
+	case WebhookAuthHMAC:
+		if webhook.SignatureHeader == "" {
+			webhook.SignatureHeader = defaultSignatureHeader
+		}
+	default:
+		return fmt.Errorf("invalid auth_type: %s (expected token or hmac)", webhook.AuthType)
+	}
+
+	for name, path := range webhook.Mapping {
+		if !variableName.MatchString(name) {
+			return fmt.Errorf("mapping: invalid variable name %q", name)
+		}
+		if strings.TrimSpace(path) == "" {
+			return fmt.Errorf("mapping: %s has no payload path", name)
+		}
+	}
+	return nil
+}
+
+// List returns all webhooks with their secrets masked
+func (s *WebhookService) List() []models.Webhook {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	webhooks := make([]models.Webhook, 0, len(s.webhooks))
+	for _, webhook := range s.webhooks {
+		webhooks = append(webhooks, webhook.masked())
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt) })
+	return webhooks
+}
+
+// Get returns a webhook with its secret masked
+func (s *WebhookService) Get(id string) (*models.Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	webhook, ok := s.webhooks[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrWebhookNotFound, id)
+	}
+	masked := webhook.masked()
+	return &masked, nil
+}
+
+// Save creates or replaces a webhook. Without a secret, the current one is
+// kept or a new one generated; a generated secret is returned once, in the
+// response to this call.
+func (s *WebhookService) Save(webhook models.Webhook) (*models.Webhook, error) {
+	if err := s.validate(&webhook); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if webhook.ID == "" {
+		webhook.ID = uuid.New().String()
+	}
+	existing, ok := s.webhooks[webhook.ID]
+	if ok {
+		webhook.CreatedAt = existing.CreatedAt
+	} else {
+		webhook.CreatedAt = now
+	}
+	webhook.UpdatedAt = now
+
+	generated := false
+	stored := &storedWebhook{Webhook: webhook}
+	switch {
+	case (webhook.Secret == "" || webhook.Secret == secretMask) && ok:
+		stored.Secret, stored.IV = existing.Secret, existing.IV
+	default:
+		if webhook.Secret == "" || webhook.Secret == secretMask {
+			secret := make([]byte, 24)
+			if _, err := rand.Read(secret); err != nil {
+				return nil, err
+			}
+			webhook.Secret = hex.EncodeToString(secret)
+			generated = true
+		}
+		ciphertext, iv, err := crypto.Encrypt(webhook.Secret, s.key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt webhook secret: %w", err)
+		}
+		stored.Secret, stored.IV = ciphertext, iv
+	}
+
+	s.webhooks[webhook.ID] = stored
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+
+	result := stored.masked()
+	if generated {
+		result.Secret = webhook.Secret
+	}
+	return &result, nil
+}
+
+// Delete removes a webhook and its delivery history
+func (s *WebhookService) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.webhooks[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrWebhookNotFound, id)
+	}
+	delete(s.webhooks, id)
+	delete(s.deliveries, id)
+
+	return s.save()
+}
+
+// ListDeliveries returns the delivery history of a webhook, newest first
+func (s *WebhookService) ListDeliveries(id string) ([]models.WebhookDelivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.webhooks[id]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrWebhookNotFound, id)
+	}
+
+	history := s.deliveries[id]
+	deliveries := make([]models.WebhookDelivery, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		deliveries = append(deliveries, *history[i])
+	}
+	return deliveries, nil
+}
+
+// Deliver authenticates an inbound call and starts the workflow. A delivery
+// seen before within its dedup window is recorded as a duplicate and starts
+// nothing. Unauthenticated calls are rejected without being recorded.
+func (s *WebhookService) Deliver(id string, req WebhookRequest) (*models.WebhookDelivery, error) {
+	s.mu.RLock()
+	stored, ok := s.webhooks[id]
+	var webhook storedWebhook
+	if ok {
+		webhook = *stored
+	}
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrWebhookNotFound, id)
+	}
+
+	if err := s.authenticate(webhook, req); err != nil {
+		logger.Warn("Rejected webhook delivery", logger.WithFields(map[string]interface{}{
+			"webhook_id": id,
+			"error":      err.Error(),
+		}).Data)
+		return nil, err
+	}
+	if !webhook.Enabled {
+		return nil, ErrWebhookDisabled
+	}
+
+	delivery := &models.WebhookDelivery{
+		ID:         uuid.New().String(),
+		WebhookID:  id,
+		DeliveryID: deliveryID(webhook.Webhook, req),
+		ReceivedAt: time.Now(),
+	}
+
+	s.mu.Lock()
+	if original := s.findDelivery(id, delivery.DeliveryID, delivery.ReceivedAt); original != nil {
+		delivery.Status = "duplicate"
+		delivery.DuplicateOf = original.ID
+		delivery.RunID = original.RunID
+		s.recordDelivery(delivery)
+		err := s.save()
+		s.mu.Unlock()
+
+		result := *delivery
+		return &result, err
+	}
+	delivery.Status = "received"
+	s.recordDelivery(delivery)
+	s.mu.Unlock()
+
+	inputs, runErr := mapPayload(webhook.Mapping, req.Body)
+	var execution *models.WorkflowExecution
+	if runErr == nil {
+		for name, value := range webhook.Variables {
+			if _, mapped := inputs[name]; !mapped {
+				inputs[name] = value
+			}
+		}
+
+		outputChan := make(chan string, 100)
+		trigger := models.RunTrigger{Type: "webhook", ID: id, DeliveryID: delivery.DeliveryID}
+		execution, runErr = s.executor.ExecuteTriggered(context.Background(), webhook.WorkflowID, webhook.Environment, inputs, trigger, outputChan)
+		if runErr == nil {
+			// The channel closes once the workflow finishes
+			go func() {
+				for range outputChan {
+				}
+			}()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if runErr != nil {
+		delivery.Status = "failed"
+		delivery.Error = runErr.Error()
+	} else {
+		delivery.Status = "triggered"
+		delivery.RunID = execution.ID
+		delivery.Variables = deliveryInputs(inputs, execution.SecretVariables)
+	}
+	if err := s.save(); err != nil {
+		logger.Error("Failed to save webhook deliveries", err)
+	}
+
+	result := *delivery
+	return &result, runErr
+}
+
+// authenticate checks the token or HMAC signature of a delivery
+func (s *WebhookService) authenticate(webhook storedWebhook, req WebhookRequest) error {
+	secret, err := crypto.Decrypt(webhook.Secret, webhook.IV, s.key)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt webhook secret: %w", err)
+	}
+
+	switch webhook.AuthType {
+	case WebhookAuthHMAC:
+		signature := strings.TrimPrefix(req.Header(webhook.SignatureHeader), "sha256=")
+		given, err := hex.DecodeString(signature)
+		if err != nil || signature == "" {
+			return ErrWebhookUnauthorized
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(req.Body)
+		if !hmac.Equal(given, mac.Sum(nil)) {
+			return ErrWebhookUnauthorized
+		}
+	default:
+		token := req.Header("X-Webhook-Token")
+		if token == "" {
+			token = strings.TrimPrefix(req.Header("Authorization"), "Bearer ")
+		}
+		if token == "" {
+			token = req.Query("token")
+		}
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			return ErrWebhookUnauthorized
+		}
+	}
+	return nil
+}
+
+// findDelivery returns an earlier delivery with the same ID within the dedup
+// window, or the replay window for payload hashes. Callers hold s.mu.
+func (s *WebhookService) findDelivery(webhookID, deliveryID string, now time.Time) *models.WebhookDelivery {
+	window := webhookDedupWindow
+	if strings.HasPrefix(deliveryID, payloadHashPrefix) {
+		window = webhookReplayWindow
+	}
+
+	history := s.deliveries[webhookID]
+	for i := len(history) - 1; i >= 0; i-- {
+		delivery := history[i]
+		if now.Sub(delivery.ReceivedAt) > window {
+			break
+		}
+		if delivery.DeliveryID == deliveryID && delivery.Status != "duplicate" && delivery.Status != "failed" {
+			return delivery
+		}
+	}
+	return nil
+}
+
+// recordDelivery appends a delivery to the history, dropping the oldest. Callers hold s.mu.
+func (s *WebhookService) recordDelivery(delivery *models.WebhookDelivery) {
+	history := append(s.deliveries[delivery.WebhookID], delivery)
+	if len(history) > maxWebhookDeliveries {
+		history = history[len(history)-maxWebhookDeliveries:]
+	}
+	s.deliveries[delivery.WebhookID] = history
+}
+
+// deliveryID identifies a delivery for deduplication: the configured or a
+// well-known delivery header, otherwise a hash of the payload
+func deliveryID(webhook models.Webhook, req WebhookRequest) string {
+	headers := deliveryHeaders
+	if webhook.DeliveryHeader != "" {
+		headers = []string{webhook.DeliveryHeader}
+	}
+	for _, header := range headers {
+		if id := strings.TrimSpace(req.Header(header)); id != "" {
+			return id
+		}
+	}
+
+	sum := sha256.Sum256(req.Body)
+	return payloadHashPrefix + hex.EncodeToString(sum[:])
+}
+
+// deliveryInputs copies the inputs a delivery passed to its run, masking
+// secret ones
+func deliveryInputs(inputs map[string]string, secrets []string) map[string]string {
+	recorded := make(map[string]string, len(inputs))
+	for name, value := range inputs {
+		recorded[name] = value
+	}
+	for _, name := range secrets {
+		if _, ok := recorded[name]; ok {
+			recorded[name] = secretMask
+		}
+	}
+	return recorded
+}
+
+// masked returns the webhook as shown to API clients
+func (w storedWebhook) masked() models.Webhook {
+	webhook := w.Webhook
+	webhook.Secret = secretMask
+	return webhook
+}
+
+// mapPayload turns fields of a JSON payload into input variables. Paths
+// resolve like JSON step outputs; fields missing from the payload are left
+// unset so defaults and required checks apply.
+func mapPayload(mapping map[string]string, body []byte) (map[string]string, error) {
+	inputs := make(map[string]string)
+	if len(mapping) == 0 {
+		return inputs, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var payload interface{}
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, fmt.Errorf("payload is not valid JSON: %w", err)
+	}
+
+	for name, path := range mapping {
+		value, err := lookupJSONPath(payload, strings.TrimSpace(path))
+		if err != nil || value == nil {
+			continue
+		}
+		rendered, err := jsonValueString(value)
+		if err != nil {
+			return nil, err
+		}
+		inputs[name] = rendered
+	}
+	return inputs, nil
+}
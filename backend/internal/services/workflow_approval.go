@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/devopstools/backend/internal/models"
+)
+
+// SetApprovalService enables approval steps
+func (e *WorkflowExecutor) SetApprovalService(approvals *ApprovalService) {
+	e.approvals = approvals
+}
+
+// executeApprovalStep pauses the run until an approver decides. The step
+// output is the final status (approved, rejected, timed_out) so conditions
+// can branch on it.
+func (e *WorkflowExecutor) executeApprovalStep(ctx context.Context, step models.Step, variables map[string]string, outputChan chan<- string, execution *models.WorkflowExecution) (string, int, error) {
+	if e.approvals == nil {
+		return "", -1, fmt.Errorf("approval steps are not enabled")
+	}
+
+	config := step.Approval
+	if config == nil {
+		config = &models.ApprovalConfig{}
+	}
+
+	e.varsMu.RLock()
+	message := e.templateParser.SubstituteVariables(config.Message, variables)
+	e.varsMu.RUnlock()
+	message = e.maskSecrets(execution, message)
+	if message == "" {
+		message = fmt.Sprintf("Approve step %q to continue", stepLabel(step))
+	}
+
+	e.setStatus(execution, "waiting_approval")
+	defer e.setStatus(execution, "running")
+
+	e.logInfo(outputChan, execution, step.ID, fmt.Sprintf("Waiting for approval: %s", message))
+
+	request, err := e.approvals.Await(ctx, models.ApprovalRequest{
+		RunID:      execution.ID,
+		WorkflowID: execution.WorkflowID,
+		StepID:     step.ID,
+		StepName:   step.Name,
+		Message:    message,
+	}, config)
+	if err != nil {
+		return request.Status, -1, err
+	}
+
+	decision := approvalDecision(request)
+	if request.Status != "approved" {
+		e.logError(outputChan, execution, step.ID, decision)
+		return request.Status, 1, fmt.Errorf("%s", decision)
+	}
+
+	e.logInfo(outputChan, execution, step.ID, decision)
+	return request.Status, 0, nil
+}
+
+// approveEnvironment holds a run in a protected environment until an
+// approver lets it start
+func (e *WorkflowExecutor) approveEnvironment(ctx context.Context, env *models.Environment, outputChan chan<- string, execution *models.WorkflowExecution) error {
+	if e.approvals == nil {
+		return fmt.Errorf("environment %s requires approval, but approvals are not enabled", env.Name)
+	}
+
+	e.varsMu.RLock()
+	message := e.templateParser.SubstituteVariables(env.Approval.Message, execution.Variables)
+	e.varsMu.RUnlock()
+	message = e.maskSecrets(execution, message)
+	if message == "" {
+		message = fmt.Sprintf("Approve run of %q in protected environment %s", execution.WorkflowName, env.Name)
+	}
+
+	e.setStatus(execution, "waiting_approval")
+	defer e.setStatus(execution, "running")
+
+	e.logInfo(outputChan, execution, "", fmt.Sprintf("Waiting for approval: %s", message))
+
+	request, err := e.approvals.Await(ctx, models.ApprovalRequest{
+		RunID:      execution.ID,
+		WorkflowID: execution.WorkflowID,
+		StepName:   "environment " + env.Name,
+		Message:    message,
+	}, env.Approval)
+	if err != nil {
+		return err
+	}
+
+	decision := approvalDecision(request)
+	if request.Status != "approved" {
+		return fmt.Errorf("%s", decision)
+	}
+	e.logInfo(outputChan, execution, "", decision)
+	return nil
+}
+
+// approvalDecision describes who decided an approval request, or that it
+// timed out
+func approvalDecision(request models.ApprovalRequest) string {
+	switch {
+	case request.DecidedBy != "":
+		verb := "Rejected"
+		if request.Status == "approved" {
+			verb = "Approved"
+		}
+		decision := fmt.Sprintf("%s by %s", verb, request.DecidedBy)
+		if request.Comment != "" {
+			decision += fmt.Sprintf(": %s", request.Comment)
+		}
+		return decision
+	case request.Status == "approved":
+		return "Approved automatically after the timeout"
+	}
+	return "Approval timed out"
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/devopstools/backend/internal/models"
+)
+
+// keepArtifacts copies the files a step declares as artifacts out of the
+// run's workspace. Paths are rendered with the run's variables. Files that
+// are missing or cannot be kept are logged as warnings and do not fail the
+// step.
+func (e *WorkflowExecutor) keepArtifacts(step models.Step, variables map[string]string, outputChan chan<- string, execution *models.WorkflowExecution) {
+	if e.runStore == nil || execution.Workspace == "" {
+		return
+	}
+
+	e.varsMu.RLock()
+	patterns := make([]string, 0, len(step.Artifacts))
+	var unresolved []string
+	for _, artifact := range step.Artifacts {
+		rendered, err := e.templateParser.Render(artifact, variables, RenderOptions{})
+		if err != nil {
+			e.log(outputChan, execution, step.ID, 0, "warning", fmt.Sprintf("Artifact %s: %v", artifact, err))
+			continue
+		}
+		patterns = append(patterns, rendered.Text)
+		unresolved = append(unresolved, rendered.Unresolved...)
+	}
+	e.varsMu.RUnlock()
+	if len(unresolved) > 0 {
+		e.log(outputChan, execution, step.ID, 0, "warning", fmt.Sprintf("Artifacts not kept, unresolved placeholders: %s", strings.Join(unresolved, ", ")))
+		return
+	}
+
+	e.mu.Lock()
+	var used int64
+	for _, artifact := range execution.Artifacts {
+		if !artifact.Expired {
+			used += artifact.Size
+		}
+	}
+	e.mu.Unlock()
+
+	kept, problems := e.runStore.KeepArtifacts(execution.ID, step.ID, execution.Workspace, patterns, used)
+	for _, err := range problems {
+		e.log(outputChan, execution, step.ID, 0, "warning", fmt.Sprintf("Artifact %v", err))
+	}
+	if len(kept) == 0 {
+		return
+	}
+
+	e.mu.Lock()
+	for _, artifact := range kept {
+		replaced := false
+		for i := range execution.Artifacts {
+			if execution.Artifacts[i].Path == artifact.Path {
+				execution.Artifacts[i] = artifact
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			execution.Artifacts = append(execution.Artifacts, artifact)
+		}
+	}
+	e.mu.Unlock()
+
+	names := make([]string, 0, len(kept))
+	for _, artifact := range kept {
+		names = append(names, artifact.Name)
+	}
+	e.logInfo(outputChan, execution, step.ID, fmt.Sprintf("Kept artifacts: %s", strings.Join(names, ", ")))
+	e.persist(execution)
+}
@@ -0,0 +1,264 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/devopstools/backend/internal/models"
+)
+
+// DryRun renders every step of a workflow with the variables a real run would
+// get, without executing anything. Placeholders filled by step outputs are
+// reported as runtime variables rather than missing.
+func (e *WorkflowExecutor) DryRun(workflowID, environment string, inputs map[string]string) (*models.DryRunResult, error) {
+	workflow, err := e.store.Get(workflowID)
+	if err != nil {
+		return nil, err
+	}
+
+	variables, err := e.resolveVariables(workflow, environment, inputs)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := e.secretNames(workflow, environment, nil)
+	if err != nil {
+		return nil, err
+	}
+	warnings, err := e.EnvironmentWarnings(environment)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.DryRunResult{
+		WorkflowID:       workflow.ID,
+		WorkflowName:     workflow.Name,
+		Variables:        make(map[string]string, len(variables)),
+		InputErrors:      inputProblems(workflow, variables),
+		Environment:      environment,
+		Warnings:         warnings,
+		MissingVariables: []string{},
+		Steps:            []models.DryRunStep{},
+	}
+	for k, v := range variables {
+		result.Variables[k] = v
+	}
+	for _, name := range secrets {
+		if _, ok := result.Variables[name]; ok {
+			result.Variables[name] = secretMask
+		}
+	}
+
+	runtime := make(map[string]bool)
+	collectOutputNames(workflow.Steps, runtime)
+
+	graph := newStepGraph(workflow.Steps)
+	reachable := graph.reachable()
+	missing := make(map[string]bool)
+
+	var walk func(steps []models.Step, parentID string, reachableFn func(int) bool)
+	walk = func(steps []models.Step, parentID string, reachableFn func(int) bool) {
+		for i, step := range steps {
+			preview := e.dryRunStep(step, variables, result.Variables, secrets, runtime)
+			preview.ParentID = parentID
+			preview.Reachable = reachableFn(i)
+			if parentID == "" {
+				preview.Branches = graph.branches(i)
+			}
+			if preview.Reachable {
+				for _, name := range preview.MissingVariables {
+					missing[name] = true
+				}
+			}
+			result.Steps = append(result.Steps, preview)
+
+			if step.Type == "parallel" || IsLoopStep(step.Type) {
+				walk(step.Steps, step.ID, func(int) bool { return preview.Reachable })
+			}
+		}
+	}
+	walk(workflow.Steps, "", func(i int) bool { return reachable[i] })
+
+	for name := range missing {
+		result.MissingVariables = append(result.MissingVariables, name)
+	}
+	sort.Strings(result.MissingVariables)
+
+	return result, nil
+}
+
+// dryRunStep renders a single step. Commands are shell-escaped and reference
+// secrets as shell variables; other text uses the masked values.
+func (e *WorkflowExecutor) dryRunStep(step models.Step, variables, masked map[string]string, secrets []string, runtime map[string]bool) models.DryRunStep {
+	preview := models.DryRunStep{
+		StepID: step.ID,
+		Name:   step.Name,
+		Type:   step.Type,
+	}
+
+	var unresolved []string
+	switch step.Type {
+	case "command":
+		rendered, _, err := e.renderCommand(step, variables, secrets)
+		preview.Command = rendered.Text
+		preview.Highlights = rendered.Spans
+		unresolved = rendered.Unresolved
+		if err != nil {
+			preview.Message = err.Error()
+		}
+	case "workflow_ref":
+		preview.WorkflowRef = step.Content
+		if ref, err := e.store.Get(step.Content); err != nil {
+			preview.Message = fmt.Sprintf("referenced workflow not found: %s", step.Content)
+		} else {
+			preview.Message = fmt.Sprintf("runs workflow %s (%d steps) and waits for it", ref.Name, len(ref.Steps))
+		}
+		params, missing, err := e.renderParams(step, masked)
+		if err != nil {
+			preview.Message = err.Error()
+			break
+		}
+		preview.With = params
+		unresolved = missing
+	case "parallel":
+		mode := step.Mode
+		if mode == "" {
+			mode = ParallelModeWaitAll
+		}
+		preview.Message = fmt.Sprintf("runs %d steps in parallel (%s)", len(step.Steps), mode)
+	case "foreach", "matrix":
+		iterations, err := e.loopIterations(step, masked)
+		if err != nil {
+			preview.Message = err.Error()
+			break
+		}
+		labels := make([]string, len(iterations))
+		for i, it := range iterations {
+			labels[i] = it.label
+		}
+		preview.Message = fmt.Sprintf("runs %d steps for %d iterations: %s", len(step.Steps), len(iterations), strings.Join(labels, "; "))
+	case "approval":
+		if step.Approval != nil {
+			preview.Message = e.templateParser.SubstituteVariables(step.Approval.Message, masked)
+			unresolved = e.templateParser.ValidateCommand(step.Approval.Message, variables)
+		}
+		if preview.Message == "" {
+			preview.Message = "waits for approval"
+		}
+	default:
+		if !IsNativeStep(step.Type) {
+			preview.Message = fmt.Sprintf("unknown step type: %s", step.Type)
+			break
+		}
+		params, missing, err := e.renderParams(step, masked)
+		if err != nil {
+			preview.Message = err.Error()
+			break
+		}
+		preview.With = params
+		unresolved = missing
+		preview.Message = fmt.Sprintf("calls %s", step.Type)
+	}
+
+	for _, name := range unresolved {
+		if runtime[name] {
+			preview.RuntimeVariables = append(preview.RuntimeVariables, name)
+		} else {
+			preview.MissingVariables = append(preview.MissingVariables, name)
+		}
+	}
+
+	return preview
+}
+
+// collectOutputNames records the variables set while a run executes: step
+// outputs, including those of nested steps, and loop variables
+func collectOutputNames(steps []models.Step, names map[string]bool) {
+	for _, step := range steps {
+		for _, out := range step.Outputs {
+			names[out.Name] = true
+		}
+		switch step.Type {
+		case "foreach":
+			names[loopVariable(step)] = true
+			names[loopIndexVariable] = true
+		case "matrix":
+			for name := range step.Matrix {
+				names[name] = true
+			}
+			names[loopIndexVariable] = true
+		}
+		collectOutputNames(step.Steps, names)
+	}
+}
+
+// branches describes where control can go after top-level step i
+func (g *stepGraph) branches(i int) []models.DryRunBranch {
+	step := g.steps[i]
+	var branches []models.DryRunBranch
+
+	for _, cond := range step.Conditions {
+		branch := models.DryRunBranch{
+			When:   describeCondition(cond),
+			Action: cond.Action.Type,
+		}
+		switch cond.Action.Type {
+		case "jump_to", "execute_step":
+			branch.Target = cond.Action.Target
+		case "continue":
+			branch.Target = g.stepID(i + 1)
+		}
+		branches = append(branches, branch)
+	}
+
+	success := models.DryRunBranch{When: "success", Action: "continue"}
+	if step.OnSuccess != nil && step.OnSuccess.Type != "" {
+		success.Action = step.OnSuccess.Type
+	}
+	success.Target = g.stepID(g.follow(step.OnSuccess, i))
+	branches = append(branches, success)
+
+	failure := models.DryRunBranch{When: "failure", Action: "stop"}
+	if step.OnFailure != nil {
+		failure.Action = step.OnFailure.Type
+		failure.Target = g.stepID(g.follow(step.OnFailure, i))
+	}
+	branches = append(branches, failure)
+
+	return branches
+}
+
+// stepID returns the ID of step i, or "" for the end of the run
+func (g *stepGraph) stepID(i int) string {
+	if i >= g.end() {
+		return ""
+	}
+	return g.steps[i].ID
+}
+
+func describeCondition(cond models.Condition) string {
+	if cond.Type == "exit_code" {
+		return fmt.Sprintf("exit code %s", cond.Value)
+	}
+	return fmt.Sprintf("output %s %q", cond.Type, cond.Value)
+}
+
+// PreviewCommand renders a command template as a workflow step would, using
+// global variables and the given inputs, and marks unresolved placeholders
+func (e *WorkflowExecutor) PreviewCommand(template string, inputs map[string]string) models.CommandPreview {
+	variables := e.variableService.GetAll()
+	for k, v := range inputs {
+		variables[k] = v
+	}
+
+	rendered, _, err := e.renderCommand(models.Step{Content: template}, variables, e.variableService.SecretNames())
+	preview := models.CommandPreview{
+		Command:    rendered.Text,
+		Unresolved: rendered.Unresolved,
+		Highlights: rendered.Spans,
+	}
+	if err != nil {
+		preview.Error = err.Error()
+	}
+	return preview
+}
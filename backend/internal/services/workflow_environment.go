@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/devopstools/backend/internal/models"
+)
+
+// EnvironmentWarnings returns the warnings shown before running in an
+// environment, e.g. that it is protected and whether runs need approval
+func (e *WorkflowExecutor) EnvironmentWarnings(environment string) ([]string, error) {
+	if environment == "" {
+		return nil, nil
+	}
+	env, err := e.variableService.GetEnvironment(environment)
+	if err != nil {
+		return nil, err
+	}
+	if !env.Protected {
+		return nil, nil
+	}
+
+	warnings := []string{fmt.Sprintf("Running in protected environment %s", env.Name)}
+	if env.Approval != nil {
+		warnings = append(warnings, fmt.Sprintf("Runs in %s wait for approval before they start", env.Name))
+	}
+	return warnings, nil
+}
+
+// runWarnings returns the environment warnings of a new run. Sub-workflow
+// runs inherit the environment of their parent, which has already been let
+// in, so they get none.
+func (e *WorkflowExecutor) runWarnings(environment string, trigger *models.RunTrigger) ([]string, error) {
+	if trigger != nil && trigger.Type == "workflow" {
+		return nil, nil
+	}
+	return e.EnvironmentWarnings(environment)
+}
+
+// enterEnvironment logs the warnings of a run and, when its environment
+// requires approval, waits for an approver before any step runs
+func (e *WorkflowExecutor) enterEnvironment(ctx context.Context, outputChan chan<- string, execution *models.WorkflowExecution) error {
+	if execution.Environment == "" {
+		return nil
+	}
+	e.logInfo(outputChan, execution, "", fmt.Sprintf("Environment: %s", execution.Environment))
+	if len(execution.Warnings) == 0 {
+		return nil
+	}
+	for _, warning := range execution.Warnings {
+		e.log(outputChan, execution, "", 0, "warning", warning)
+	}
+
+	env, err := e.variableService.GetEnvironment(execution.Environment)
+	if err != nil {
+		return err
+	}
+	if env.Approval == nil {
+		return nil
+	}
+	return e.approveEnvironment(ctx, env, outputChan, execution)
+}
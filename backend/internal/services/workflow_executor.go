@@ -3,12 +3,17 @@ package services
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/devopstools/backend/internal/logger"
 	"github.com/devopstools/backend/internal/models"
 	"github.com/google/uuid"
 )
@@ -17,81 +22,170 @@ type WorkflowExecutor struct {
 	store           *WorkflowStore
 	variableService *VariableService
 	templateParser  *TemplateParser
+	runStore        *RunStore
+	approvals       *ApprovalService
+	locks           *LockManager
+	onFinish        func(models.WorkflowExecution)
+	services        StepServices                         // used by native step types
+	active          map[string]*models.WorkflowExecution // running executions by ID
+	mu              sync.Mutex                           // guards executions while they run
+	varsMu          sync.RWMutex                         // guards execution variables written by step outputs
+	persistMu       sync.Mutex                           // keeps saved snapshots in order
 }
 
+// maxStepOutput bounds the output kept in a step result; full output is in the logs
+const maxStepOutput = 64 * 1024
+
 func NewWorkflowExecutor(store *WorkflowStore, variableService *VariableService) *WorkflowExecutor {
 	return &WorkflowExecutor{
 		store:           store,
 		variableService: variableService,
 		templateParser:  NewTemplateParser(),
+		active:          make(map[string]*models.WorkflowExecution),
 	}
 }
 
-func (e *WorkflowExecutor) Execute(ctx context.Context, workflowID string, inputs map[string]string, outputChan chan<- string) (*models.WorkflowExecution, error) {
+// SetRunStore enables persisting workflow runs
+func (e *WorkflowExecutor) SetRunStore(store *RunStore) {
+	e.runStore = store
+}
+
+// SetFinishCallback sets the callback invoked with a run once it ends
+func (e *WorkflowExecutor) SetFinishCallback(callback func(models.WorkflowExecution)) {
+	e.onFinish = callback
+}
+
+// SetLockManager enables the locks workflows can declare
+func (e *WorkflowExecutor) SetLockManager(locks *LockManager) {
+	e.locks = locks
+}
+
+// Execute starts a run with the variables of an environment; an empty
+// environment uses the global variables
+func (e *WorkflowExecutor) Execute(ctx context.Context, workflowID, environment string, inputs map[string]string, outputChan chan<- string) (*models.WorkflowExecution, error) {
+	return e.execute(ctx, workflowID, environment, inputs, nil, nil, nil, outputChan)
+}
+
+// ExecuteTriggered starts a run and records what triggered it
+func (e *WorkflowExecutor) ExecuteTriggered(ctx context.Context, workflowID, environment string, inputs map[string]string, trigger models.RunTrigger, outputChan chan<- string) (*models.WorkflowExecution, error) {
+	return e.execute(ctx, workflowID, environment, inputs, nil, nil, &trigger, outputChan)
+}
+
+// execute starts a run. Secret names are inherited from a parent run so
+// secrets it passes down stay masked; callStack lists the workflows of the
+// parent runs.
+func (e *WorkflowExecutor) execute(ctx context.Context, workflowID, environment string, inputs map[string]string, secrets, callStack []string, trigger *models.RunTrigger, outputChan chan<- string) (*models.WorkflowExecution, error) {
 	workflow, err := e.store.Get(workflowID)
 	if err != nil {
 		return nil, err
 	}
 
-	execution := &models.WorkflowExecution{
-		ID:         uuid.New().String(),
-		WorkflowID: workflowID,
-		Status:     "running",
-		Variables:  make(map[string]string),
-		Logs:       []models.ExecutionLog{},
-		StartTime:  time.Now().Format(time.RFC3339),
+	variables, err := e.resolveVariables(workflow, environment, inputs)
+	if err != nil {
+		return nil, err
 	}
-
-	// Merge global variables first
-	globalVars := e.variableService.GetAll()
-	for k, v := range globalVars {
-		execution.Variables[k] = v
+	if err := validateInputs(workflow, variables); err != nil {
+		return nil, err
 	}
-
-	// Merge workflow default values
-	for _, v := range workflow.Variables {
-		if _, ok := execution.Variables[v.Name]; !ok && v.DefaultValue != "" {
-			execution.Variables[v.Name] = v.DefaultValue
-		}
+	secretNames, err := e.secretNames(workflow, environment, secrets)
+	if err != nil {
+		return nil, err
+	}
+	warnings, err := e.runWarnings(environment, trigger)
+	if err != nil {
+		return nil, err
 	}
 
-	// Override with user inputs
-	for k, v := range inputs {
-		execution.Variables[k] = v
+	execution := &models.WorkflowExecution{
+		ID:              uuid.New().String(),
+		WorkflowID:      workflowID,
+		WorkflowName:    workflow.Name,
+		WorkflowVersion: workflow.Version,
+		Trigger:         trigger,
+		CallStack:       callStack,
+		Environment:     environment,
+		Warnings:        warnings,
+		Status:          "running",
+		Variables:       variables,
+		SecretVariables: secretNames,
+		Steps:           []models.StepResult{},
+		Logs:            []models.ExecutionLog{},
+		StartTime:       time.Now().Format(time.RFC3339Nano),
 	}
 
+	e.start(ctx, workflow, execution, 0, outputChan)
+	return execution, nil
+}
+
+// start registers an execution and runs the workflow's steps in the
+// background, beginning with the step at index from
+func (e *WorkflowExecutor) start(ctx context.Context, workflow *models.Workflow, execution *models.WorkflowExecution, from int, outputChan chan<- string) {
+	e.mu.Lock()
+	e.active[execution.ID] = execution
+	e.mu.Unlock()
+	e.persist(execution)
+
 	go func() {
 		defer close(outputChan)
 
-		e.logInfo(outputChan, execution, "", fmt.Sprintf("Starting workflow: %s", workflow.Name))
+		if execution.ResumedFrom != "" {
+			e.logInfo(outputChan, execution, "", fmt.Sprintf("Resuming workflow: %s (run %s) from step %s", workflow.Name, execution.ResumedFrom, workflow.Steps[from].Name))
+		} else {
+			e.logInfo(outputChan, execution, "", fmt.Sprintf("Starting workflow: %s", workflow.Name))
+		}
+
+		if e.runStore != nil {
+			workspace, err := e.runStore.Workspace(execution.ID)
+			if err != nil {
+				e.logError(outputChan, execution, "", err.Error())
+				e.finish(execution, "failed", err)
+				return
+			}
+			e.mu.Lock()
+			execution.Workspace = workspace
+			e.mu.Unlock()
+			defer e.runStore.RemoveWorkspace(execution.ID)
+		}
+
+		if err := e.enterEnvironment(ctx, outputChan, execution); err != nil {
+			e.logError(outputChan, execution, "", err.Error())
+			if ctx.Err() != nil {
+				e.finish(execution, "cancelled", nil)
+			} else {
+				e.finish(execution, "failed", err)
+			}
+			return
+		}
 
-		currentStepIndex := 0
+		if len(workflow.Locks) > 0 {
+			release, err := e.acquireLocks(ctx, workflow, execution, outputChan)
+			if err != nil {
+				e.logError(outputChan, execution, "", err.Error())
+				if ctx.Err() != nil {
+					e.finish(execution, "cancelled", nil)
+				} else {
+					e.finish(execution, "failed", err)
+				}
+				return
+			}
+			defer release()
+		}
+
+		currentStepIndex := from
 		for currentStepIndex < len(workflow.Steps) {
 			step := workflow.Steps[currentStepIndex]
 
 			select {
 			case <-ctx.Done():
 				e.logInfo(outputChan, execution, step.ID, "Execution cancelled")
-				execution.Status = "cancelled"
-				execution.EndTime = time.Now().Format(time.RFC3339)
+				e.finish(execution, "cancelled", nil)
 				return
 			default:
 			}
 
 			e.logInfo(outputChan, execution, step.ID, fmt.Sprintf("Step %d/%d: %s", currentStepIndex+1, len(workflow.Steps), step.Name))
 
-			var stepErr error
-			var output string
-			var exitCode int
-
-			switch step.Type {
-			case "command":
-				output, exitCode, stepErr = e.executeCommandStep(ctx, step, execution.Variables, outputChan, execution)
-			case "workflow_ref":
-				stepErr = e.executeWorkflowStep(ctx, step, execution.Variables, outputChan, execution)
-			default:
-				stepErr = fmt.Errorf("unknown step type: %s", step.Type)
-			}
+			output, exitCode, stepErr := e.runStep(ctx, step, "", execution.Variables, outputChan, execution)
 
 			// Check conditions
 			if len(step.Conditions) > 0 {
@@ -101,17 +195,16 @@ func (e *WorkflowExecutor) Execute(ctx context.Context, workflowID string, input
 
 					switch action.Type {
 					case "stop":
-						execution.Status = "completed"
-						execution.EndTime = time.Now().Format(time.RFC3339)
 						e.logInfo(outputChan, execution, "", "Workflow stopped by condition")
+						e.finish(execution, "completed", nil)
 						return
 					case "jump_to":
 						// Find target step
 						for i, s := range workflow.Steps {
 							if s.ID == action.Target {
-								currentStepIndex = i
+								currentStepIndex = i - 1 // -1 because loop will increment
 								e.logInfo(outputChan, execution, step.ID, fmt.Sprintf("Jumping to step: %s", s.Name))
-								continue
+								break
 							}
 						}
 					case "execute_step":
@@ -119,7 +212,7 @@ func (e *WorkflowExecutor) Execute(ctx context.Context, workflowID string, input
 						for _, s := range workflow.Steps {
 							if s.ID == action.Target {
 								e.logInfo(outputChan, execution, step.ID, fmt.Sprintf("Executing step: %s", s.Name))
-								e.executeCommandStep(ctx, s, execution.Variables, outputChan, execution)
+								e.runStep(ctx, s, "", execution.Variables, outputChan, execution)
 								break
 							}
 						}
@@ -131,11 +224,13 @@ func (e *WorkflowExecutor) Execute(ctx context.Context, workflowID string, input
 			if stepErr != nil {
 				e.logError(outputChan, execution, step.ID, fmt.Sprintf("Step failed: %v", stepErr))
 
-				if step.OnFailure != nil {
+				switch {
+				case step.OnFailure != nil:
 					e.handleStepAction(step.OnFailure, workflow.Steps, &currentStepIndex, outputChan, execution)
-				} else {
-					execution.Status = "failed"
-					execution.EndTime = time.Now().Format(time.RFC3339)
+				case ignoreFailure(ctx, step, stepErr):
+					e.log(outputChan, execution, step.ID, 0, "warning", "Continuing: continue_on_error is set")
+				default:
+					e.finish(execution, "failed", stepErr)
 					return
 				}
 			} else {
@@ -147,61 +242,444 @@ func (e *WorkflowExecutor) Execute(ctx context.Context, workflowID string, input
 			currentStepIndex++
 		}
 
-		execution.Status = "completed"
-		execution.EndTime = time.Now().Format(time.RFC3339)
 		e.logInfo(outputChan, execution, "", "Workflow completed successfully")
+		e.finish(execution, "completed", nil)
 	}()
+}
 
-	return execution, nil
+// acquireLocks takes the locks a workflow declares for a run. Lock names are
+// rendered with the run's variables. Locks held by the runs that called this
+// one as a sub-workflow are shared with it.
+func (e *WorkflowExecutor) acquireLocks(ctx context.Context, workflow *models.Workflow, execution *models.WorkflowExecution, outputChan chan<- string) (func(), error) {
+	if e.locks == nil {
+		return nil, fmt.Errorf("locks are not enabled")
+	}
+	if err := ValidateLockPolicy(workflow.LockPolicy); err != nil {
+		return nil, err
+	}
+
+	e.varsMu.RLock()
+	names := make([]string, 0, len(workflow.Locks))
+	var unresolved []string
+	for _, lock := range workflow.Locks {
+		rendered, err := e.templateParser.Render(lock, execution.Variables, RenderOptions{})
+		if err != nil {
+			e.varsMu.RUnlock()
+			return nil, fmt.Errorf("lock %s: %w", lock, err)
+		}
+		names = append(names, rendered.Text)
+		unresolved = append(unresolved, rendered.Unresolved...)
+	}
+	e.varsMu.RUnlock()
+	if len(unresolved) > 0 {
+		return nil, fmt.Errorf("unresolved placeholders in locks: %s", strings.Join(unresolved, ", "))
+	}
+	names = NormalizeLockNames(names)
+
+	e.mu.Lock()
+	execution.Locks = names
+	var callers []string
+	for trigger := execution.Trigger; trigger != nil && trigger.Type == "workflow"; {
+		callers = append(callers, trigger.ID)
+		parent, ok := e.active[trigger.ID]
+		if !ok {
+			break
+		}
+		trigger = parent.Trigger
+	}
+	e.mu.Unlock()
+
+	holder := models.LockHolder{Type: "workflow_run", ID: execution.ID, Label: workflow.Name}
+	release, err := e.locks.Acquire(ctx, names, holder, LockPolicyFail, callers)
+	if err != nil {
+		if workflow.LockPolicy == LockPolicyFail {
+			return nil, err
+		}
+		e.logInfo(outputChan, execution, "", fmt.Sprintf("Waiting: %v", err))
+		e.setStatus(execution, "waiting_lock")
+		if release, err = e.locks.Acquire(ctx, names, holder, LockPolicyWait, callers); err != nil {
+			return nil, err
+		}
+		e.setStatus(execution, "running")
+	}
+
+	e.logInfo(outputChan, execution, "", fmt.Sprintf("Acquired locks: %s", strings.Join(names, ", ")))
+	return release, nil
+}
+
+// resolveVariables merges the global or environment variables, workflow
+// defaults and user inputs
+func (e *WorkflowExecutor) resolveVariables(workflow *models.Workflow, environment string, inputs map[string]string) (map[string]string, error) {
+	variables := make(map[string]string)
+
+	// Merge global variables first
+	globalVars, err := e.variableService.GetAllIn(environment)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range globalVars {
+		variables[k] = v
+	}
+
+	// Merge workflow default values
+	for _, v := range workflow.Variables {
+		if _, ok := variables[v.Name]; !ok && v.DefaultValue != "" {
+			variables[v.Name] = v.DefaultValue
+		}
+	}
+
+	// Override with user inputs
+	for k, v := range inputs {
+		variables[k] = v
+	}
+
+	return variables, nil
+}
+
+// secretNames lists the secret variables of a run: secret global and
+// environment variables, variables declared as secret and secrets inherited
+// from a parent run
+func (e *WorkflowExecutor) secretNames(workflow *models.Workflow, environment string, inherited []string) ([]string, error) {
+	global, err := e.variableService.SecretNamesIn(environment)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, name := range global {
+		seen[name] = true
+	}
+	for _, v := range workflow.Variables {
+		if v.Type == "secret" {
+			seen[v.Name] = true
+		}
+	}
+	for _, name := range inherited {
+		seen[name] = true
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// maskSecrets replaces the values of the run's secret variables in text
+func (e *WorkflowExecutor) maskSecrets(execution *models.WorkflowExecution, text string) string {
+	if execution == nil || len(execution.SecretVariables) == 0 || text == "" {
+		return text
+	}
+
+	e.varsMu.RLock()
+	defer e.varsMu.RUnlock()
+	for _, name := range execution.SecretVariables {
+		if value := execution.Variables[name]; value != "" {
+			text = strings.ReplaceAll(text, value, secretMask)
+		}
+	}
+	return text
+}
+
+// runStep executes a single step according to its type, captures its
+// declared outputs into the variables and records its result. parentID names
+// the enclosing parallel step or loop iteration, if any.
+func (e *WorkflowExecutor) runStep(ctx context.Context, step models.Step, parentID string, variables map[string]string, outputChan chan<- string, execution *models.WorkflowExecution) (output string, exitCode int, err error) {
+	index := e.beginStep(execution, step, parentID)
+	defer func() {
+		e.endStep(ctx, execution, index, output, exitCode, err, ignoreFailure(ctx, step, err))
+	}()
+
+	if err = validateStepPolicy(step); err != nil {
+		return "", -1, err
+	}
+
+	var stdout string
+	if step.Type == "command" {
+		output, stdout, exitCode, err = e.executeCommandStep(ctx, step, index, variables, outputChan, execution)
+	} else {
+		output, stdout, exitCode, err = e.runAttempts(ctx, step, index, outputChan, execution, func(ctx context.Context, attempt int) (string, string, int, error) {
+			return e.runTimed(ctx, step, func(ctx context.Context) (string, string, int, error) {
+				return e.dispatchStep(ctx, step, variables, outputChan, execution)
+			})
+		})
+	}
+
+	if len(step.Outputs) > 0 && ctx.Err() == nil {
+		if captureErr := e.captureOutputs(step, stdout, exitCode, err == nil, variables, outputChan, execution); captureErr != nil && err == nil {
+			err = captureErr
+		}
+	}
+	if len(step.Artifacts) > 0 && ctx.Err() == nil {
+		e.keepArtifacts(step, variables, outputChan, execution)
+	}
+
+	return output, exitCode, err
+}
+
+// dispatchStep runs one attempt of a step other than a command. stdout is
+// what outputs are captured from.
+func (e *WorkflowExecutor) dispatchStep(ctx context.Context, step models.Step, variables map[string]string, outputChan chan<- string, execution *models.WorkflowExecution) (output string, stdout string, exitCode int, err error) {
+	switch step.Type {
+	case "workflow_ref":
+		output, exitCode, err = e.executeWorkflowStep(ctx, step, variables, outputChan, execution)
+	case "parallel":
+		output, exitCode, err = e.executeParallelStep(ctx, step, variables, outputChan, execution)
+	case "foreach", "matrix":
+		output, exitCode, err = e.executeLoopStep(ctx, step, variables, outputChan, execution)
+	case "approval":
+		output, exitCode, err = e.executeApprovalStep(ctx, step, variables, outputChan, execution)
+	case "http", "tcp_check", "dns", "tls_check", "terraform", "argocd_sync", "aws_list":
+		output, exitCode, err = e.executeNativeStep(ctx, step, variables, outputChan, execution)
+	default:
+		return "", "", 0, fmt.Errorf("unknown step type: %s", step.Type)
+	}
+	return output, output, exitCode, err
+}
+
+// runAttempts runs a step under its retry policy, logging every attempt and
+// the final outcome. Steps with a retry or timeout policy also record their
+// attempts in the step result.
+func (e *WorkflowExecutor) runAttempts(ctx context.Context, step models.Step, index int, outputChan chan<- string, execution *models.WorkflowExecution, attempt func(ctx context.Context, n int) (string, string, int, error)) (output string, stdout string, exitCode int, err error) {
+	total := 1
+	if stepRetries(step.Type) {
+		total = maxAttempts(step.Retry)
+	}
+
+	n := 1
+	for ; ; n++ {
+		if total > 1 {
+			e.log(outputChan, execution, step.ID, n, "info", fmt.Sprintf("Attempt %d/%d", n, total))
+		}
+
+		started := time.Now()
+		output, stdout, exitCode, err = attempt(ctx, n)
+		e.recordAttempt(execution, index, step, n, started, output, exitCode, err)
+		if err == nil || ctx.Err() != nil || n >= total || !shouldRetry(step.Retry, n, exitCode) {
+			break
+		}
+
+		delay := retryDelay(step.Retry, n)
+		e.log(outputChan, execution, step.ID, n, "warning", fmt.Sprintf("Attempt %d failed (exit code %d): %v, retrying in %s", n, exitCode, err, delay))
+		if !waitForRetry(ctx, delay) {
+			break
+		}
+	}
+
+	switch {
+	case total == 1:
+	case err == nil:
+		e.log(outputChan, execution, step.ID, n, "info", fmt.Sprintf("Succeeded on attempt %d/%d", n, total))
+	default:
+		e.log(outputChan, execution, step.ID, n, "error", fmt.Sprintf("Failed after %d of %d attempts", n, total))
+	}
+	return output, stdout, exitCode, err
+}
+
+// runTimed runs one attempt of a step other than a command under the step
+// timeout
+func (e *WorkflowExecutor) runTimed(ctx context.Context, step models.Step, run func(ctx context.Context) (string, string, int, error)) (string, string, int, error) {
+	if step.Timeout == nil {
+		return run(ctx)
+	}
+
+	attemptCtx, cancel := context.WithTimeout(ctx, time.Duration(step.Timeout.DurationMs)*time.Millisecond)
+	defer cancel()
+
+	// Every step type returns once its context ends, native calls included,
+	// so the attempt never writes to the run after it is over
+	output, stdout, exitCode, err := run(attemptCtx)
+	if err != nil && attemptCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return output, stdout, -1, fmt.Errorf("%w after %dms", ErrStepTimeout, step.Timeout.DurationMs)
+	}
+	return output, stdout, exitCode, err
+}
+
+// recordAttempt adds an attempt to the step result of steps with a retry or
+// timeout policy
+func (e *WorkflowExecutor) recordAttempt(execution *models.WorkflowExecution, index int, step models.Step, n int, started time.Time, output string, exitCode int, err error) {
+	if step.Retry == nil && step.Timeout == nil {
+		return
+	}
+
+	now := time.Now()
+	attempt := models.CommandAttempt{
+		Number:    n,
+		Status:    "success",
+		ExitCode:  exitCode,
+		StartedAt: started,
+		EndedAt:   &now,
+		Duration:  now.Sub(started).Milliseconds(),
+	}
+	if err != nil {
+		attempt.Status = "failed"
+		attempt.Error = e.maskSecrets(execution, err.Error())
+		if errors.Is(err, ErrStepTimeout) {
+			attempt.Status = "timeout"
+			attempt.TimedOut = true
+		}
+	}
+
+	e.mu.Lock()
+	execution.Steps[index].Attempts = append(execution.Steps[index].Attempts, attempt)
+	e.mu.Unlock()
 }
 
-func (e *WorkflowExecutor) executeCommandStep(ctx context.Context, step models.Step, variables map[string]string, outputChan chan<- string, execution *models.WorkflowExecution) (string, int, error) {
-	// Substitute variables
-	command := e.templateParser.SubstituteVariables(step.Content, variables)
+func (e *WorkflowExecutor) executeCommandStep(ctx context.Context, step models.Step, index int, variables map[string]string, outputChan chan<- string, execution *models.WorkflowExecution) (string, string, int, error) {
+	rendered, env, err := e.renderCommand(step, variables, execution.SecretVariables)
+	if err != nil {
+		return "", "", -1, err
+	}
+	command := rendered.Text
+
+	e.logInfo(outputChan, execution, step.ID, fmt.Sprintf("$ %s", command))
+
+	return e.runAttempts(ctx, step, index, outputChan, execution, func(ctx context.Context, attempt int) (string, string, int, error) {
+		return e.runShellCommand(ctx, command, env, step, attempt, outputChan, execution)
+	})
+}
+
+// renderCommand renders a command for sh: values are shell-escaped and step
+// variable mappings applied. Secrets are not put on the command line: their
+// placeholders become shell references and the values are returned as
+// environment variables.
+func (e *WorkflowExecutor) renderCommand(step models.Step, variables map[string]string, secrets []string) (*RenderResult, []string, error) {
+	e.varsMu.RLock()
+	defer e.varsMu.RUnlock()
+
+	secret := make(map[string]string) // placeholder -> secret variable
+	for _, name := range secrets {
+		secret[name] = name
+	}
+
+	values := make(map[string]string, len(variables)+len(step.Variables))
+	for k, v := range variables {
+		values[k] = v
+	}
 
 	// Handle step-specific variable mappings
 	for key, valName := range step.Variables {
 		if val, ok := variables[valName]; ok {
-			command = strings.ReplaceAll(command, fmt.Sprintf("{%s}", key), val)
+			values[key] = val
+			if name, ok := secret[valName]; ok {
+				secret[key] = name
+			} else {
+				delete(secret, key)
+			}
 		}
 	}
 
-	e.logInfo(outputChan, execution, step.ID, fmt.Sprintf("$ %s", command))
+	var env []string
+	envNames := make(map[string]string) // value -> environment variable
+	secretRef := func(key, value string) (string, bool) {
+		name, ok := secret[key]
+		if !ok {
+			return "", false
+		}
+		envName, ok := envNames[value]
+		if !ok {
+			// Filters and defaults can yield other values than the variable
+			envName = name
+			if value != variables[name] {
+				envName = fmt.Sprintf("%s_%d", name, len(envNames)+1)
+			}
+			envNames[value] = envName
+			env = append(env, envName+"="+value)
+		}
+		return `"${` + envName + `}"`, true
+	}
+
+	result, err := e.templateParser.Render(step.Content, values, RenderOptions{Escape: true, Secret: secretRef})
+	if err != nil {
+		return result, nil, err
+	}
+
+	// Mapping keys that are not placeholder names are replaced literally
+	for key := range step.Variables {
+		placeholder := "{" + key + "}"
+		if val, ok := values[key]; ok && strings.Contains(result.Text, placeholder) {
+			if ref, ok := secretRef(key, val); ok {
+				val = ref
+			} else {
+				val = ShellEscape(val)
+			}
+			result.Text = strings.ReplaceAll(result.Text, placeholder, val)
+		}
+	}
+	return result, env, nil
+}
+
+// runShellCommand runs a rendered command once, honouring the step timeout.
+// It returns the combined output and stdout on its own.
+func (e *WorkflowExecutor) runShellCommand(ctx context.Context, command string, env []string, step models.Step, attempt int, outputChan chan<- string, execution *models.WorkflowExecution) (string, string, int, error) {
+	attemptCtx, cancel := withAttemptTimeout(ctx, step.Timeout, func() {
+		e.log(outputChan, execution, step.ID, attempt, "warning", fmt.Sprintf("Step exceeded timeout of %dms, letting it continue", step.Timeout.DurationMs))
+	})
+	defer cancel()
 
 	// Execute
-	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd := exec.CommandContext(attemptCtx, "sh", "-c", command)
+	if execution.Workspace != "" {
+		cmd.Dir = execution.Workspace
+		env = append([]string{"RUN_WORKSPACE=" + execution.Workspace}, env...)
+	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 
 	stdout, _ := cmd.StdoutPipe()
 	stderr, _ := cmd.StderrPipe()
 
 	if err := cmd.Start(); err != nil {
-		return "", -1, err
+		return "", "", -1, err
 	}
 
-	var output strings.Builder
+	// Killing sh leaves its children holding the pipes open, so stop reading
+	// once the attempt is cancelled instead of waiting for them to exit
+	stopReading := context.AfterFunc(attemptCtx, func() {
+		stdout.Close()
+		stderr.Close()
+	})
+	defer stopReading()
+
+	var output, stdoutOutput strings.Builder
+	var outputMu sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(2)
 
 	// Stream output
 	go func() {
+		defer wg.Done()
 		scanner := bufio.NewScanner(stdout)
 		for scanner.Scan() {
 			line := scanner.Text()
+			outputMu.Lock()
 			output.WriteString(line + "\n")
-			e.logInfo(outputChan, execution, step.ID, line)
+			stdoutOutput.WriteString(line + "\n")
+			outputMu.Unlock()
+			e.log(outputChan, execution, step.ID, attempt, "info", line)
 		}
 	}()
 
 	go func() {
+		defer wg.Done()
 		scanner := bufio.NewScanner(stderr)
 		for scanner.Scan() {
 			line := scanner.Text()
+			outputMu.Lock()
 			output.WriteString(line + "\n")
-			e.logError(outputChan, execution, step.ID, line)
+			outputMu.Unlock()
+			e.log(outputChan, execution, step.ID, attempt, "error", line)
 		}
 	}()
 
+	wg.Wait()
 	err := cmd.Wait()
 	exitCode := 0
 	if err != nil {
+		if attemptCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			return output.String(), stdoutOutput.String(), -1, fmt.Errorf("%w after %dms", ErrStepTimeout, step.Timeout.DurationMs)
+		}
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			exitCode = exitErr.ExitCode()
 		} else {
@@ -209,25 +687,7 @@ func (e *WorkflowExecutor) executeCommandStep(ctx context.Context, step models.S
 		}
 	}
 
-	return output.String(), exitCode, err
-}
-
-func (e *WorkflowExecutor) executeWorkflowStep(ctx context.Context, step models.Step, variables map[string]string, outputChan chan<- string, execution *models.WorkflowExecution) error {
-	e.logInfo(outputChan, execution, step.ID, fmt.Sprintf("Executing sub-workflow: %s", step.Content))
-
-	// Create sub-execution channel
-	subOutputChan := make(chan string, 100)
-
-	// Forward sub-workflow logs
-	go func() {
-		for msg := range subOutputChan {
-			e.logInfo(outputChan, execution, step.ID, "  "+msg)
-		}
-	}()
-
-	// Execute sub-workflow
-	_, err := e.Execute(ctx, step.Content, variables, subOutputChan)
-	return err
+	return output.String(), stdoutOutput.String(), exitCode, err
 }
 
 func (e *WorkflowExecutor) evaluateConditions(conditions []models.Condition, output string, exitCode int) *models.StepAction {
@@ -276,25 +736,189 @@ func (e *WorkflowExecutor) handleStepAction(action *models.StepAction, steps []m
 }
 
 func (e *WorkflowExecutor) logInfo(outputChan chan<- string, execution *models.WorkflowExecution, stepID, message string) {
-	outputChan <- message
-	if execution != nil {
-		execution.Logs = append(execution.Logs, models.ExecutionLog{
-			Timestamp: time.Now().Format(time.RFC3339),
-			StepID:    stepID,
-			Level:     "info",
-			Message:   message,
-		})
-	}
+	e.log(outputChan, execution, stepID, 0, "info", message)
 }
 
 func (e *WorkflowExecutor) logError(outputChan chan<- string, execution *models.WorkflowExecution, stepID, message string) {
+	e.log(outputChan, execution, stepID, 0, "error", message)
+}
+
+func (e *WorkflowExecutor) log(outputChan chan<- string, execution *models.WorkflowExecution, stepID string, attempt int, level, message string) {
+	message = e.maskSecrets(execution, message)
 	outputChan <- message
 	if execution != nil {
+		e.mu.Lock()
+		defer e.mu.Unlock()
 		execution.Logs = append(execution.Logs, models.ExecutionLog{
 			Timestamp: time.Now().Format(time.RFC3339),
 			StepID:    stepID,
-			Level:     "error",
+			Attempt:   attempt,
+			Level:     level,
 			Message:   message,
 		})
 	}
 }
+
+// Snapshot returns a consistent copy of an execution that may still be running
+func (e *WorkflowExecutor) Snapshot(execution *models.WorkflowExecution) models.WorkflowExecution {
+	e.mu.Lock()
+	snapshot := *execution
+	snapshot.Steps = append([]models.StepResult(nil), execution.Steps...)
+	snapshot.Logs = append([]models.ExecutionLog(nil), execution.Logs...)
+	snapshot.Artifacts = append([]models.RunArtifact(nil), execution.Artifacts...)
+	e.mu.Unlock()
+
+	e.varsMu.RLock()
+	snapshot.Variables = make(map[string]string, len(execution.Variables))
+	for k, v := range execution.Variables {
+		snapshot.Variables[k] = v
+	}
+	e.varsMu.RUnlock()
+	for _, name := range execution.SecretVariables {
+		if _, ok := snapshot.Variables[name]; ok {
+			snapshot.Variables[name] = secretMask
+		}
+	}
+
+	return snapshot
+}
+
+// GetActive returns a snapshot of a running execution
+func (e *WorkflowExecutor) GetActive(id string) (*models.WorkflowExecution, bool) {
+	e.mu.Lock()
+	execution, ok := e.active[id]
+	e.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	snapshot := e.Snapshot(execution)
+	return &snapshot, true
+}
+
+// persist saves the current state of an execution to the run store
+func (e *WorkflowExecutor) persist(execution *models.WorkflowExecution) {
+	if e.runStore == nil {
+		return
+	}
+
+	e.persistMu.Lock()
+	defer e.persistMu.Unlock()
+
+	snapshot := e.Snapshot(execution)
+	if err := e.runStore.Save(&snapshot); err != nil {
+		logger.Error("Failed to save workflow run", err, logger.WithFields(map[string]interface{}{
+			"run_id": execution.ID,
+		}).Data)
+	}
+}
+
+// setStatus updates the status of a running execution
+func (e *WorkflowExecutor) setStatus(execution *models.WorkflowExecution, status string) {
+	e.mu.Lock()
+	execution.Status = status
+	e.mu.Unlock()
+
+	e.persist(execution)
+}
+
+// finish records the final status of an execution and applies run retention
+func (e *WorkflowExecutor) finish(execution *models.WorkflowExecution, status string, err error) {
+	now := time.Now()
+	var message string
+	if err != nil {
+		message = e.maskSecrets(execution, err.Error())
+	}
+
+	e.mu.Lock()
+	delete(e.active, execution.ID)
+	execution.Status = status
+	if err != nil {
+		execution.Error = message
+	}
+	execution.EndTime = now.Format(time.RFC3339Nano)
+	if started, parseErr := time.Parse(time.RFC3339Nano, execution.StartTime); parseErr == nil {
+		execution.DurationMs = now.Sub(started).Milliseconds()
+	}
+	e.mu.Unlock()
+
+	e.persist(execution)
+	if e.onFinish != nil {
+		e.onFinish(e.Snapshot(execution))
+	}
+
+	if e.runStore != nil {
+		if _, err := e.runStore.Prune(); err != nil {
+			logger.Error("Failed to prune workflow runs", err)
+		}
+	}
+}
+
+// beginStep appends a running step result and returns its index
+func (e *WorkflowExecutor) beginStep(execution *models.WorkflowExecution, step models.Step, parentID string) int {
+	e.mu.Lock()
+	execution.Steps = append(execution.Steps, models.StepResult{
+		StepID:    step.ID,
+		Name:      step.Name,
+		Type:      step.Type,
+		ParentID:  parentID,
+		Status:    "running",
+		StartTime: time.Now().Format(time.RFC3339Nano),
+	})
+	index := len(execution.Steps) - 1
+	e.mu.Unlock()
+
+	e.persist(execution)
+	return index
+}
+
+// endStep completes a step result started by beginStep. ignored marks a
+// failure that continue_on_error let pass.
+func (e *WorkflowExecutor) endStep(ctx context.Context, execution *models.WorkflowExecution, index int, output string, exitCode int, err error, ignored bool) {
+	now := time.Now()
+	output = e.maskSecrets(execution, output)
+	var message string
+	if err != nil {
+		message = e.maskSecrets(execution, err.Error())
+	}
+
+	e.mu.Lock()
+	result := &execution.Steps[index]
+	switch {
+	case err == nil:
+		result.Status = "success"
+	case ctx.Err() != nil:
+		result.Status = "cancelled"
+	default:
+		result.Status = "failed"
+	}
+	if err != nil {
+		result.Error = message
+		result.ErrorIgnored = ignored
+	}
+	if len(output) > maxStepOutput {
+		output = output[len(output)-maxStepOutput:]
+	}
+	result.Output = output
+	result.ExitCode = exitCode
+	result.EndTime = now.Format(time.RFC3339Nano)
+	if started, parseErr := time.Parse(time.RFC3339Nano, result.StartTime); parseErr == nil {
+		result.DurationMs = now.Sub(started).Milliseconds()
+	}
+	e.mu.Unlock()
+
+	e.persist(execution)
+}
+
+// skipStep records a step that never started
+func (e *WorkflowExecutor) skipStep(execution *models.WorkflowExecution, step models.Step, parentID string) {
+	e.mu.Lock()
+	execution.Steps = append(execution.Steps, models.StepResult{
+		StepID:   step.ID,
+		Name:     step.Name,
+		Type:     step.Type,
+		ParentID: parentID,
+		Status:   "skipped",
+	})
+	e.mu.Unlock()
+}
@@ -0,0 +1,123 @@
+package services
+
+import "github.com/devopstools/backend/internal/models"
+
+// stepGraph is the top-level control flow of a workflow as the executor
+// follows it. Nodes are step indexes; node len(steps) is the end of the run.
+type stepGraph struct {
+	steps    []models.Step
+	index    map[string]int // step ID -> index, first match like the executor
+	edges    [][]int        // possible next steps
+	executed [][]int        // steps run in place by execute_step conditions
+}
+
+func newStepGraph(steps []models.Step) *stepGraph {
+	g := &stepGraph{
+		steps:    steps,
+		index:    make(map[string]int),
+		edges:    make([][]int, len(steps)+1),
+		executed: make([][]int, len(steps)),
+	}
+	for i, step := range steps {
+		if _, ok := g.index[step.ID]; !ok && step.ID != "" {
+			g.index[step.ID] = i
+		}
+	}
+
+	for i, step := range steps {
+		g.edges[i] = append(g.edges[i], g.follow(step.OnSuccess, i))
+		if step.OnFailure == nil {
+			g.edges[i] = append(g.edges[i], g.end()) // the run fails
+		} else {
+			g.edges[i] = append(g.edges[i], g.follow(step.OnFailure, i))
+		}
+
+		for _, cond := range step.Conditions {
+			switch cond.Action.Type {
+			case "stop":
+				g.edges[i] = append(g.edges[i], g.end())
+			case "jump_to":
+				if t, ok := g.index[cond.Action.Target]; ok {
+					g.edges[i] = append(g.edges[i], t)
+				}
+			case "execute_step":
+				if t, ok := g.index[cond.Action.Target]; ok {
+					g.executed[i] = append(g.executed[i], t)
+				}
+			}
+		}
+	}
+	return g
+}
+
+func (g *stepGraph) end() int {
+	return len(g.steps)
+}
+
+// follow returns where an on_success/on_failure action leads from step i
+func (g *stepGraph) follow(action *models.StepAction, i int) int {
+	if action != nil {
+		switch action.Type {
+		case "stop":
+			return g.end()
+		case "jump_to":
+			if t, ok := g.index[action.Target]; ok {
+				return t
+			}
+		}
+	}
+	return i + 1 // i+1 == len(steps) is the end
+}
+
+// reachable marks the steps that can run, starting from the first step
+func (g *stepGraph) reachable() []bool {
+	seen := make([]bool, len(g.steps)+1)
+	if len(g.steps) == 0 {
+		return seen
+	}
+
+	queue := []int{0}
+	seen[0] = true
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		if i == g.end() {
+			continue
+		}
+		for _, t := range g.executed[i] {
+			seen[t] = true
+		}
+		for _, t := range g.edges[i] {
+			if !seen[t] {
+				seen[t] = true
+				queue = append(queue, t)
+			}
+		}
+	}
+	return seen
+}
+
+// finishes marks the steps from which the end of the run can be reached
+func (g *stepGraph) finishes() []bool {
+	reverse := make([][]int, len(g.steps)+1)
+	for i := 0; i < len(g.steps); i++ {
+		for _, t := range g.edges[i] {
+			reverse[t] = append(reverse[t], i)
+		}
+	}
+
+	done := make([]bool, len(g.steps)+1)
+	done[g.end()] = true
+	queue := []int{g.end()}
+	for len(queue) > 0 {
+		t := queue[0]
+		queue = queue[1:]
+		for _, i := range reverse[t] {
+			if !done[i] {
+				done[i] = true
+				queue = append(queue, i)
+			}
+		}
+	}
+	return done
+}
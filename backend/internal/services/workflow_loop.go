@@ -0,0 +1,315 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/devopstools/backend/internal/models"
+)
+
+const (
+	// defaultLoopVariable holds the current item of a foreach step
+	defaultLoopVariable = "ITEM"
+	// loopIndexVariable holds the zero-based iteration number
+	loopIndexVariable = "LOOP_INDEX"
+
+	// maxLoopIterations bounds the iterations of a single loop step
+	maxLoopIterations = 1000
+)
+
+// loopIteration is one set of values a loop runs its child steps with
+type loopIteration struct {
+	label  string // e.g. REGION=eu-west-1, shown in results and logs
+	values map[string]string
+}
+
+// IsLoopStep reports whether a step type runs its child steps per iteration
+func IsLoopStep(stepType string) bool {
+	return stepType == "foreach" || stepType == "matrix"
+}
+
+// loopVariable returns the iteration variable of a foreach step
+func loopVariable(step models.Step) string {
+	if step.As != "" {
+		return step.As
+	}
+	return defaultLoopVariable
+}
+
+// loopIterations expands the items of a foreach step or the combinations of
+// a matrix step. Matrix variables are combined in name order, the first
+// varying slowest.
+func (e *WorkflowExecutor) loopIterations(step models.Step, variables map[string]string) ([]loopIteration, error) {
+	switch step.Type {
+	case "foreach":
+		if len(step.Items) == 0 {
+			return nil, fmt.Errorf("foreach step has no items")
+		}
+		items, err := e.expandLoopValues(step.Items, variables)
+		if err != nil {
+			return nil, fmt.Errorf("items: %w", err)
+		}
+		if len(items) > maxLoopIterations {
+			return nil, fmt.Errorf("foreach step has %d items (max %d)", len(items), maxLoopIterations)
+		}
+
+		name := loopVariable(step)
+		iterations := make([]loopIteration, len(items))
+		for i, item := range items {
+			iterations[i] = loopIteration{
+				label:  fmt.Sprintf("%s=%s", name, item),
+				values: map[string]string{name: item},
+			}
+		}
+		return iterations, nil
+
+	case "matrix":
+		if len(step.Matrix) == 0 {
+			return nil, fmt.Errorf("matrix step has no variables")
+		}
+		names := make([]string, 0, len(step.Matrix))
+		for name := range step.Matrix {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		iterations := []loopIteration{{values: map[string]string{}}}
+		for _, name := range names {
+			values, err := e.expandLoopValues(step.Matrix[name], variables)
+			if err != nil {
+				return nil, fmt.Errorf("matrix.%s: %w", name, err)
+			}
+			if len(iterations)*len(values) > maxLoopIterations {
+				return nil, fmt.Errorf("matrix step has more than %d combinations", maxLoopIterations)
+			}
+
+			next := make([]loopIteration, 0, len(iterations)*len(values))
+			for _, it := range iterations {
+				for _, value := range values {
+					combined := loopIteration{values: make(map[string]string, len(it.values)+1)}
+					for k, v := range it.values {
+						combined.values[k] = v
+					}
+					combined.values[name] = value
+					combined.label = fmt.Sprintf("%s=%s", name, value)
+					if it.label != "" {
+						combined.label = it.label + ", " + combined.label
+					}
+					next = append(next, combined)
+				}
+			}
+			iterations = next
+		}
+		return iterations, nil
+	}
+	return nil, fmt.Errorf("%s is not a loop step", step.Type)
+}
+
+// expandLoopValues renders list entries. An entry that is only a placeholder
+// expands to the list in that variable; any other entry is one value.
+func (e *WorkflowExecutor) expandLoopValues(entries []string, variables map[string]string) ([]string, error) {
+	e.varsMu.RLock()
+	defer e.varsMu.RUnlock()
+
+	var values []string
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		placeholders, _ := e.templateParser.placeholders(entry)
+		whole := len(placeholders) == 1 && placeholders[0].Start == 0 && placeholders[0].End == len(entry)
+
+		rendered, err := e.templateParser.Render(entry, variables, RenderOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if len(rendered.Unresolved) > 0 {
+			return nil, fmt.Errorf("unresolved variables: %s", strings.Join(rendered.Unresolved, ", "))
+		}
+
+		if !whole {
+			values = append(values, rendered.Text)
+			continue
+		}
+		list, err := splitLoopList(rendered.Text)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry, err)
+		}
+		values = append(values, list...)
+	}
+	return values, nil
+}
+
+// splitLoopList reads a list held in a variable: a JSON array, as captured by
+// a json output, or values separated by commas or newlines
+func splitLoopList(value string) ([]string, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "[") {
+		var items []interface{}
+		if err := json.Unmarshal([]byte(value), &items); err != nil {
+			return nil, fmt.Errorf("invalid JSON list: %w", err)
+		}
+		list := make([]string, 0, len(items))
+		for _, item := range items {
+			s, err := jsonValueString(item)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, s)
+		}
+		return list, nil
+	}
+
+	var list []string
+	for _, line := range strings.Split(value, "\n") {
+		for _, item := range strings.Split(line, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list, nil
+}
+
+// executeLoopStep runs the child steps of a foreach or matrix step once per
+// iteration. Within an iteration the children run in order and stop at the
+// first failure not covered by continue_on_error; iterations run up to
+// max_concurrency at once and follow the same wait_all and fail_fast modes as
+// parallel groups.
+func (e *WorkflowExecutor) executeLoopStep(ctx context.Context, step models.Step, variables map[string]string, outputChan chan<- string, execution *models.WorkflowExecution) (string, int, error) {
+	if len(step.Steps) == 0 {
+		return "", -1, fmt.Errorf("%s step has no child steps", step.Type)
+	}
+	mode, err := groupMode(step)
+	if err != nil {
+		return "", -1, err
+	}
+
+	iterations, err := e.loopIterations(step, variables)
+	if err != nil {
+		return "", -1, err
+	}
+	if len(iterations) == 0 {
+		e.logInfo(outputChan, execution, step.ID, "No iterations to run")
+		return "", 0, nil
+	}
+
+	limit := step.MaxConcurrency
+	if limit == 0 {
+		limit = 1
+	}
+	if limit > len(iterations) {
+		limit = len(iterations)
+	}
+
+	e.logInfo(outputChan, execution, step.ID, fmt.Sprintf("Running %d iterations of %d steps (max %d at once, %s)", len(iterations), len(step.Steps), limit, mode))
+
+	results := runGroup(ctx, len(iterations), limit, mode,
+		func(ctx context.Context, i int) (string, int, error) {
+			return e.runIteration(ctx, step, i, len(iterations), iterations[i], variables, outputChan, execution)
+		},
+		func(i int, r groupResult) {
+			id := iterationID(step, i)
+			switch {
+			case r.cancelled:
+				e.logInfo(outputChan, execution, id, fmt.Sprintf("Iteration cancelled: %s", iterations[i].label))
+			case r.err != nil:
+				e.logError(outputChan, execution, id, fmt.Sprintf("Iteration failed: %s: %v", iterations[i].label, r.err))
+			default:
+				e.logInfo(outputChan, execution, id, fmt.Sprintf("Iteration completed: %s", iterations[i].label))
+			}
+		})
+
+	for i, r := range results {
+		if r.skipped {
+			id := iterationID(step, i)
+			e.skipStep(execution, models.Step{ID: id, Name: iterations[i].label, Type: "iteration"}, step.ID)
+			e.logInfo(outputChan, execution, id, fmt.Sprintf("Iteration skipped: %s", iterations[i].label))
+		}
+	}
+
+	output, exitCode, err := groupSummary(results, func(i int) string { return iterations[i].label }, "iterations")
+	if ctx.Err() != nil {
+		return output, -1, ctx.Err()
+	}
+	if err != nil {
+		return output, exitCode, err
+	}
+
+	e.logInfo(outputChan, execution, step.ID, fmt.Sprintf("All %d iterations completed", len(iterations)))
+	return output, 0, nil
+}
+
+// runIteration runs the child steps of a loop with the iteration's values.
+// The iteration is recorded as a step result of type iteration, and its
+// children get IDs suffixed with the iteration number so each iteration has
+// its own results and log section. Outputs captured by the children stay in
+// the iteration's variables.
+func (e *WorkflowExecutor) runIteration(ctx context.Context, step models.Step, i, total int, it loopIteration, variables map[string]string, outputChan chan<- string, execution *models.WorkflowExecution) (output string, exitCode int, err error) {
+	id := iterationID(step, i)
+	index := e.beginStep(execution, models.Step{ID: id, Name: it.label, Type: "iteration"}, step.ID)
+	defer func() {
+		e.endStep(ctx, execution, index, output, exitCode, err, false)
+	}()
+
+	e.varsMu.RLock()
+	scope := make(map[string]string, len(variables)+len(it.values)+1)
+	for k, v := range variables {
+		scope[k] = v
+	}
+	e.varsMu.RUnlock()
+	for k, v := range it.values {
+		scope[k] = v
+	}
+	scope[loopIndexVariable] = strconv.Itoa(i)
+
+	e.logInfo(outputChan, execution, id, fmt.Sprintf("Iteration %d/%d: %s", i+1, total, it.label))
+
+	suffix := fmt.Sprintf("[%d]", i)
+	children := scopeSteps(step.Steps, suffix)
+	var combined strings.Builder
+	for j, child := range children {
+		if err != nil {
+			e.skipStep(execution, child, id)
+			continue
+		}
+
+		e.logInfo(outputChan, execution, child.ID, fmt.Sprintf("Step %d/%d: %s", j+1, len(children), stepLabel(child)))
+		var childOutput string
+		childOutput, exitCode, err = e.runStep(ctx, child, id, scope, outputChan, execution)
+		combined.WriteString(childOutput)
+		if childOutput != "" && !strings.HasSuffix(childOutput, "\n") {
+			combined.WriteString("\n")
+		}
+		if ignoreFailure(ctx, child, err) {
+			e.log(outputChan, execution, child.ID, 0, "warning", fmt.Sprintf("Step failed, continuing (continue_on_error): %v", err))
+			err = nil
+		}
+		if err != nil {
+			err = fmt.Errorf("%s: %w", stepLabel(child), err)
+		}
+	}
+
+	return combined.String(), exitCode, err
+}
+
+// iterationID identifies an iteration in step results and logs
+func iterationID(step models.Step, i int) string {
+	return fmt.Sprintf("%s[%d]", step.ID, i)
+}
+
+// scopeSteps copies steps with suffix appended to their IDs and those of
+// their children
+func scopeSteps(steps []models.Step, suffix string) []models.Step {
+	scoped := make([]models.Step, len(steps))
+	for i, step := range steps {
+		step.ID += suffix
+		if len(step.Steps) > 0 {
+			step.Steps = scopeSteps(step.Steps, suffix)
+		}
+		scoped[i] = step
+	}
+	return scoped
+}
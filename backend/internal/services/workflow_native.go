@@ -0,0 +1,448 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/devopstools/backend/internal/models"
+)
+
+// StepServices are the backend services native step types call directly
+type StepServices struct {
+	Network   *NetworkToolService
+	Terraform *TerraformService
+	ArgoCD    *ArgoCDService
+	AWS       *AWSBrowserService
+}
+
+// nativeStepSpec lists the `with` parameters a native step type accepts
+type nativeStepSpec struct {
+	required []string
+	optional []string
+	prefix   string // prefix of free-form keys, such as header.<name>
+}
+
+var nativeSteps = map[string]nativeStepSpec{
+	"http":        {required: []string{"url"}, optional: []string{"method", "body", "timeout", "expect_status"}, prefix: "header."},
+	"tcp_check":   {required: []string{"host", "port"}, optional: []string{"timeout", "expect"}},
+	"dns":         {required: []string{"name"}, optional: []string{"type", "server"}},
+	"tls_check":   {required: []string{"host"}, optional: []string{"port", "min_days"}},
+	"terraform":   {required: []string{"dir", "command"}, optional: []string{"args"}},
+	"argocd_sync": {required: []string{"app"}},
+	"aws_list":    {required: []string{"resource"}, optional: []string{"profile", "region", "bucket", "prefix"}},
+}
+
+// IsNativeStep reports whether a step type calls a backend service directly
+func IsNativeStep(stepType string) bool {
+	_, ok := nativeSteps[stepType]
+	return ok
+}
+
+// SetStepServices enables the native step types
+func (e *WorkflowExecutor) SetStepServices(services StepServices) {
+	e.services = services
+}
+
+// checkNativeParams reports missing and unknown `with` parameters
+func checkNativeParams(stepType string, with map[string]string) (missing, unknown []string) {
+	spec := nativeSteps[stepType]
+	for _, key := range spec.required {
+		if strings.TrimSpace(with[key]) == "" {
+			missing = append(missing, key)
+		}
+	}
+	for key := range with {
+		if containsString(spec.required, key) || containsString(spec.optional, key) {
+			continue
+		}
+		if spec.prefix != "" && strings.HasPrefix(key, spec.prefix) && len(key) > len(spec.prefix) {
+			continue
+		}
+		unknown = append(unknown, key)
+	}
+	sort.Strings(unknown)
+	return missing, unknown
+}
+
+// renderParams substitutes variables into the `with` parameters of a step.
+// Values are not shell-escaped since nothing goes through a shell.
+func (e *WorkflowExecutor) renderParams(step models.Step, variables map[string]string) (map[string]string, []string, error) {
+	e.varsMu.RLock()
+	defer e.varsMu.RUnlock()
+
+	params := make(map[string]string, len(step.With))
+	seen := make(map[string]bool)
+	var unresolved []string
+	for key, value := range step.With {
+		rendered, err := e.templateParser.Render(value, variables, RenderOptions{})
+		if err != nil {
+			return nil, nil, fmt.Errorf("with.%s: %w", key, err)
+		}
+		params[key] = rendered.Text
+		for _, name := range rendered.Unresolved {
+			if !seen[name] {
+				seen[name] = true
+				unresolved = append(unresolved, name)
+			}
+		}
+	}
+	sort.Strings(unresolved)
+	return params, unresolved, nil
+}
+
+// executeNativeStep calls the service behind a native step. The structured
+// result is the step output as JSON, so conditions and json output captures
+// can read its fields; the exit code is 0 on success and 1 on failure.
+func (e *WorkflowExecutor) executeNativeStep(ctx context.Context, step models.Step, variables map[string]string, outputChan chan<- string, execution *models.WorkflowExecution) (string, int, error) {
+	params, unresolved, err := e.renderParams(step, variables)
+	if err != nil {
+		return "", -1, err
+	}
+	if len(unresolved) > 0 {
+		return "", -1, fmt.Errorf("unresolved placeholders: %s", strings.Join(unresolved, ", "))
+	}
+	if missing, _ := checkNativeParams(step.Type, params); len(missing) > 0 {
+		return "", -1, fmt.Errorf("%s step is missing with.%s", step.Type, strings.Join(missing, ", with."))
+	}
+
+	e.logInfo(outputChan, execution, step.ID, fmt.Sprintf("Calling %s: %s", step.Type, describeParams(params)))
+
+	if !IsNativeStep(step.Type) {
+		return "", -1, fmt.Errorf("unknown step type: %s", step.Type)
+	}
+	data, err := e.callNative(ctx, step.Type, params, execution.Workspace)
+
+	var output string
+	if data != nil {
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		if encodeErr := encoder.Encode(data); encodeErr != nil {
+			return "", -1, encodeErr
+		}
+		output = strings.TrimSuffix(buf.String(), "\n")
+		for _, line := range strings.Split(output, "\n") {
+			e.logInfo(outputChan, execution, step.ID, line)
+		}
+	}
+
+	if err != nil {
+		return output, 1, err
+	}
+	return output, 0, nil
+}
+
+// callNative calls the service behind a native step. Services that take the
+// context stop when it ends and are waited for, so a terraform or argocd
+// process never outlives its attempt. The others do not take a context, so
+// when ctx ends first the call is left to finish in the background; it only
+// hands back its result and never touches the run. Relative paths resolve
+// against the run workspace.
+func (e *WorkflowExecutor) callNative(ctx context.Context, stepType string, params map[string]string, workspace string) (interface{}, error) {
+	switch stepType {
+	case "terraform":
+		return e.nativeTerraform(ctx, params, workspace)
+	case "argocd_sync":
+		return e.nativeArgoCDSync(ctx, params)
+	case "aws_list":
+		return e.nativeAWSList(ctx, params)
+	}
+
+	type result struct {
+		data interface{}
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		switch stepType {
+		case "http":
+			r.data, r.err = e.nativeHTTP(params)
+		case "tcp_check":
+			r.data, r.err = e.nativeTCPCheck(params)
+		case "dns":
+			r.data, r.err = e.nativeDNS(params)
+		case "tls_check":
+			r.data, r.err = e.nativeTLSCheck(params)
+		}
+		done <- r
+	}()
+
+	select {
+	case r := <-done:
+		return r.data, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (e *WorkflowExecutor) nativeHTTP(params map[string]string) (interface{}, error) {
+	if e.services.Network == nil {
+		return nil, fmt.Errorf("http steps are not enabled")
+	}
+
+	method := strings.ToUpper(params["method"])
+	if method == "" {
+		method = "GET"
+	}
+	headers := make(map[string]string)
+	for key, value := range params {
+		if strings.HasPrefix(key, "header.") {
+			headers[strings.TrimPrefix(key, "header.")] = value
+		}
+	}
+	timeout, err := intParam(params, "timeout", 30)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := e.services.Network.ExecuteHTTPRequest(method, params["url"], headers, params["body"], timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	info, _ := result.ParsedResult.(map[string]interface{})
+	status, _ := info["status_code"].(int)
+	data := map[string]interface{}{
+		"status_code": status,
+		"status":      info["status"],
+		"headers":     info["headers"],
+		"body":        result.Output,
+		"duration_ms": result.DurationMs,
+	}
+	var body interface{}
+	if json.Unmarshal([]byte(result.Output), &body) == nil {
+		data["json"] = body
+	}
+
+	if !statusMatches(status, params["expect_status"]) {
+		expected := params["expect_status"]
+		if expected == "" {
+			expected = "< 400"
+		}
+		return data, fmt.Errorf("unexpected status %d (expected %s)", status, expected)
+	}
+	return data, nil
+}
+
+// statusMatches checks a status code against "200", "2xx" or a comma
+// separated list of those; without an expectation any status below 400 passes
+func statusMatches(status int, expect string) bool {
+	if strings.TrimSpace(expect) == "" {
+		return status > 0 && status < 400
+	}
+	code := strconv.Itoa(status)
+	for _, want := range strings.Split(expect, ",") {
+		want = strings.ToLower(strings.TrimSpace(want))
+		if len(want) == 3 && strings.HasSuffix(want, "xx") && code[:1] == want[:1] {
+			return true
+		}
+		if want == code {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *WorkflowExecutor) nativeTCPCheck(params map[string]string) (interface{}, error) {
+	if e.services.Network == nil {
+		return nil, fmt.Errorf("tcp_check steps are not enabled")
+	}
+
+	port, err := intParam(params, "port", 0)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := intParam(params, "timeout", 5)
+	if err != nil {
+		return nil, err
+	}
+	expect := params["expect"]
+	switch expect {
+	case "":
+		expect = "open"
+	case "open", "closed":
+	default:
+		return nil, fmt.Errorf("invalid expect: %s (expected open or closed)", expect)
+	}
+
+	result, err := e.services.Network.CheckTCPPort(params["host"], port, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	data, _ := result.ParsedResult.(*models.TCPPortResult)
+	if data == nil {
+		return nil, fmt.Errorf("tcp check returned no result")
+	}
+	if data.Open != (expect == "open") {
+		return data, fmt.Errorf("port %d on %s is not %s", data.Port, data.Host, expect)
+	}
+	return data, nil
+}
+
+func (e *WorkflowExecutor) nativeDNS(params map[string]string) (interface{}, error) {
+	if e.services.Network == nil {
+		return nil, fmt.Errorf("dns steps are not enabled")
+	}
+
+	queryType := strings.ToUpper(params["type"])
+	if queryType == "" {
+		queryType = "A"
+	}
+
+	result, err := e.services.Network.ExecuteDNSLookup(params["name"], queryType, params["server"])
+	if err != nil {
+		return nil, err
+	}
+
+	data, _ := result.ParsedResult.(*models.DNSResult)
+	if data == nil {
+		return nil, fmt.Errorf("could not parse DNS response")
+	}
+	if len(data.Answers) == 0 {
+		return data, fmt.Errorf("no %s records for %s", queryType, params["name"])
+	}
+	return data, nil
+}
+
+func (e *WorkflowExecutor) nativeTLSCheck(params map[string]string) (interface{}, error) {
+	if e.services.Network == nil {
+		return nil, fmt.Errorf("tls_check steps are not enabled")
+	}
+
+	port, err := intParam(params, "port", 443)
+	if err != nil {
+		return nil, err
+	}
+	minDays, err := intParam(params, "min_days", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := e.services.Network.InspectTLS(params["host"], port)
+	if err != nil {
+		return nil, err
+	}
+
+	data, _ := result.ParsedResult.(*models.TLSResult)
+	if data == nil {
+		return nil, fmt.Errorf("could not parse TLS response")
+	}
+	if minDays > 0 && data.DaysToExpiry < minDays {
+		return data, fmt.Errorf("certificate for %s expires in %d days (minimum %d)", data.Host, data.DaysToExpiry, minDays)
+	}
+	return data, nil
+}
+
+func (e *WorkflowExecutor) nativeTerraform(ctx context.Context, params map[string]string, workspace string) (interface{}, error) {
+	if e.services.Terraform == nil {
+		return nil, fmt.Errorf("terraform steps are not enabled")
+	}
+
+	dir := params["dir"]
+	if workspace != "" && !filepath.IsAbs(dir) {
+		dir = filepath.Join(workspace, dir)
+	}
+	result, err := e.services.Terraform.ExecuteCommandContext(ctx, dir, params["command"], strings.Fields(params["args"])...)
+	if result == nil {
+		return nil, err
+	}
+	return result, err
+}
+
+func (e *WorkflowExecutor) nativeArgoCDSync(ctx context.Context, params map[string]string) (interface{}, error) {
+	if e.services.ArgoCD == nil {
+		return nil, fmt.Errorf("argocd_sync steps are not enabled")
+	}
+
+	if err := e.services.ArgoCD.SyncApplicationContext(ctx, params["app"]); err != nil {
+		return nil, err
+	}
+	return e.services.ArgoCD.GetApplicationContext(ctx, params["app"])
+}
+
+func (e *WorkflowExecutor) nativeAWSList(ctx context.Context, params map[string]string) (interface{}, error) {
+	if e.services.AWS == nil {
+		return nil, fmt.Errorf("aws_list steps are not enabled")
+	}
+
+	aws := e.services.AWS
+	profile, region := params["profile"], params["region"]
+
+	var items interface{}
+	var count int
+	var err error
+	switch params["resource"] {
+	case "s3_buckets":
+		var buckets []S3Bucket
+		buckets, err = aws.ListS3Buckets(ctx, profile)
+		items, count = buckets, len(buckets)
+	case "s3_objects":
+		if params["bucket"] == "" {
+			return nil, fmt.Errorf("aws_list s3_objects needs with.bucket")
+		}
+		var objects []S3Object
+		objects, err = aws.ListS3Objects(ctx, profile, params["bucket"], params["prefix"])
+		items, count = objects, len(objects)
+	case "ec2":
+		var instances []EC2Instance
+		instances, err = aws.ListEC2Instances(ctx, profile, region)
+		items, count = instances, len(instances)
+	case "rds":
+		var instances []RDSInstance
+		instances, err = aws.ListRDSInstances(ctx, profile, region)
+		items, count = instances, len(instances)
+	case "lambda":
+		var functions []LambdaFunction
+		functions, err = aws.ListLambdaFunctions(ctx, profile, region)
+		items, count = functions, len(functions)
+	default:
+		return nil, fmt.Errorf("invalid resource: %s (expected s3_buckets, s3_objects, ec2, rds or lambda)", params["resource"])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"resource": params["resource"],
+		"count":    count,
+		"items":    items,
+	}, nil
+}
+
+func intParam(params map[string]string, key string, def int) (int, error) {
+	value := strings.TrimSpace(params[key])
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("with.%s: %q is not a number", key, value)
+	}
+	return n, nil
+}
+
+// describeParams formats parameters for the run log, body excluded
+func describeParams(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		if key != "body" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%s", key, params[key]))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/devopstools/backend/internal/models"
+)
+
+const (
+	ParallelModeWaitAll  = "wait_all"
+	ParallelModeFailFast = "fail_fast"
+)
+
+// groupResult is the outcome of one member of a parallel group or loop
+type groupResult struct {
+	output    string
+	exitCode  int
+	err       error
+	skipped   bool // never started
+	cancelled bool // stopped by another member's failure (fail_fast)
+}
+
+// runGroup runs n members with at most limit at once and returns their
+// results in order. In fail_fast mode the first failure cancels the members
+// still running and skips those not yet started. done is called as each
+// started member finishes.
+func runGroup(ctx context.Context, n, limit int, mode string, run func(ctx context.Context, i int) (string, int, error), done func(i int, r groupResult)) []groupResult {
+	groupCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]groupResult, n)
+	slots := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	var failMu sync.Mutex
+	groupFailed := false
+
+	for i := 0; i < n; i++ {
+		// Wait for a free slot; stop launching once the group is cancelled
+		select {
+		case slots <- struct{}{}:
+		case <-groupCtx.Done():
+		}
+		if groupCtx.Err() != nil {
+			results[i].skipped = true
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			output, exitCode, err := run(groupCtx, i)
+			results[i].output, results[i].exitCode, results[i].err = output, exitCode, err
+
+			if err != nil && mode == ParallelModeFailFast {
+				failMu.Lock()
+				if groupFailed {
+					results[i].cancelled = true
+				}
+				groupFailed = true
+				failMu.Unlock()
+				cancel()
+			}
+			done(i, results[i])
+		}(i)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// groupMode checks the concurrency and mode of a parallel group or loop
+func groupMode(step models.Step) (string, error) {
+	if step.MaxConcurrency < 0 {
+		return "", fmt.Errorf("max_concurrency must not be negative")
+	}
+	mode := step.Mode
+	if mode == "" {
+		mode = ParallelModeWaitAll
+	}
+	if mode != ParallelModeWaitAll && mode != ParallelModeFailFast {
+		return "", fmt.Errorf("invalid %s mode: %s (expected wait_all or fail_fast)", step.Type, mode)
+	}
+	return mode, nil
+}
+
+// groupSummary combines member results in order under [label] headers and
+// builds the error for failed members. The exit code is that of the first
+// failure.
+func groupSummary(results []groupResult, label func(i int) string, noun string) (string, int, error) {
+	var output strings.Builder
+	var failed, cancelled, skipped []string
+	exitCode := 0
+	for i, r := range results {
+		name := label(i)
+		if r.skipped {
+			skipped = append(skipped, name)
+			continue
+		}
+		fmt.Fprintf(&output, "[%s]\n%s", name, r.output)
+		if r.output != "" && !strings.HasSuffix(r.output, "\n") {
+			output.WriteString("\n")
+		}
+		if r.cancelled {
+			cancelled = append(cancelled, name)
+		} else if r.err != nil {
+			failed = append(failed, name)
+			if exitCode == 0 {
+				exitCode = r.exitCode
+				if exitCode == 0 {
+					exitCode = -1
+				}
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		msg := fmt.Sprintf("%d of %d %s failed: %s", len(failed), len(results), noun, strings.Join(failed, ", "))
+		if len(cancelled) > 0 || len(skipped) > 0 {
+			msg += fmt.Sprintf(" (%d cancelled, %d skipped)", len(cancelled), len(skipped))
+		}
+		return output.String(), exitCode, fmt.Errorf("%s", msg)
+	}
+	return output.String(), 0, nil
+}
+
+// executeParallelStep runs the child steps of a parallel group concurrently.
+// In wait_all mode every child runs and the group fails if any child failed;
+// in fail_fast mode the first failure cancels the children still running and
+// skips those not yet started.
+func (e *WorkflowExecutor) executeParallelStep(ctx context.Context, step models.Step, variables map[string]string, outputChan chan<- string, execution *models.WorkflowExecution) (string, int, error) {
+	if len(step.Steps) == 0 {
+		return "", -1, fmt.Errorf("parallel step has no child steps")
+	}
+	mode, err := groupMode(step)
+	if err != nil {
+		return "", -1, err
+	}
+
+	limit := step.MaxConcurrency
+	if limit == 0 || limit > len(step.Steps) {
+		limit = len(step.Steps)
+	}
+
+	e.logInfo(outputChan, execution, step.ID, fmt.Sprintf("Running %d steps in parallel (max %d at once, %s)", len(step.Steps), limit, mode))
+
+	results := runGroup(ctx, len(step.Steps), limit, mode,
+		func(ctx context.Context, i int) (string, int, error) {
+			child := step.Steps[i]
+			e.logInfo(outputChan, execution, child.ID, fmt.Sprintf("Parallel step started: %s", stepLabel(child)))
+			output, exitCode, err := e.runStep(ctx, child, step.ID, variables, outputChan, execution)
+			if ignoreFailure(ctx, child, err) {
+				e.log(outputChan, execution, child.ID, 0, "warning", fmt.Sprintf("Step failed, continuing (continue_on_error): %v", err))
+				err = nil
+			}
+			return output, exitCode, err
+		},
+		func(i int, r groupResult) {
+			child := step.Steps[i]
+			switch {
+			case r.cancelled:
+				e.logInfo(outputChan, execution, child.ID, fmt.Sprintf("Parallel step cancelled: %s", stepLabel(child)))
+			case r.err != nil:
+				e.logError(outputChan, execution, child.ID, fmt.Sprintf("Parallel step failed: %s: %v", stepLabel(child), r.err))
+			default:
+				e.logInfo(outputChan, execution, child.ID, fmt.Sprintf("Parallel step completed: %s", stepLabel(child)))
+			}
+		})
+
+	for i, r := range results {
+		if r.skipped {
+			child := step.Steps[i]
+			e.skipStep(execution, child, step.ID)
+			e.logInfo(outputChan, execution, child.ID, fmt.Sprintf("Parallel step skipped: %s", stepLabel(child)))
+		}
+	}
+
+	output, exitCode, err := groupSummary(results, func(i int) string { return stepLabel(step.Steps[i]) }, "parallel steps")
+	if ctx.Err() != nil {
+		return output, -1, ctx.Err()
+	}
+	if err != nil {
+		return output, exitCode, err
+	}
+
+	e.logInfo(outputChan, execution, step.ID, fmt.Sprintf("All %d parallel steps completed", len(step.Steps)))
+	return output, 0, nil
+}
+
+// stepLabel names a step in logs, falling back to its ID
+func stepLabel(step models.Step) string {
+	if step.Name != "" {
+		return step.Name
+	}
+	return step.ID
+}
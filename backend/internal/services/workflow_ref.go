@@ -0,0 +1,138 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/devopstools/backend/internal/models"
+)
+
+// maxWorkflowDepth bounds how deeply workflow_ref steps can nest
+const maxWorkflowDepth = 5
+
+// subWorkflowResult is the output of a workflow_ref step
+type subWorkflowResult struct {
+	RunID   string            `json:"run_id"`
+	Status  string            `json:"status"`
+	Error   string            `json:"error,omitempty"`
+	Outputs map[string]string `json:"outputs"`
+}
+
+// executeWorkflowStep runs the referenced workflow and waits for it. The
+// child only receives the inputs in `with`, rendered against the parent's
+// variables; global variables and its own defaults apply as usual. The step
+// output is a JSON object with the child's run ID, status and the values its
+// steps captured as outputs, so the parent can capture them in turn.
+func (e *WorkflowExecutor) executeWorkflowStep(ctx context.Context, step models.Step, variables map[string]string, outputChan chan<- string, execution *models.WorkflowExecution) (string, int, error) {
+	callStack := append(append([]string(nil), execution.CallStack...), execution.WorkflowID)
+	for _, id := range callStack {
+		if id == step.Content {
+			return "", -1, fmt.Errorf("recursive workflow call: %s -> %s", strings.Join(callStack, " -> "), step.Content)
+		}
+	}
+	if len(callStack) >= maxWorkflowDepth {
+		return "", -1, fmt.Errorf("workflow calls nested more than %d deep", maxWorkflowDepth)
+	}
+
+	inputs, unresolved, err := e.renderParams(step, variables)
+	if err != nil {
+		return "", -1, err
+	}
+	if len(unresolved) > 0 {
+		return "", -1, fmt.Errorf("unresolved placeholders: %s", strings.Join(unresolved, ", "))
+	}
+
+	// Inputs built from secrets stay secret in the child
+	secrets := append([]string(nil), execution.SecretVariables...)
+	for key, value := range step.With {
+		placeholders, _ := e.templateParser.placeholders(value)
+		for _, p := range placeholders {
+			if !p.Env && containsString(execution.SecretVariables, p.Name) {
+				secrets = append(secrets, key)
+				break
+			}
+		}
+	}
+
+	names := make([]string, 0, len(inputs))
+	for name := range inputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	e.logInfo(outputChan, execution, step.ID, fmt.Sprintf("Executing sub-workflow: %s (inputs: %s)", step.Content, strings.Join(names, ", ")))
+
+	subOutputChan := make(chan string, 100)
+	trigger := &models.RunTrigger{Type: "workflow", ID: execution.ID}
+	child, err := e.execute(ctx, step.Content, execution.Environment, inputs, secrets, callStack, trigger, subOutputChan)
+	if err != nil {
+		return "", -1, err
+	}
+
+	// The channel is closed when the child run ends
+	for msg := range subOutputChan {
+		e.logInfo(outputChan, execution, step.ID, "  "+msg)
+	}
+
+	snapshot := e.Snapshot(child)
+	result := subWorkflowResult{
+		RunID:   snapshot.ID,
+		Status:  snapshot.Status,
+		Error:   snapshot.Error,
+		Outputs: make(map[string]string),
+	}
+	if workflow, err := e.runWorkflow(&snapshot); err == nil {
+		for _, name := range workflowOutputs(workflow.Steps) {
+			if value, ok := snapshot.Variables[name]; ok && !containsString(snapshot.SecretVariables, name) {
+				result.Outputs[name] = value
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		return "", -1, err
+	}
+	output := strings.TrimSuffix(buf.String(), "\n")
+
+	if snapshot.Status != "completed" {
+		if ctx.Err() != nil {
+			return output, -1, ctx.Err()
+		}
+		return output, 1, fmt.Errorf("sub-workflow %s %s: %s", step.Content, snapshot.Status, snapshot.Error)
+	}
+	e.logInfo(outputChan, execution, step.ID, fmt.Sprintf("Sub-workflow completed: run %s", snapshot.ID))
+	return output, 0, nil
+}
+
+// workflowOutputs lists the variables a workflow's steps capture, in name
+// order. Outputs captured inside loop iterations stay in the iteration and
+// are not included.
+func workflowOutputs(steps []models.Step) []string {
+	seen := make(map[string]bool)
+	var walk func(steps []models.Step)
+	walk = func(steps []models.Step) {
+		for _, step := range steps {
+			for _, out := range step.Outputs {
+				seen[out.Name] = true
+			}
+			if !IsLoopStep(step.Type) {
+				walk(step.Steps)
+			}
+		}
+	}
+	walk(steps)
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/devopstools/backend/internal/logger"
+	"github.com/devopstools/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+var ErrRunNotResumable = errors.New("run cannot be resumed")
+
+// Resume starts a new run that continues a finished one at stepID, or at the
+// step where it failed, was cancelled or was interrupted. It runs the
+// workflow version the original run executed, with the variables it had
+// saved, including captured outputs. Secret values are not saved, so they are
+// taken from inputs or the global variables again; other inputs override
+// saved values.
+func (e *WorkflowExecutor) Resume(ctx context.Context, runID, stepID string, inputs map[string]string, outputChan chan<- string) (*models.WorkflowExecution, error) {
+	if e.runStore == nil {
+		return nil, ErrRunNotFound
+	}
+	if _, ok := e.GetActive(runID); ok {
+		return nil, fmt.Errorf("%w: run is still active", ErrRunNotResumable)
+	}
+	original, err := e.runStore.Get(runID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch original.Status {
+	case "failed", "cancelled", "interrupted":
+	case "completed":
+		if stepID == "" {
+			return nil, fmt.Errorf("%w: run completed, choose a step to resume from", ErrRunNotResumable)
+		}
+	default:
+		return nil, fmt.Errorf("%w: run is %s", ErrRunNotResumable, original.Status)
+	}
+
+	workflow, err := e.runWorkflow(original)
+	if err != nil {
+		return nil, err
+	}
+
+	if stepID == "" {
+		if stepID = resumePoint(original); stepID == "" {
+			return nil, fmt.Errorf("%w: no failed step found, choose a step to resume from", ErrRunNotResumable)
+		}
+	}
+	from := -1
+	for i, step := range workflow.Steps {
+		if step.ID == stepID {
+			from = i
+			break
+		}
+	}
+	if from < 0 {
+		return nil, fmt.Errorf("%w: step %s is not a top-level step of version %d", ErrRunNotResumable, stepID, workflow.Version)
+	}
+
+	variables, err := e.resumeVariables(workflow, original, inputs)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateInputs(workflow, variables); err != nil {
+		return nil, err
+	}
+	secretNames, err := e.secretNames(workflow, original.Environment, original.SecretVariables)
+	if err != nil {
+		return nil, err
+	}
+	warnings, err := e.runWarnings(original.Environment, original.Trigger)
+	if err != nil {
+		return nil, err
+	}
+
+	execution := &models.WorkflowExecution{
+		ID:              uuid.New().String(),
+		WorkflowID:      original.WorkflowID,
+		WorkflowName:    workflow.Name,
+		WorkflowVersion: workflow.Version,
+		Trigger:         original.Trigger,
+		ResumedFrom:     original.ID,
+		ResumeStep:      stepID,
+		CallStack:       original.CallStack,
+		Environment:     original.Environment,
+		Warnings:        warnings,
+		Status:          "running",
+		Variables:       variables,
+		SecretVariables: secretNames,
+		Steps:           []models.StepResult{},
+		Logs:            []models.ExecutionLog{},
+		StartTime:       time.Now().Format(time.RFC3339Nano),
+	}
+
+	e.linkResumed(original.ID, execution.ID)
+	e.start(ctx, workflow, execution, from, outputChan)
+	return execution, nil
+}
+
+// runWorkflow loads the workflow version a run executed, or the current
+// workflow for runs recorded before versions were kept
+func (e *WorkflowExecutor) runWorkflow(run *models.WorkflowExecution) (*models.Workflow, error) {
+	if run.WorkflowVersion > 0 {
+		if v, err := e.store.GetVersion(run.WorkflowID, run.WorkflowVersion); err == nil {
+			return v.Workflow, nil
+		}
+	}
+	return e.store.Get(run.WorkflowID)
+}
+
+// resumePoint returns the last top-level step of a run that failed, was
+// cancelled or never finished. Failures let pass by continue_on_error do not
+// count.
+func resumePoint(run *models.WorkflowExecution) string {
+	for i := len(run.Steps) - 1; i >= 0; i-- {
+		result := run.Steps[i]
+		if result.ParentID != "" {
+			continue
+		}
+		switch {
+		case result.Status == "failed" && !result.ErrorIgnored,
+			result.Status == "cancelled",
+			result.Status == "running":
+			return result.StepID
+		}
+	}
+	return ""
+}
+
+// resumeVariables restores the saved variables of a run. Masked secrets are
+// filled from inputs or the current variables and fail the resume when
+// neither has them.
+func (e *WorkflowExecutor) resumeVariables(workflow *models.Workflow, run *models.WorkflowExecution, inputs map[string]string) (map[string]string, error) {
+	variables := make(map[string]string, len(run.Variables)+len(inputs))
+	for k, v := range run.Variables {
+		variables[k] = v
+	}
+	for k, v := range inputs {
+		variables[k] = v
+	}
+
+	current, err := e.resolveVariables(workflow, run.Environment, inputs)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, name := range run.SecretVariables {
+		if variables[name] != secretMask {
+			continue
+		}
+		if value, ok := current[name]; ok && value != "" {
+			variables[name] = value
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: secret values are not saved, provide %s again", ErrInvalidInputs, strings.Join(missing, ", "))
+	}
+	return variables, nil
+}
+
+// linkResumed records on the original run that runID resumed it
+func (e *WorkflowExecutor) linkResumed(originalID, runID string) {
+	e.persistMu.Lock()
+	defer e.persistMu.Unlock()
+
+	original, err := e.runStore.Get(originalID)
+	if err == nil {
+		original.ResumedBy = append(original.ResumedBy, runID)
+		err = e.runStore.Save(original)
+	}
+	if err != nil {
+		logger.Error("Failed to link resumed workflow run", err, logger.WithFields(map[string]interface{}{
+			"run_id":       runID,
+			"resumed_from": originalID,
+		}).Data)
+	}
+}
@@ -2,18 +2,27 @@ package services
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/devopstools/backend/internal/models"
 	"github.com/google/uuid"
 )
 
+var (
+	ErrWorkflowNotFound  = errors.New("workflow not found")
+	ErrInvalidWorkflowID = errors.New("invalid workflow id")
+)
+
 type WorkflowStore struct {
-	dataDir string
-	mu      sync.RWMutex
+	dataDir   string
+	mu        sync.RWMutex
+	validator *WorkflowValidator
 }
 
 func NewWorkflowStore(dataDir string) (*WorkflowStore, error) {
@@ -23,6 +32,11 @@ func NewWorkflowStore(dataDir string) (*WorkflowStore, error) {
 	return &WorkflowStore{dataDir: dataDir}, nil
 }
 
+// SetValidator makes imports reject workflows with validation errors
+func (s *WorkflowStore) SetValidator(validator *WorkflowValidator) {
+	s.validator = validator
+}
+
 func (s *WorkflowStore) List() ([]models.Workflow, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -56,11 +70,16 @@ func (s *WorkflowStore) Get(id string) (*models.Workflow, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	return s.read(id)
+}
+
+// read loads the current workflow. Callers hold s.mu.
+func (s *WorkflowStore) read(id string) (*models.Workflow, error) {
 	path := filepath.Join(s.dataDir, id+".json")
 	data, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("workflow not found")
+			return nil, ErrWorkflowNotFound
 		}
 		return nil, err
 	}
@@ -73,21 +92,66 @@ func (s *WorkflowStore) Get(id string) (*models.Workflow, error) {
 	return &wf, nil
 }
 
-func (s *WorkflowStore) Save(wf models.Workflow) error {
+// Save stores a workflow as a new version. Saving content identical to the
+// current version changes nothing and returns the stored workflow.
+func (s *WorkflowStore) Save(wf models.Workflow, author, note string) (*models.Workflow, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if wf.ID == "" {
 		wf.ID = uuid.New().String()
 	}
+	// The ID names the workflow's file and version directory
+	if wf.ID != filepath.Base(wf.ID) || strings.HasPrefix(wf.ID, ".") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidWorkflowID, wf.ID)
+	}
+
+	current, err := s.read(wf.ID)
+	if err != nil && !errors.Is(err, ErrWorkflowNotFound) {
+		return nil, err
+	}
+
+	latest, err := s.latestVersion(wf.ID)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil && latest == 0 {
+		// Workflow saved before history was kept
+		if err := s.writeVersion(*current, 1, "", "version before history was kept", current.UpdatedAt); err != nil {
+			return nil, err
+		}
+		latest = 1
+	}
+	if current != nil && current.Version == 0 {
+		current.Version = latest
+	}
+	if current != nil && sameContent(*current, wf) {
+		return current, nil
+	}
+
+	now := time.Now()
+	if current != nil {
+		wf.CreatedAt = current.CreatedAt
+	} else if wf.CreatedAt.IsZero() {
+		wf.CreatedAt = now
+	}
+	wf.UpdatedAt = now
+	wf.Version = latest + 1
+
+	if err := s.writeVersion(wf, wf.Version, author, note, now); err != nil {
+		return nil, err
+	}
 
 	data, err := json.MarshalIndent(wf, "", "  ")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	path := filepath.Join(s.dataDir, wf.ID+".json")
-	return os.WriteFile(path, data, 0644)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return nil, err
+	}
+	return &wf, nil
 }
 
 func (s *WorkflowStore) Delete(id string) error {
@@ -0,0 +1,623 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/devopstools/backend/internal/models"
+)
+
+// WorkflowValidator statically checks workflow definitions before they are saved
+type WorkflowValidator struct {
+	store          *WorkflowStore
+	variables      *VariableService
+	templateParser *TemplateParser
+}
+
+func NewWorkflowValidator(store *WorkflowStore, variables *VariableService) *WorkflowValidator {
+	return &WorkflowValidator{
+		store:          store,
+		variables:      variables,
+		templateParser: NewTemplateParser(),
+	}
+}
+
+// WorkflowValidationError is returned when workflows have validation errors
+type WorkflowValidationError struct {
+	Results []*models.ValidationResult
+}
+
+func (e *WorkflowValidationError) Error() string {
+	for _, result := range e.Results {
+		for _, issue := range result.Issues {
+			if issue.Severity == "error" {
+				return fmt.Sprintf("workflow %s is invalid: %s: %s", result.WorkflowID, issue.Location, issue.Message)
+			}
+		}
+	}
+	return "workflow is invalid"
+}
+
+// ValidateAll checks workflows saved together, resolving references between
+// them, and returns a *WorkflowValidationError if any of them has errors
+func (v *WorkflowValidator) ValidateAll(workflows []models.Workflow) error {
+	var invalid []*models.ValidationResult
+	for _, wf := range workflows {
+		if result := v.Validate(wf, workflows...); !result.Valid {
+			invalid = append(invalid, result)
+		}
+	}
+	if len(invalid) > 0 {
+		return &WorkflowValidationError{Results: invalid}
+	}
+	return nil
+}
+
+// Validate checks a workflow. Workflow references resolve against pending
+// (workflows being saved in the same batch) before the store.
+func (v *WorkflowValidator) Validate(wf models.Workflow, pending ...models.Workflow) *models.ValidationResult {
+	c := &workflowCheck{
+		validator: v,
+		workflow:  wf,
+		pending:   make(map[string]*models.Workflow),
+		result:    &models.ValidationResult{WorkflowID: wf.ID, Issues: []models.ValidationIssue{}},
+		declared:  make(map[string]bool),
+		used:      make(map[string]bool),
+		stepIDs:   make(map[string]string),
+		topLevel:  make(map[string]int),
+	}
+	for i := range pending {
+		c.pending[pending[i].ID] = &pending[i]
+	}
+
+	if strings.TrimSpace(wf.Name) == "" {
+		c.add("error", "missing_name", "name", "", "workflow name is required")
+	}
+
+	c.checkVariables()
+	c.checkLocks()
+	c.collectSteps(wf.Steps, "steps", true)
+	for i, step := range wf.Steps {
+		c.checkStep(step, fmt.Sprintf("steps[%d]", i), false)
+	}
+	c.checkPlaceholders()
+	c.checkGraph()
+	c.checkReferenceCycles()
+
+	c.result.Valid = true
+	for _, issue := range c.result.Issues {
+		if issue.Severity == "error" {
+			c.result.Valid = false
+			break
+		}
+	}
+	return c.result
+}
+
+// workflowCheck holds the state of a single validation
+type workflowCheck struct {
+	validator *WorkflowValidator
+	workflow  models.Workflow
+	pending   map[string]*models.Workflow
+	result    *models.ValidationResult
+
+	declared map[string]bool   // variables available to steps
+	used     map[string]bool   // variables referenced by steps
+	uses     []placeholderUse  // placeholders found in steps
+	stepIDs  map[string]string // step ID -> location, all levels
+	topLevel map[string]int    // top-level step ID -> index (jump targets)
+}
+
+// placeholderUse is a {VAR} found in a step
+type placeholderUse struct {
+	name       string
+	hasDefault bool
+	location   string
+	stepID     string
+	local      map[string]string // step variable mappings
+}
+
+func (c *workflowCheck) add(severity, code, location, stepID, format string, args ...interface{}) {
+	c.result.Issues = append(c.result.Issues, models.ValidationIssue{
+		Severity: severity,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		Location: location,
+		StepID:   stepID,
+	})
+}
+
+// checkVariables validates declarations and default values against their type
+func (c *workflowCheck) checkVariables() {
+	if c.validator.variables != nil {
+		for name := range c.validator.variables.KnownNames() {
+			c.declared[name] = true
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i, variable := range c.workflow.Variables {
+		loc := fmt.Sprintf("variables[%d]", i)
+		if variable.Name == "" {
+			c.add("error", "missing_variable_name", loc+".name", "", "variable name is required")
+			continue
+		}
+		if seen[variable.Name] {
+			c.add("error", "duplicate_variable", loc+".name", "", "variable %s is declared more than once", variable.Name)
+		}
+		seen[variable.Name] = true
+		c.declared[variable.Name] = true
+
+		def := variable.DefaultValue
+		switch variable.Type {
+		case "", "string", "number", "boolean", "secret":
+		case "select":
+			if len(variable.Options) == 0 {
+				c.add("error", "missing_options", loc+".options", "", "select variable %s has no options", variable.Name)
+				continue
+			}
+		default:
+			c.add("error", "invalid_variable_type", loc+".type", "", "unknown variable type %q (expected string, number, select, boolean or secret)", variable.Type)
+			continue
+		}
+
+		if variable.Pattern != "" {
+			if _, err := compileVariablePattern(variable.Pattern); err != nil {
+				c.add("error", "invalid_pattern", loc+".pattern", "", "variable %s: %v", variable.Name, err)
+				continue
+			}
+		}
+
+		switch {
+		case def == "":
+		case variable.Type == "secret":
+			c.add("error", "secret_default", loc+".default_value", "", "secret variable %s must not have a default value; store it as a secret global variable", variable.Name)
+		default:
+			if err := checkVariableValue(variable, def); err != nil {
+				c.add("error", "type_mismatch", loc+".default_value", "", "default of variable %s: %v", variable.Name, err)
+			}
+		}
+	}
+}
+
+// collectSteps indexes step IDs and declared outputs before steps are checked,
+// so references may point forward
+func (c *workflowCheck) collectSteps(steps []models.Step, prefix string, top bool) {
+	for i, step := range steps {
+		loc := fmt.Sprintf("%s[%d]", prefix, i)
+		if strings.ContainsAny(step.ID, `/\`) || strings.Contains(step.ID, "..") {
+			// Step IDs name artifact directories
+			c.add("error", "invalid_step_id", loc+".id", step.ID, "step id %s must not contain path separators or ..", step.ID)
+		}
+		if step.ID == "" {
+			c.add("error", "missing_step_id", loc+".id", "", "step id is required")
+		} else if previous, ok := c.stepIDs[step.ID]; ok {
+			c.add("error", "duplicate_step_id", loc+".id", step.ID, "step id %s is already used at %s", step.ID, previous)
+		} else {
+			c.stepIDs[step.ID] = loc
+			if top {
+				c.topLevel[step.ID] = i
+			}
+		}
+
+		for _, out := range step.Outputs {
+			if out.Name != "" {
+				c.declared[out.Name] = true
+			}
+		}
+		switch step.Type {
+		case "foreach":
+			c.declared[loopVariable(step)] = true
+			c.declared[loopIndexVariable] = true
+		case "matrix":
+			for name := range step.Matrix {
+				c.declared[name] = true
+			}
+			c.declared[loopIndexVariable] = true
+		}
+		c.collectSteps(step.Steps, loc+".steps", false)
+	}
+}
+
+// checkStep validates a step and its children. nested is set for children of
+// parallel and loop steps.
+func (c *workflowCheck) checkStep(step models.Step, loc string, nested bool) {
+	switch step.Type {
+	case "command":
+		if strings.TrimSpace(step.Content) == "" {
+			c.add("error", "empty_command", loc+".content", step.ID, "command step has no command")
+		}
+		c.findPlaceholders(step.Content, loc+".content", step)
+	case "workflow_ref":
+		if step.Content == "" {
+			c.add("error", "missing_workflow", loc+".content", step.ID, "workflow_ref step has no workflow id")
+		} else if ref, err := c.lookup(step.Content); err != nil {
+			c.add("error", "unknown_workflow", loc+".content", step.ID, "referenced workflow %s does not exist", step.Content)
+		} else {
+			c.checkInputs(step, ref, loc)
+		}
+		for key, value := range step.With {
+			c.findPlaceholders(value, loc+".with."+key, step)
+		}
+	case "parallel":
+		if len(step.Steps) == 0 {
+			c.add("error", "empty_parallel", loc+".steps", step.ID, "parallel step has no child steps")
+		}
+		c.checkGroup(step, loc)
+	case "foreach", "matrix":
+		if len(step.Steps) == 0 {
+			c.add("error", "empty_loop", loc+".steps", step.ID, "%s step has no child steps", step.Type)
+		}
+		c.checkLoop(step, loc)
+		c.checkGroup(step, loc)
+	case "approval":
+		if err := ValidateApprovalConfig(step.Approval); err != nil {
+			c.add("error", "invalid_approval", loc+".approval", step.ID, "%v", err)
+		}
+		if step.Approval != nil {
+			c.findPlaceholders(step.Approval.Message, loc+".approval.message", step)
+		}
+	case "":
+		c.add("error", "missing_step_type", loc+".type", step.ID, "step type is required")
+	default:
+		if !IsNativeStep(step.Type) {
+			c.add("error", "unknown_step_type", loc+".type", step.ID, "unknown step type %q", step.Type)
+			break
+		}
+		missing, unknown := checkNativeParams(step.Type, step.With)
+		for _, key := range missing {
+			c.add("error", "missing_parameter", loc+".with."+key, step.ID, "%s step needs with.%s", step.Type, key)
+		}
+		for _, key := range unknown {
+			c.add("warning", "unknown_parameter", loc+".with."+key, step.ID, "%s step does not use with.%s", step.Type, key)
+		}
+		for key, value := range step.With {
+			c.findPlaceholders(value, loc+".with."+key, step)
+		}
+	}
+	if len(step.With) > 0 && !IsNativeStep(step.Type) && step.Type != "workflow_ref" {
+		c.add("warning", "unused_parameters", loc+".with", step.ID, "with is only used by native and workflow_ref steps")
+	}
+
+	if step.Type != "parallel" && !IsLoopStep(step.Type) && len(step.Steps) > 0 {
+		c.add("warning", "unused_child_steps", loc+".steps", step.ID, "child steps are only run by parallel, foreach and matrix steps")
+	}
+	if !IsLoopStep(step.Type) && (len(step.Items) > 0 || len(step.Matrix) > 0 || step.As != "") {
+		c.add("warning", "unused_loop_values", loc, step.ID, "items, as and matrix are only used by foreach and matrix steps")
+	}
+
+	if err := validateStepPolicy(step); err != nil {
+		c.add("error", "invalid_policy", loc, step.ID, "%v", err)
+	}
+	if step.Retry != nil && maxAttempts(step.Retry) > 1 && !stepRetries(step.Type) {
+		c.add("warning", "ignored_retry", loc+".retry", step.ID, "%s steps are not retried", step.Type)
+	}
+	if step.ContinueOnError && step.OnFailure != nil {
+		c.add("warning", "ignored_continue_on_error", loc+".continue_on_error", step.ID, "on_failure takes precedence over continue_on_error")
+	}
+
+	for key, name := range step.Variables {
+		c.used[name] = true
+		if !c.declared[name] {
+			c.add("error", "undeclared_variable", fmt.Sprintf("%s.variables.%s", loc, key), step.ID, "variable %s is not declared", name)
+		}
+	}
+
+	for i, out := range step.Outputs {
+		c.checkOutput(out, fmt.Sprintf("%s.outputs[%d]", loc, i), step.ID)
+	}
+	for i, artifact := range step.Artifacts {
+		artifactLoc := fmt.Sprintf("%s.artifacts[%d]", loc, i)
+		if err := ValidateArtifactPattern(artifact); err != nil {
+			c.add("error", "invalid_artifact_path", artifactLoc, step.ID, "%v", err)
+			continue
+		}
+		c.findPlaceholders(artifact, artifactLoc, step)
+	}
+
+	if nested && (len(step.Conditions) > 0 || step.OnSuccess != nil || step.OnFailure != nil) {
+		c.add("warning", "ignored_flow_control", loc, step.ID, "conditions and on_success/on_failure are not evaluated inside parallel, foreach and matrix steps")
+	}
+	for i, cond := range step.Conditions {
+		c.checkCondition(cond, fmt.Sprintf("%s.conditions[%d]", loc, i), step.ID)
+	}
+	if step.OnSuccess != nil {
+		c.checkAction(*step.OnSuccess, loc+".on_success", step.ID, false)
+	}
+	if step.OnFailure != nil {
+		c.checkAction(*step.OnFailure, loc+".on_failure", step.ID, false)
+	}
+}
+
+// checkGroup validates the mode and concurrency of a parallel or loop step
+// and checks its children
+func (c *workflowCheck) checkGroup(step models.Step, loc string) {
+	switch step.Mode {
+	case "", ParallelModeWaitAll, ParallelModeFailFast:
+	default:
+		c.add("error", "invalid_mode", loc+".mode", step.ID, "unknown %s mode %q (expected wait_all or fail_fast)", step.Type, step.Mode)
+	}
+	if step.MaxConcurrency < 0 {
+		c.add("error", "invalid_concurrency", loc+".max_concurrency", step.ID, "max_concurrency must not be negative")
+	}
+	for i, child := range step.Steps {
+		c.checkStep(child, fmt.Sprintf("%s.steps[%d]", loc, i), true)
+	}
+}
+
+// checkLoop validates the values a foreach or matrix step iterates over
+func (c *workflowCheck) checkLoop(step models.Step, loc string) {
+	checkName := func(name, nameLoc string) {
+		if len(c.validator.templateParser.ExtractVariables("{"+name+"}")) == 0 {
+			c.add("error", "invalid_loop_variable", nameLoc, step.ID, "loop variable %q cannot be referenced as a {VARIABLE} placeholder", name)
+		}
+	}
+
+	if step.Type == "foreach" {
+		if len(step.Items) == 0 {
+			c.add("error", "empty_items", loc+".items", step.ID, "foreach step has no items")
+		}
+		if step.As != "" {
+			checkName(step.As, loc+".as")
+		}
+		for i, item := range step.Items {
+			c.findPlaceholders(item, fmt.Sprintf("%s.items[%d]", loc, i), step)
+		}
+		if len(step.Matrix) > 0 {
+			c.add("warning", "unused_loop_values", loc+".matrix", step.ID, "matrix is only used by matrix steps")
+		}
+		return
+	}
+
+	if len(step.Matrix) == 0 {
+		c.add("error", "empty_matrix", loc+".matrix", step.ID, "matrix step has no variables")
+	}
+	names := make([]string, 0, len(step.Matrix))
+	for name := range step.Matrix {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		checkName(name, loc+".matrix."+name)
+		if len(step.Matrix[name]) == 0 {
+			c.add("error", "empty_items", loc+".matrix."+name, step.ID, "matrix variable %s has no values", name)
+		}
+		for i, value := range step.Matrix[name] {
+			c.findPlaceholders(value, fmt.Sprintf("%s.matrix.%s[%d]", loc, name, i), step)
+		}
+	}
+	if len(step.Items) > 0 || step.As != "" {
+		c.add("warning", "unused_loop_values", loc+".items", step.ID, "items and as are only used by foreach steps")
+	}
+}
+
+func (c *workflowCheck) checkOutput(out models.StepOutput, loc, stepID string) {
+	if out.Name == "" {
+		c.add("error", "missing_output_name", loc+".name", stepID, "output name is required")
+	} else if len(c.validator.templateParser.ExtractVariables("{"+out.Name+"}")) == 0 {
+		c.add("warning", "output_not_placeholder", loc+".name", stepID, "output %s cannot be referenced as a {VARIABLE} placeholder", out.Name)
+	}
+
+	switch out.Type {
+	case "stdout", "exit_code", "json":
+	case "regex":
+		re, err := regexp.Compile(out.Pattern)
+		if err != nil {
+			c.add("error", "invalid_regex", loc+".pattern", stepID, "invalid pattern: %v", err)
+		} else if out.Group < 0 || out.Group > re.NumSubexp() {
+			c.add("error", "invalid_group", loc+".group", stepID, "pattern has no capture group %d", out.Group)
+		}
+	default:
+		c.add("error", "invalid_output_type", loc+".type", stepID, "unknown output type %q (expected regex, json, stdout or exit_code)", out.Type)
+	}
+}
+
+func (c *workflowCheck) checkCondition(cond models.Condition, loc, stepID string) {
+	switch cond.Type {
+	case "contains", "equals", "starts_with", "ends_with":
+	case "regex":
+		if _, err := regexp.Compile(cond.Value); err != nil {
+			c.add("error", "invalid_regex", loc+".value", stepID, "invalid pattern: %v", err)
+		}
+	case "exit_code":
+		if _, err := strconv.Atoi(cond.Value); err != nil {
+			c.add("error", "type_mismatch", loc+".value", stepID, "exit_code condition value %q is not an integer", cond.Value)
+		}
+	default:
+		c.add("error", "invalid_condition_type", loc+".type", stepID, "unknown condition type %q", cond.Type)
+	}
+	c.checkAction(cond.Action, loc+".action", stepID, true)
+}
+
+// checkAction validates a flow-control action and its target
+func (c *workflowCheck) checkAction(action models.StepAction, loc, stepID string, condition bool) {
+	switch action.Type {
+	case "continue", "stop":
+		return
+	case "jump_to":
+	case "execute_step":
+		if !condition {
+			c.add("warning", "ignored_action", loc+".type", stepID, "execute_step is only supported in conditions")
+			return
+		}
+	default:
+		c.add("error", "invalid_action", loc+".type", stepID, "unknown action type %q", action.Type)
+		return
+	}
+
+	if _, ok := c.topLevel[action.Target]; ok {
+		return
+	}
+	if _, ok := c.stepIDs[action.Target]; ok {
+		c.add("error", "unknown_target", loc+".target", stepID, "target %s is nested in a parallel step; only top-level steps can be targets", action.Target)
+		return
+	}
+	c.add("error", "unknown_target", loc+".target", stepID, "target step %q does not exist", action.Target)
+}
+
+// findPlaceholders records the {VAR} placeholders used in a template
+func (c *workflowCheck) findPlaceholders(template, loc string, step models.Step) {
+	placeholders, errs := c.validator.templateParser.placeholders(template)
+	for _, err := range errs {
+		c.add("error", "invalid_placeholder", loc, step.ID, "%v", err)
+	}
+	for _, p := range placeholders {
+		if p.Env {
+			continue
+		}
+		c.uses = append(c.uses, placeholderUse{name: p.Name, hasDefault: p.HasDefault, location: loc, stepID: step.ID, local: step.Variables})
+	}
+}
+
+// checkLocks checks the lock policy and the placeholders in lock names
+func (c *workflowCheck) checkLocks() {
+	if err := ValidateLockPolicy(c.workflow.LockPolicy); err != nil {
+		c.add("error", "invalid_lock_policy", "lock_policy", "", "%v", err)
+	}
+	for i, name := range c.workflow.Locks {
+		loc := fmt.Sprintf("locks[%d]", i)
+		if strings.TrimSpace(name) == "" {
+			c.add("error", "empty_lock", loc, "", "lock name is empty")
+			continue
+		}
+		c.findPlaceholders(name, loc, models.Step{})
+	}
+}
+
+// checkPlaceholders reports undeclared placeholders and unused variables
+func (c *workflowCheck) checkPlaceholders() {
+	for _, use := range c.uses {
+		if mapped, ok := use.local[use.name]; ok {
+			c.used[mapped] = true
+			continue
+		}
+		c.used[use.name] = true
+		if !c.declared[use.name] && !use.hasDefault {
+			c.add("error", "undeclared_variable", use.location, use.stepID, "placeholder {%s} has no declared variable or step output", use.name)
+		}
+	}
+
+	for i, variable := range c.workflow.Variables {
+		if variable.Name != "" && !c.used[variable.Name] {
+			c.add("warning", "unused_variable", fmt.Sprintf("variables[%d]", i), "", "variable %s is never used", variable.Name)
+		}
+	}
+}
+
+// checkGraph walks the top-level control flow to find unreachable steps and
+// jump loops that can never reach the end of the workflow
+func (c *workflowCheck) checkGraph() {
+	graph := newStepGraph(c.workflow.Steps)
+	reachable := graph.reachable()
+	finishes := graph.finishes()
+
+	for i, step := range c.workflow.Steps {
+		loc := fmt.Sprintf("steps[%d]", i)
+		switch {
+		case !reachable[i]:
+			c.add("warning", "unreachable_step", loc, step.ID, "step %s can never run", stepLabel(step))
+		case !finishes[i]:
+			c.add("error", "infinite_loop", loc, step.ID, "step %s can never finish: every path from it ends in a jump loop", stepLabel(step))
+		}
+	}
+}
+
+// checkInputs compares the inputs of a workflow_ref step with the variables
+// the referenced workflow declares. Required variables must be passed unless
+// they have a default or a global or environment value.
+func (c *workflowCheck) checkInputs(step models.Step, ref *models.Workflow, loc string) {
+	var known map[string]bool
+	if c.validator.variables != nil {
+		known = c.validator.variables.KnownNames()
+	}
+
+	declared := make(map[string]bool, len(ref.Variables))
+	for _, v := range ref.Variables {
+		declared[v.Name] = true
+		if !v.Required || v.DefaultValue != "" {
+			continue
+		}
+		if _, ok := step.With[v.Name]; ok {
+			continue
+		}
+		if known[v.Name] {
+			continue
+		}
+		c.add("error", "missing_input", loc+".with", step.ID, "workflow %s requires input %s", ref.ID, v.Name)
+	}
+
+	keys := make([]string, 0, len(step.With))
+	for key := range step.With {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !declared[key] {
+			c.add("warning", "unknown_input", loc+".with."+key, step.ID, "workflow %s does not declare variable %s", ref.ID, key)
+		}
+	}
+}
+
+// checkReferenceCycles reports workflow_ref chains that lead back to a
+// workflow already being executed
+func (c *workflowCheck) checkReferenceCycles() {
+	if c.workflow.ID == "" {
+		return
+	}
+
+	var walk func(id string, path []string, visited map[string]bool) []string
+	walk = func(id string, path []string, visited map[string]bool) []string {
+		for _, p := range path {
+			if p == id {
+				return append(path, id)
+			}
+		}
+		if visited[id] {
+			return nil
+		}
+		visited[id] = true
+
+		wf, err := c.lookup(id)
+		if err != nil {
+			return nil
+		}
+		for _, ref := range workflowRefs(wf.Steps) {
+			if cycle := walk(ref, append(path, id), visited); cycle != nil {
+				return cycle
+			}
+		}
+		return nil
+	}
+
+	c.forEachRef(c.workflow.Steps, "steps", func(step models.Step, loc string) {
+		if cycle := walk(step.Content, []string{c.workflow.ID}, make(map[string]bool)); cycle != nil {
+			c.add("error", "reference_cycle", loc+".content", step.ID, "workflow reference cycle: %s", strings.Join(cycle, " -> "))
+		}
+	})
+}
+
+// forEachRef calls fn for every workflow_ref step, including nested ones
+func (c *workflowCheck) forEachRef(steps []models.Step, prefix string, fn func(models.Step, string)) {
+	for i, step := range steps {
+		loc := fmt.Sprintf("%s[%d]", prefix, i)
+		if step.Type == "workflow_ref" && step.Content != "" {
+			fn(step, loc)
+		}
+		c.forEachRef(step.Steps, loc+".steps", fn)
+	}
+}
+
+// lookup resolves a workflow ID against the workflow being checked, the
+// pending batch and the store
+func (c *workflowCheck) lookup(id string) (*models.Workflow, error) {
+	if id == c.workflow.ID {
+		return &c.workflow, nil
+	}
+	if wf, ok := c.pending[id]; ok {
+		return wf, nil
+	}
+	return c.validator.store.Get(id)
+}
@@ -0,0 +1,352 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/devopstools/backend/internal/models"
+)
+
+// versionDir holds the saved versions of a workflow, one <n>.json per version
+func (s *WorkflowStore) versionDir(id string) string {
+	return filepath.Join(s.dataDir, "versions", id)
+}
+
+// versionNumbers lists the saved versions of a workflow in ascending order.
+// Callers hold s.mu.
+func (s *WorkflowStore) versionNumbers(id string) ([]int, error) {
+	entries, err := os.ReadDir(s.versionDir(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var numbers []int
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != ".json" {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimSuffix(name, ".json")); err == nil {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+// latestVersion returns the highest saved version, 0 if there is none.
+// Callers hold s.mu.
+func (s *WorkflowStore) latestVersion(id string) (int, error) {
+	numbers, err := s.versionNumbers(id)
+	if err != nil || len(numbers) == 0 {
+		return 0, err
+	}
+	return numbers[len(numbers)-1], nil
+}
+
+// writeVersion records a workflow as the given version. Callers hold s.mu.
+func (s *WorkflowStore) writeVersion(wf models.Workflow, version int, author, note string, createdAt time.Time) error {
+	if err := os.MkdirAll(s.versionDir(wf.ID), 0755); err != nil {
+		return fmt.Errorf("failed to create version directory: %w", err)
+	}
+
+	wf.Version = version
+	data, err := json.MarshalIndent(models.WorkflowVersion{
+		WorkflowID: wf.ID,
+		Version:    version,
+		Author:     author,
+		ChangeNote: note,
+		CreatedAt:  createdAt,
+		Workflow:   &wf,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.versionDir(wf.ID), fmt.Sprintf("%d.json", version)), data, 0644)
+}
+
+// readVersion loads a saved version. Callers hold s.mu.
+func (s *WorkflowStore) readVersion(id string, version int) (*models.WorkflowVersion, error) {
+	if id == "" || id != filepath.Base(id) {
+		return nil, ErrWorkflowNotFound
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.versionDir(id), fmt.Sprintf("%d.json", version)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("version %d of workflow %s not found", version, id)
+		}
+		return nil, err
+	}
+
+	var v models.WorkflowVersion
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// Versions lists the saved versions of a workflow, newest first, without
+// their content
+func (s *WorkflowStore) Versions(id string) ([]models.WorkflowVersion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	numbers, err := s.versionNumbers(id)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]models.WorkflowVersion, 0, len(numbers))
+	for i := len(numbers) - 1; i >= 0; i-- {
+		v, err := s.readVersion(id, numbers[i])
+		if err != nil {
+			return nil, err
+		}
+		v.Workflow = nil
+		versions = append(versions, *v)
+	}
+	return versions, nil
+}
+
+// GetVersion returns a saved version with its content
+func (s *WorkflowStore) GetVersion(id string, version int) (*models.WorkflowVersion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.readVersion(id, version)
+}
+
+// Restore saves the content of an earlier version as a new version. The
+// restored workflow is validated like an import.
+func (s *WorkflowStore) Restore(id string, version int, author, note string) (*models.Workflow, error) {
+	v, err := s.GetVersion(id, version)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.validator != nil {
+		if err := s.validator.ValidateAll([]models.Workflow{*v.Workflow}); err != nil {
+			return nil, err
+		}
+	}
+
+	if note == "" {
+		note = fmt.Sprintf("restored version %d", version)
+	}
+	return s.Save(*v.Workflow, author, note)
+}
+
+// Diff compares two versions of a workflow. A zero version means the
+// current one.
+func (s *WorkflowStore) Diff(id string, from, to int) (*models.WorkflowDiff, error) {
+	current, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if from == 0 {
+		from = current.Version
+	}
+	if to == 0 {
+		to = current.Version
+	}
+
+	older, err := s.GetVersion(id, from)
+	if err != nil {
+		return nil, err
+	}
+	newer, err := s.GetVersion(id, to)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := comparableContent(*older.Workflow)
+	if err != nil {
+		return nil, err
+	}
+	b, err := comparableContent(*newer.Workflow)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &models.WorkflowDiff{WorkflowID: id, From: from, To: to, Changes: []models.WorkflowChange{}}
+	diffValues("", a, b, &diff.Changes)
+	return diff, nil
+}
+
+// sameContent reports whether two workflows differ only in bookkeeping fields
+func sameContent(a, b models.Workflow) bool {
+	ca, errA := comparableContent(a)
+	cb, errB := comparableContent(b)
+	return errA == nil && errB == nil && reflect.DeepEqual(ca, cb)
+}
+
+// comparableContent converts a workflow to generic JSON values without the
+// fields the store maintains
+func comparableContent(wf models.Workflow) (interface{}, error) {
+	wf.Version = 0
+	wf.CreatedAt = time.Time{}
+	wf.UpdatedAt = time.Time{}
+
+	data, err := json.Marshal(wf)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	err = json.Unmarshal(data, &v)
+	return v, err
+}
+
+// diffValues appends the differences between two JSON values. Lists of
+// objects with unique IDs, such as steps, are matched by ID rather than
+// position so inserting a step does not report every following step.
+func diffValues(path string, a, b interface{}, changes *[]models.WorkflowChange) {
+	if reflect.DeepEqual(a, b) {
+		return
+	}
+
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make(map[string]bool)
+		for k := range av {
+			keys[k] = true
+		}
+		for k := range bv {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+
+		for _, k := range sorted {
+			child := k
+			if path != "" {
+				child = path + "." + k
+			}
+			before, inA := av[k]
+			after, inB := bv[k]
+			switch {
+			case !inA || before == nil && after != nil:
+				*changes = append(*changes, models.WorkflowChange{Path: child, Op: "added", New: after})
+			case !inB || after == nil && before != nil:
+				*changes = append(*changes, models.WorkflowChange{Path: child, Op: "removed", Old: before})
+			default:
+				diffValues(child, before, after, changes)
+			}
+		}
+		return
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		aIDs, aOK := idIndex(av)
+		bIDs, bOK := idIndex(bv)
+		if aOK && bOK {
+			for _, item := range av {
+				id := item.(map[string]interface{})["id"].(string)
+				child := fmt.Sprintf("%s[id=%s]", path, id)
+				if j, ok := bIDs[id]; ok {
+					diffValues(child, item, bv[j], changes)
+				} else {
+					*changes = append(*changes, models.WorkflowChange{Path: child, Op: "removed", Old: item})
+				}
+			}
+			for _, item := range bv {
+				id := item.(map[string]interface{})["id"].(string)
+				if _, ok := aIDs[id]; !ok {
+					*changes = append(*changes, models.WorkflowChange{Path: fmt.Sprintf("%s[id=%s]", path, id), Op: "added", New: item})
+				}
+			}
+			if !sameOrder(av, bv) {
+				*changes = append(*changes, models.WorkflowChange{Path: path, Op: "reordered", Old: idList(av), New: idList(bv)})
+			}
+			return
+		}
+
+		for i := 0; i < len(av) || i < len(bv); i++ {
+			child := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(av):
+				*changes = append(*changes, models.WorkflowChange{Path: child, Op: "added", New: bv[i]})
+			case i >= len(bv):
+				*changes = append(*changes, models.WorkflowChange{Path: child, Op: "removed", Old: av[i]})
+			default:
+				diffValues(child, av[i], bv[i], changes)
+			}
+		}
+		return
+	}
+
+	*changes = append(*changes, models.WorkflowChange{Path: path, Op: "changed", Old: a, New: b})
+}
+
+// idIndex maps the "id" of each object in a list to its position. It fails
+// unless every item is an object with a unique, non-empty ID.
+func idIndex(items []interface{}) (map[string]int, bool) {
+	index := make(map[string]int, len(items))
+	for i, item := range items {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		id, ok := obj["id"].(string)
+		if !ok || id == "" {
+			return nil, false
+		}
+		if _, dup := index[id]; dup {
+			return nil, false
+		}
+		index[id] = i
+	}
+	return index, true
+}
+
+func idList(items []interface{}) []string {
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.(map[string]interface{})["id"].(string))
+	}
+	return ids
+}
+
+// sameOrder reports whether the IDs both lists share appear in the same order
+func sameOrder(a, b []interface{}) bool {
+	inB := make(map[string]bool)
+	for _, id := range idList(b) {
+		inB[id] = true
+	}
+	inA := make(map[string]bool)
+	for _, id := range idList(a) {
+		inA[id] = true
+	}
+
+	var x, y []string
+	for _, id := range idList(a) {
+		if inB[id] {
+			x = append(x, id)
+		}
+	}
+	for _, id := range idList(b) {
+		if inA[id] {
+			y = append(y, id)
+		}
+	}
+	return reflect.DeepEqual(x, y)
+}
@@ -0,0 +1,270 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/devopstools/backend/internal/models"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// workflowBundleVersion is the bundle format written by exports
+const workflowBundleVersion = 1
+
+var ErrWorkflowExists = errors.New("workflow already exists")
+
+// MarshalYAML encodes workflows or bundles in the readable YAML format
+func MarshalYAML(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ParseWorkflows decodes one or more YAML (or JSON) documents, each holding a
+// single workflow or a bundle. Unknown fields are rejected so typos in
+// hand-written files surface instead of being silently dropped.
+func ParseWorkflows(data []byte) ([]models.Workflow, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+
+	var workflows []models.Workflow
+	for doc := 1; ; doc++ {
+		var node yaml.Node
+		if err := decoder.Decode(&node); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("document %d: %w", doc, err)
+		}
+		if len(node.Content) == 0 {
+			continue
+		}
+
+		raw, err := yaml.Marshal(&node)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", doc, err)
+		}
+		strict := yaml.NewDecoder(bytes.NewReader(raw))
+		strict.KnownFields(true)
+
+		if isBundleNode(node.Content[0]) {
+			var bundle models.WorkflowBundle
+			if err := strict.Decode(&bundle); err != nil {
+				return nil, fmt.Errorf("document %d: %w", doc, err)
+			}
+			if bundle.Version > workflowBundleVersion {
+				return nil, fmt.Errorf("document %d: unsupported bundle version %d", doc, bundle.Version)
+			}
+			workflows = append(workflows, bundle.Workflows...)
+			continue
+		}
+
+		var wf models.Workflow
+		if err := strict.Decode(&wf); err != nil {
+			return nil, fmt.Errorf("document %d: %w", doc, err)
+		}
+		workflows = append(workflows, wf)
+	}
+
+	if len(workflows) == 0 {
+		return nil, fmt.Errorf("no workflows found")
+	}
+	return workflows, nil
+}
+
+// isBundleNode reports whether a mapping node is a bundle rather than a workflow
+func isBundleNode(node *yaml.Node) bool {
+	if node.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == "workflows" {
+			return true
+		}
+	}
+	return false
+}
+
+// ExportBundle collects the given workflows together with every workflow
+// they reference through workflow_ref steps
+func (s *WorkflowStore) ExportBundle(ids []string) (*models.WorkflowBundle, error) {
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no workflows selected")
+	}
+
+	bundle := &models.WorkflowBundle{
+		Version:    workflowBundleVersion,
+		ExportedAt: time.Now(),
+	}
+
+	seen := make(map[string]bool)
+	pending := append([]string(nil), ids...)
+	for len(pending) > 0 {
+		id := pending[0]
+		pending = pending[1:]
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		wf, err := s.Get(id)
+		if err != nil {
+			return nil, fmt.Errorf("workflow %s: %w", id, err)
+		}
+		bundle.Workflows = append(bundle.Workflows, *wf)
+		pending = append(pending, workflowRefs(wf.Steps)...)
+	}
+
+	return bundle, nil
+}
+
+// Import saves workflows, typically parsed from YAML. Workflows without an ID
+// get one. The whole set is checked first: IDs must be unique, existing
+// workflows are only replaced when overwrite is set, and every workflow_ref
+// must resolve to a workflow in the set or already stored. Each saved
+// workflow gets a new version recorded with the author and change note.
+func (s *WorkflowStore) Import(workflows []models.Workflow, overwrite bool, author, note string) ([]models.Workflow, error) {
+	if len(workflows) == 0 {
+		return nil, fmt.Errorf("no workflows to import")
+	}
+
+	ids := make(map[string]bool)
+	for i := range workflows {
+		wf := &workflows[i]
+		if wf.ID == "" {
+			wf.ID = uuid.New().String()
+		}
+		if wf.ID != filepath.Base(wf.ID) || strings.HasPrefix(wf.ID, ".") {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidWorkflowID, wf.ID)
+		}
+		if wf.Name == "" {
+			return nil, fmt.Errorf("workflow %s: name is required", wf.ID)
+		}
+		if ids[wf.ID] {
+			return nil, fmt.Errorf("duplicate workflow id: %s", wf.ID)
+		}
+		ids[wf.ID] = true
+
+		if !overwrite {
+			if _, err := s.Get(wf.ID); err == nil {
+				return nil, fmt.Errorf("%w: %s", ErrWorkflowExists, wf.ID)
+			}
+		}
+	}
+
+	var missing []string
+	for _, wf := range workflows {
+		for _, ref := range workflowRefs(wf.Steps) {
+			if ids[ref] {
+				continue
+			}
+			if _, err := s.Get(ref); err != nil {
+				missing = append(missing, fmt.Sprintf("%s (referenced by %s)", ref, wf.ID))
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing workflow dependencies: %s", strings.Join(missing, ", "))
+	}
+
+	if s.validator != nil {
+		if err := s.validator.ValidateAll(workflows); err != nil {
+			return nil, err
+		}
+	}
+
+	saved := make([]models.Workflow, 0, len(workflows))
+	for _, wf := range workflows {
+		stored, err := s.Save(wf, author, note)
+		if err != nil {
+			return nil, fmt.Errorf("workflow %s: %w", wf.ID, err)
+		}
+		saved = append(saved, *stored)
+	}
+
+	return saved, nil
+}
+
+// LoadDirectory imports every .yaml/.yml file below dir, replacing stored
+// workflows with the same ID. A file holding a single workflow without an ID
+// uses the file name as its ID, so reloading a checked-out directory updates
+// the same workflows.
+func (s *WorkflowStore) LoadDirectory(dir string) ([]models.Workflow, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir // .git and friends
+			}
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var workflows []models.Workflow
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := ParseWorkflows(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for i := range parsed {
+			if parsed[i].ID != "" {
+				continue
+			}
+			if len(parsed) > 1 {
+				return nil, fmt.Errorf("%s: workflow %d needs an id in a file with several workflows", path, i+1)
+			}
+			name := filepath.Base(path)
+			parsed[i].ID = strings.TrimSuffix(name, filepath.Ext(name))
+		}
+		workflows = append(workflows, parsed...)
+	}
+
+	if len(workflows) == 0 {
+		return nil, fmt.Errorf("no workflow files found in %s", dir)
+	}
+	return s.Import(workflows, true, "system", "loaded from "+dir)
+}
+
+// workflowRefs lists the workflows referenced by steps, including steps
+// nested in parallel groups
+func workflowRefs(steps []models.Step) []string {
+	var refs []string
+	for _, step := range steps {
+		if step.Type == "workflow_ref" && step.Content != "" {
+			refs = append(refs, step.Content)
+		}
+		refs = append(refs, workflowRefs(step.Steps)...)
+	}
+	return refs
+}